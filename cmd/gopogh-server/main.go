@@ -2,52 +2,213 @@
 package main
 
 import (
+	"context"
 	_ "embed"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/medyagh/gopogh/pkg/analysis"
 	"github.com/medyagh/gopogh/pkg/db"
 	"github.com/medyagh/gopogh/pkg/handler"
+	"github.com/medyagh/gopogh/pkg/metrics"
+	"github.com/medyagh/gopogh/pkg/notify"
+	"github.com/medyagh/gopogh/pkg/store"
 )
 
 var dbPath = flag.String("db_path", "", "path to postgres db in the form of 'user=DB_USER dbname=DB_NAME password=DB_PASS'")
 var dbHost = flag.String("db_host", "", "host of the db")
 var useCloudSQL = flag.Bool("use_cloudsql", false, "whether the database is a cloudsql db")
 var useIAMAuth = flag.Bool("use_iam_auth", false, "whether to use IAM to authenticate with the cloudsql db")
+var tokensFile = flag.String("tokens_file", "", "path to the file of bearer tokens and their scopes (read, ingest, admin) allowed to use the protected endpoints, reloaded on SIGHUP")
+var reportDir = flag.String("report_dir", "", "directory to store the HTML reports of the ingested runs in, reports are not stored if empty")
+var reportFallbackURL = flag.String("report_fallback_url", "https://storage.googleapis.com/minikube-builds/logs/master/{commit}/{env}.html", "url to redirect to for reports that are not stored, {env} and {commit} are replaced by the requested values")
+var suggestionsInterval = flag.Duration("suggestions_interval", 7*24*time.Hour, "how often the quarantine suggestions are generated, 0 to never generate them")
+var suggestionsDays = flag.Int("suggestions_days", analysis.SuggestionDays, "number of days of runs the quarantine suggestions are found from")
+var suggestionsFlipRate = flag.Float64("suggestions_flip_rate", float64(analysis.DefaultSuggestionParams.MinFlipRate), "percentage of consecutive runs with different results above which a test is suggested as flaky")
+var suggestionsMinRuns = flag.Int("suggestions_min_runs", analysis.DefaultSuggestionParams.MinRuns, "number of runs a test needs in the window to be suggested, at least 2 to compare consecutive runs")
+var suggestionsFailingStreak = flag.Int("suggestions_failing_streak", analysis.DefaultSuggestionParams.FailingStreak, "number of last runs that must have failed for a test to be suggested as failing")
+var webhooksFile = flag.String("webhooks_file", "", "path to the json file of the webhooks notified of new flakes and regressions of the ingested runs, reloaded on SIGHUP")
+var metricsInterval = flag.Duration("metrics_interval", 5*time.Minute, "how often the environment gauges of /metrics are refreshed from the database, 0 to never refresh them")
+var metricsFlakeRate = flag.Float64("metrics_flake_rate", 20, "flake percentage in the recent window from which a test counts in the gopogh_env_flaky_tests gauge")
+var cacheTTL = flag.Duration("cache_ttl", 10*time.Minute, "how long the responses of the dashboard endpoints are cached for at most, they are also dropped when a run is ingested, 0 to disable caching")
+var cacheEntries = flag.Int("cache_entries", 1000, "number of responses of the dashboard endpoints cached at most")
+var queryTimeout = flag.Duration("query_timeout", time.Minute, "how long each call to the database may take before it is canceled, not counting the time spent writing the rows it streams, 0 for no limit. The calls of a request are also canceled when its client disconnects")
+var maxUploadMB = flag.Int64("max_upload_mb", 64, "largest run accepted by POST /api/v1/runs in MiB once decompressed")
+var healthTimeout = flag.Duration("health_timeout", 2*time.Second, "how long /healthz and /readyz wait for the database")
+var requireReadAuth = flag.Bool("require_read_auth", false, "whether reading the dashboard data requires a token with the read scope")
 
 func main() {
 	flag.Parse()
+	if *maxUploadMB <= 0 {
+		log.Fatal("max_upload_mb must be positive")
+	}
+	if *suggestionsMinRuns < 2 {
+		log.Fatal("suggestions_min_runs must be at least 2")
+	}
+	if *suggestionsFailingStreak < 1 {
+		log.Fatal("suggestions_failing_streak must be positive")
+	}
 	flagValues := db.FlagValues{
-		Backend:     "postgres",
-		Host:        *dbHost,
-		Path:        *dbPath,
-		UseCloudSQL: *useCloudSQL,
-		UseIAMAuth:  *useIAMAuth,
+		Backend:      "postgres",
+		Host:         *dbHost,
+		Path:         *dbPath,
+		UseCloudSQL:  *useCloudSQL,
+		UseIAMAuth:   *useIAMAuth,
+		QueryTimeout: *queryTimeout,
 	}
 	datab, err := db.FromEnv(flagValues)
 	if err != nil {
 		log.Fatal(err)
 	}
+	datab = db.Instrument(datab)
+	// Create the tables and columns added since the database was created, the queries join the commits table
+	if err := datab.Initialize(context.Background()); err != nil {
+		log.Fatal(err)
+	}
 	db := handler.DB{
-		Database: datab,
+		Database:          datab,
+		ReportFallbackURL: *reportFallbackURL,
+		HealthTimeout:     *healthTimeout,
+		MaxUploadSize:     *maxUploadMB << 20,
+	}
+	if *reportDir != "" {
+		reports, err := store.NewLocal(*reportDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		db.Reports = reports
+	}
+
+	notifier, err := notify.New(*webhooksFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db.Notifier = notifier
+
+	auth, err := handler.NewAuth(*tokensFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if auth.Len() == 0 {
+		log.Printf("no tokens configured, write and admin endpoints will reject every request")
+	}
+	// Reload the tokens and webhooks on SIGHUP so they can be rotated without a restart
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := auth.Reload(); err != nil {
+				log.Printf("failed to reload tokens, keeping the previous ones: %v", err)
+			} else {
+				log.Printf("reloaded %d tokens", auth.Len())
+			}
+			if err := notifier.Reload(); err != nil {
+				log.Printf("failed to reload webhooks, keeping the previous ones: %v", err)
+			} else {
+				log.Printf("reloaded %d webhooks", notifier.Len())
+			}
+		}
+	}()
+
+	// Generate the quarantine suggestions periodically, the latest report of the report store is kept until it is due
+	if *suggestionsInterval > 0 {
+		params := analysis.SuggestionParams{
+			MinFlipRate:   float32(*suggestionsFlipRate),
+			MinRuns:       *suggestionsMinRuns,
+			FailingStreak: *suggestionsFailingStreak,
+		}
+		go func() {
+			for {
+				time.Sleep(db.SuggestionsDue(*suggestionsInterval, time.Now()))
+				if err := db.GenerateSuggestions(context.Background(), *suggestionsDays, params, time.Now()); err != nil {
+					log.Printf("failed to generate quarantine suggestions, retrying in an hour: %v", err)
+					time.Sleep(time.Hour)
+				}
+			}
+		}()
+	}
+
+	if *metricsInterval > 0 {
+		go func() {
+			for {
+				if err := db.RefreshMetrics(context.Background(), float32(*metricsFlakeRate), time.Now()); err != nil {
+					log.Printf("failed to refresh the metrics: %v", err)
+				}
+				time.Sleep(*metricsInterval)
+			}
+		}()
+	}
+
+	if *cacheTTL > 0 {
+		if *cacheEntries < 1 {
+			log.Fatal("cache_entries must be positive")
+		}
+		db.Cache = handler.NewResponseCache(*cacheTTL, *cacheEntries)
 	}
+
 	// Create an HTTP server and register the handlers
 
-	http.HandleFunc("/db", db.ServeEnvironmentTestsAndTestCases)
+	// The unversioned routes are kept for the dashboard and existing scripts, they serve the same data as /api/v1
+	for _, prefix := range []string{"", "/api/v1"} {
+		http.HandleFunc(prefix+"/db", auth.Optional(*requireReadAuth, handler.ScopeRead, db.ServeEnvironmentTestsAndTestCases))
+
+		http.HandleFunc(prefix+"/env", auth.Optional(*requireReadAuth, handler.ScopeRead, db.Cache.Wrap(db.ServeEnvCharts)))
+
+		http.HandleFunc(prefix+"/test", auth.Optional(*requireReadAuth, handler.ScopeRead, db.Cache.Wrap(db.ServeTestCharts)))
+
+		http.HandleFunc(prefix+"/summary", auth.Optional(*requireReadAuth, handler.ScopeRead, db.Cache.Wrap(db.ServeOverview)))
+
+		http.HandleFunc(prefix+"/version", handler.ServeGopoghVersion)
+	}
+
+	http.HandleFunc("/api/v1/envs", auth.Optional(*requireReadAuth, handler.ScopeRead, db.Cache.Wrap(db.ServeEnvs)))
+
+	http.HandleFunc("/api/v1/tests", auth.Optional(*requireReadAuth, handler.ScopeRead, db.Cache.Wrap(db.ServeTests)))
+
+	http.HandleFunc("/api/v1/tests/first_failure", auth.Optional(*requireReadAuth, handler.ScopeRead, db.ServeFirstFailure))
+
+	http.HandleFunc("/api/v1/commits", auth.Optional(*requireReadAuth, handler.ScopeRead, db.Cache.Wrap(db.ServeCommitHistory)))
+
+	http.HandleFunc("/api/v1/prs/{pr}", auth.Optional(*requireReadAuth, handler.ScopeRead, db.ServePRResults))
+
+	http.HandleFunc("GET /api/v1/quarantine", auth.Optional(*requireReadAuth, handler.ScopeRead, db.ServeQuarantine))
+
+	http.HandleFunc("POST /api/v1/quarantine", auth.Require(handler.ScopeAdmin, db.ServeAddQuarantine))
+
+	http.HandleFunc("DELETE /api/v1/quarantine", auth.Require(handler.ScopeAdmin, db.ServeDeleteQuarantine))
+
+	http.HandleFunc("/api/v1/suggestions", auth.Optional(*requireReadAuth, handler.ScopeRead, db.ServeSuggestions))
+
+	http.HandleFunc("/badge/env", auth.Optional(*requireReadAuth, handler.ScopeRead, db.ServeEnvBadge))
+
+	http.HandleFunc("/badge/test", auth.Optional(*requireReadAuth, handler.ScopeRead, db.ServeTestBadge))
+
+	http.HandleFunc("/feed.atom", auth.Optional(*requireReadAuth, handler.ScopeRead, db.Cache.Wrap(db.ServeFeed)))
+
+	http.HandleFunc("/report", auth.Optional(*requireReadAuth, handler.ScopeRead, db.ServeReport))
+
+	http.HandleFunc("POST /api/v1/runs", auth.Require(handler.ScopeIngest, db.ServeIngestRun))
+
+	http.HandleFunc("POST /api/v1/cache/refresh", auth.Require(handler.ScopeAdmin, db.ServeRefreshCache))
 
-	http.HandleFunc("/env", db.ServeEnvCharts)
+	http.HandleFunc("/api/v1/openapi.json", handler.ServeOpenAPI)
 
-	http.HandleFunc("/test", db.ServeTestCharts)
+	// The probes of the platform running the server have no token
+	http.HandleFunc("/healthz", db.ServeHealthz)
 
-	http.HandleFunc("/summary", db.ServeOverview)
+	http.HandleFunc("/readyz", db.ServeReadyz)
 
-	http.HandleFunc("/version", handler.ServeGopoghVersion)
+	http.HandleFunc("/metrics", auth.Optional(*requireReadAuth, handler.ScopeRead, metrics.Handler))
 
 	http.HandleFunc("/", handler.ServeHTML)
 
 	// Start the HTTP server
-	err = http.ListenAndServe(":8080", nil)
+	err = http.ListenAndServe(":8080", metrics.Instrument(http.DefaultServeMux))
 	if err != nil {
 		log.Fatalf("failed to start HTTP server: %v", err)
 	}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/analysis"
+	"github.com/medyagh/gopogh/pkg/db"
+)
+
+// bisect runs the bisect subcommand, printing the range of commits a test started failing in on an environment
+func bisect(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("bisect", flag.ExitOnError)
+	env := fs.String("env", "", "environment name")
+	test := fs.String("test", "", "test name")
+	branch := fs.String("branch", "", "only the commits of this branch, all branches if empty")
+	flagValues := dbFlags(fs)
+	_ = fs.Parse(args)
+
+	if *env == "" || *test == "" {
+		return fmt.Errorf("please provide the environment and test using -env and -test")
+	}
+	// bisect only reads the database, so it does not create or migrate the tables
+	database, err := db.FromEnv(flagValues())
+	if err != nil {
+		return err
+	}
+	window := db.DefaultWindow(time.Now())
+	ff, err := analysis.FindFirstFailure(ctx, database, *env, *test, *branch, window)
+	if err != nil {
+		return err
+	}
+	since := window.From.Format("2006-01-02")
+	if ff == nil || ff.TestedCommits == 0 {
+		return fmt.Errorf("%s has no results on %s since %s, the environment or test is unknown", *test, *env, since)
+	}
+	if !ff.Failing {
+		return fmt.Errorf("%s passed on the most recent of the %d commits it ran on %s since %s, there is no failure to bisect", *test, ff.TestedCommits, *env, since)
+	}
+	j, err := json.MarshalIndent(ff, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(j))
+	return nil
+}
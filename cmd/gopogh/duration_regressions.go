@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/analysis"
+	"github.com/medyagh/gopogh/pkg/db"
+	"github.com/medyagh/gopogh/pkg/report"
+)
+
+// durationRegressions runs the duration-regressions subcommand, comparing the durations of the passed tests of a json summary
+// with the baseline of the environment in the database
+func durationRegressions(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("duration-regressions", flag.ExitOnError)
+	summaryPath := fs.String("summary", "", "path to json summary produced by gopogh -out_summary")
+	name := fs.String("name", "", "environment name, defaults to the name in the summary")
+	flagValues := dbFlags(fs)
+	_ = fs.Parse(args)
+
+	if *summaryPath == "" {
+		return fmt.Errorf("please provide the path to the json summary using -summary")
+	}
+	data, err := os.ReadFile(*summaryPath)
+	if err != nil {
+		return err
+	}
+	var ss report.Summary
+	if err := json.Unmarshal(data, &ss); err != nil {
+		return fmt.Errorf("failed to parse summary: %v", err)
+	}
+	env := *name
+	if env == "" {
+		env = ss.Detail.Name
+	}
+	if env == "" {
+		return fmt.Errorf("please provide the environment name using -name")
+	}
+
+	database, err := db.FromEnv(flagValues())
+	if err != nil {
+		return err
+	}
+	// the summary is the recent run, so the baseline ends now
+	now := time.Now()
+	stats, err := database.GetDurationStats(ctx, env, now, now.Add(-analysis.BaselineWindow), now)
+	if err != nil {
+		return err
+	}
+	passed := map[string]bool{}
+	for _, t := range ss.PassedTests {
+		passed[t] = true
+	}
+	for i, s := range stats {
+		if d, ok := ss.Durations[s.TestName]; ok && passed[s.TestName] {
+			stats[i].RecentMedian = d
+			stats[i].RecentRuns = 1
+		}
+	}
+
+	j, err := json.MarshalIndent(analysis.SlowestGrowing(stats, len(stats)), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(j))
+	return nil
+}
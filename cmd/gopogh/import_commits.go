@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/db"
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// importCommits runs the import-commits subcommand, storing the commit order of a branch so runs are charted by commit rather than by test time.
+// The input is the output of: git log --first-parent --format='%H %P %cI' BRANCH
+func importCommits(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import-commits", flag.ExitOnError)
+	branch := fs.String("branch", "", "branch the commits were logged from")
+	in := fs.String("in", "", "path to the git log output, defaults to stdin")
+	flagValues := dbFlags(fs)
+	_ = fs.Parse(args)
+
+	if *branch == "" {
+		return fmt.Errorf("please provide the branch using -branch")
+	}
+	var r io.Reader = os.Stdin
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		r = f
+	}
+	commits, err := parseGitLog(r, *branch)
+	if err != nil {
+		return err
+	}
+
+	database, err := db.FromEnv(flagValues())
+	if err != nil {
+		return err
+	}
+	if err := database.Initialize(ctx); err != nil {
+		return err
+	}
+	if err := database.SetCommits(ctx, commits); err != nil {
+		return err
+	}
+	fmt.Printf("imported %d commits of %s\n", len(commits), *branch)
+	return nil
+}
+
+// parseGitLog parses lines of "SHA [PARENT_SHA...] COMMIT_TIME", keeping only the first parent of merge commits
+func parseGitLog(r io.Reader, branch string) ([]models.DBCommit, error) {
+	var commits []models.DBCommit
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid git log line: %q", scanner.Text())
+		}
+		commitTime, err := time.Parse(time.RFC3339, fields[len(fields)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid commit time in git log line %q: %v", scanner.Text(), err)
+		}
+		c := models.DBCommit{CommitID: fields[0], Branch: branch, CommitTime: commitTime}
+		if len(fields) > 2 {
+			c.ParentID = fields[1]
+		}
+		commits = append(commits, c)
+	}
+	return commits, scanner.Err()
+}
@@ -2,15 +2,21 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"time"
 
+	"github.com/medyagh/gopogh/pkg/analysis"
 	"github.com/medyagh/gopogh/pkg/db"
 	"github.com/medyagh/gopogh/pkg/models"
 	"github.com/medyagh/gopogh/pkg/parser"
 	"github.com/medyagh/gopogh/pkg/report"
+	"github.com/medyagh/gopogh/pkg/upload"
 )
 
 // Build includes commit sha date
@@ -22,18 +28,39 @@ var (
 	dbPath         = flag.String("db_path", "", "path to sql database/database file. if using postgres in the form of 'user=DB_USER dbname=DB_NAME password=DB_PASS'")
 	useCloudSQL    = flag.Bool("use_cloudsql", false, "whether the database is a cloudsql db")
 	useIAMAuth     = flag.Bool("use_iam_auth", false, "whether to use IAM to authenticate with the cloudsql db")
+	queryTimeout   = flag.Duration("query_timeout", 0, "how long each call to the database may take, 0 for no limit")
 	reportName     = flag.String("name", "", "report name")
 	reportPR       = flag.String("pr", "", "Pull request number")
 	reportDetails  = flag.String("details", "", "report details (for example test args...)")
 	reportRepo     = flag.String("repo", "", "source repo")
+	reportBranch   = flag.String("branch", "", "branch the commit was tested on")
+	commitTime     = flag.String("commit_time", "", "RFC3339 time of the commit (for example from git show -s --format=%cI), orders the run in the commit history")
 	inPath         = flag.String("in", "", "path to JSON file produced by go tool test2json")
 	outPath        = flag.String("out", "", "(deprecated use  -out_html instead) path to HTML output file")
 	outHTMLPath    = flag.String("out_html", "", "path to HTML output file")
 	outSummaryPath = flag.String("out_summary", "", "path to json summary output file")
+	uploadToken    = flag.String("upload_token", "", "bearer token with the ingest scope used with -upload_url, defaults to the GOPOGH_UPLOAD_TOKEN environment variable")
+	uploadURL      = flag.String("upload_url", "", "gopogh-server ingestion url (for example https://HOST/api/v1/runs) to post the results to instead of connecting to the database")
+	uploadTimeout  = flag.Duration("upload_timeout", upload.DefaultTimeout, "how long the upload to -upload_url may take before it fails, 0 for no limit")
+	exitOnFailure  = flag.Bool("exit_on_failure", false, "exit with code 1 when a test failed that is not quarantined")
 	version        = flag.Bool("version", false, "shows version")
 )
 
 func main() {
+	// interrupting gopogh cancels the queries it is running
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if len(os.Args) > 1 {
+		if subcommand, ok := subcommands[os.Args[1]]; ok {
+			if err := subcommand(ctx, os.Args[2:]); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	flag.Parse()
 	if *version {
 		fmt.Printf("Version %s Build %s\n", report.Version(), report.Build)
@@ -60,25 +87,64 @@ func main() {
 		os.Exit(1)
 	}
 	groups := parser.ProcessEvents(events)
-	r := models.ReportDetail{Name: *reportName, Details: *reportDetails, PR: *reportPR, RepoName: *reportRepo}
+	r := models.ReportDetail{Name: *reportName, Details: *reportDetails, PR: *reportPR, RepoName: *reportRepo, Branch: *reportBranch}
+	if *commitTime != "" {
+		t, err := time.Parse(time.RFC3339, *commitTime)
+		if err != nil {
+			fmt.Printf("invalid -commit_time: %v", err)
+			os.Exit(1)
+		}
+		r.CommitTime = t
+	}
 	c, err := report.Generate(r, groups)
 	if err != nil {
 		fmt.Printf("failed to generate report: %v", err)
 		os.Exit(1)
 	}
 
-	if dbVarProvided(*dbPath, *dbBackend, *dbHost) {
+	if *uploadURL != "" {
+		token := *uploadToken
+		if token == "" {
+			token = os.Getenv("GOPOGH_UPLOAD_TOKEN")
+		}
+		body, err := upload.Events(ctx, *uploadURL, token, r, *inPath, *uploadTimeout)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		// the server responds with the summary of the run, quarantined failures included
+		var ss report.Summary
+		if err := json.Unmarshal(body, &ss); err != nil {
+			fmt.Printf("failed to parse the upload response: %v\n", err)
+		}
+		c.Quarantined = ss.Quarantined
+	} else if dbVarProvided(*dbPath, *dbBackend, *dbHost) {
 		flagValues := db.FlagValues{
-			Backend:     *dbBackend,
-			Host:        *dbHost,
-			Path:        *dbPath,
-			UseCloudSQL: *useCloudSQL,
-			UseIAMAuth:  *useIAMAuth,
+			Backend:      *dbBackend,
+			Host:         *dbHost,
+			Path:         *dbPath,
+			UseCloudSQL:  *useCloudSQL,
+			UseIAMAuth:   *useIAMAuth,
+			QueryTimeout: *queryTimeout,
+		}
+		database, err := db.FromEnv(flagValues)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
-		if err := c.SQL(flagValues); err != nil {
+		if err := c.Store(ctx, database); err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
+		// the history only annotates the report, so failing to read it does not fail gopogh
+		c.FlakeHistory, err = analysis.FlakeHistories(ctx, database, r.Name, c.FailedTests(), r.Details, analysis.FlakeHistoryWindow(time.Now()))
+		if err != nil {
+			fmt.Printf("failed to read the flake history: %v\n", err)
+		}
+		c.Quarantined, err = analysis.FindQuarantined(ctx, database, r.Name, c.FailedTests(), time.Now())
+		if err != nil {
+			fmt.Printf("failed to read the quarantine: %v\n", err)
+		}
 	}
 
 	html, err := c.HTML()
@@ -110,6 +176,9 @@ func main() {
 		}
 		fmt.Println(string(j))
 	}
+	if *exitOnFailure && c.FailingTests() > 0 {
+		os.Exit(1)
+	}
 }
 
 // dbVarProvided checks whether any of the database flags/environment variables are set
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/medyagh/gopogh/pkg/db"
+)
+
+// dbFlags defines the database flags on the flag set of a subcommand, returning their values once parsed
+func dbFlags(fs *flag.FlagSet) func() db.FlagValues {
+	dbBackend := fs.String("db_backend", "", "sql database driver. 'sqlite' for file output")
+	dbHost := fs.String("db_host", "", "host of the db")
+	dbPath := fs.String("db_path", "", "path to sql database/database file. if using postgres in the form of 'user=DB_USER dbname=DB_NAME password=DB_PASS'")
+	useCloudSQL := fs.Bool("use_cloudsql", false, "whether the database is a cloudsql db")
+	useIAMAuth := fs.Bool("use_iam_auth", false, "whether to use IAM to authenticate with the cloudsql db")
+	queryTimeout := fs.Duration("query_timeout", 0, "how long each call to the database may take, 0 for no limit")
+	return func() db.FlagValues {
+		return db.FlagValues{
+			Backend:      *dbBackend,
+			Host:         *dbHost,
+			Path:         *dbPath,
+			UseCloudSQL:  *useCloudSQL,
+			UseIAMAuth:   *useIAMAuth,
+			QueryTimeout: *queryTimeout,
+		}
+	}
+}
+
+// subcommands are run instead of generating a report when given as the first argument
+var subcommands = map[string]func(ctx context.Context, args []string) error{
+	"bisect":               bisect,
+	"duration-regressions": durationRegressions,
+	"import-commits":       importCommits,
+}
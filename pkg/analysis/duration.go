@@ -0,0 +1,124 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// The duration of a test regresses when the median of its recent passing runs is far above the median of its baseline runs,
+// measured in median absolute deviations (MAD) of the baseline so tests with noisy durations need a larger change to be flagged.
+// Failing runs are left out, a failure may time out or end early so its duration says little about the test.
+//
+// The baseline is the BaselineWindow before the RecentWindow, so it rolls forward with the recent runs.
+const (
+	// RecentWindow is the window of the recent runs compared to the baseline
+	RecentWindow = 7 * 24 * time.Hour
+	// BaselineWindow is the window of the baseline runs before the recent window
+	BaselineWindow = 30 * 24 * time.Hour
+
+	minBaselineRuns = 5
+	// madScale scales the MAD to be comparable to a standard deviation of normally distributed durations
+	madScale = 1.4826
+	// minScore is the number of scaled MADs the recent median must be above the baseline median
+	minScore = 3.5
+	// minGrowth is the percentage the recent median must be above the baseline median
+	minGrowth = 20
+	// minIncrease in seconds ignores regressions of very short tests
+	minIncrease = 1
+)
+
+// DurationWindows returns the start of the recent and the baseline windows ending at now
+func DurationWindows(now time.Time) (recentSince time.Time, baselineSince time.Time) {
+	recentSince = now.Add(-RecentWindow)
+	return recentSince, recentSince.Add(-BaselineWindow)
+}
+
+// Median returns the median of the values, 0 if there are none
+func Median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
+
+// MAD returns the median absolute deviation of the values from their median
+func MAD(values []float64, median float64) float64 {
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - median)
+	}
+	return Median(deviations)
+}
+
+// DurationStatsOf summarizes the baseline and recent durations of a test
+func DurationStatsOf(env string, test string, baseline []float64, recent []float64) models.DurationStats {
+	median := Median(baseline)
+	return models.DurationStats{
+		EnvName:        env,
+		TestName:       test,
+		BaselineMedian: median,
+		BaselineMAD:    MAD(baseline, median),
+		BaselineRuns:   len(baseline),
+		RecentMedian:   Median(recent),
+		RecentRuns:     len(recent),
+	}
+}
+
+// DurationRegression returns the regression of the test, false if the recent duration did not regress
+func DurationRegression(s models.DurationStats) (models.DurationRegression, bool) {
+	if s.BaselineRuns < minBaselineRuns || s.RecentRuns == 0 || s.BaselineMedian <= 0 {
+		return models.DurationRegression{}, false
+	}
+	increase := s.RecentMedian - s.BaselineMedian
+	// a MAD of 0 (a test with constant duration) would make any change infinitely significant
+	spread := math.Max(madScale*s.BaselineMAD, 0.05*s.BaselineMedian)
+	score := increase / spread
+	growth := increase / s.BaselineMedian * 100
+	if increase < minIncrease || growth < minGrowth || score < minScore {
+		return models.DurationRegression{}, false
+	}
+	return models.DurationRegression{
+		EnvName:        s.EnvName,
+		TestName:       s.TestName,
+		BaselineMedian: round(s.BaselineMedian),
+		RecentMedian:   round(s.RecentMedian),
+		Growth:         round(growth),
+		Score:          round(score),
+		BaselineRuns:   s.BaselineRuns,
+		RecentRuns:     s.RecentRuns,
+	}, true
+}
+
+// SlowestGrowing returns the regressed tests ordered by the most growth first, at most limit of them
+func SlowestGrowing(stats []models.DurationStats, limit int) []models.DurationRegression {
+	regressions := []models.DurationRegression{}
+	for _, s := range stats {
+		if r, ok := DurationRegression(s); ok {
+			regressions = append(regressions, r)
+		}
+	}
+	sort.Slice(regressions, func(i, j int) bool {
+		if regressions[i].Growth != regressions[j].Growth {
+			return regressions[i].Growth > regressions[j].Growth
+		}
+		return regressions[i].Score > regressions[j].Score
+	})
+	if len(regressions) > limit {
+		regressions = regressions[:limit]
+	}
+	return regressions
+}
+
+// round rounds f to 2 decimal places like the percentages calculated by the database
+func round(f float64) float64 {
+	return math.Round(f*100) / 100
+}
@@ -0,0 +1,124 @@
+package analysis
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+func TestMedianAndMAD(t *testing.T) {
+	tests := []struct {
+		name       string
+		values     []float64
+		wantMedian float64
+		wantMAD    float64
+	}{
+		{"none", nil, 0, 0},
+		{"one", []float64{3}, 3, 0},
+		{"odd", []float64{5, 1, 3}, 3, 2},
+		{"even", []float64{4, 1, 2, 3}, 2.5, 1},
+		{"outlier", []float64{10, 10, 11, 9, 100}, 10, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			median := Median(tc.values)
+			if median != tc.wantMedian {
+				t.Errorf("Median(%v) = %v, want %v", tc.values, median, tc.wantMedian)
+			}
+			if mad := MAD(tc.values, median); mad != tc.wantMAD {
+				t.Errorf("MAD(%v, %v) = %v, want %v", tc.values, median, mad, tc.wantMAD)
+			}
+		})
+	}
+}
+
+func TestDurationStatsOf(t *testing.T) {
+	got := DurationStatsOf("env", "TestA", []float64{10, 12, 8, 10, 30}, []float64{20, 22})
+	want := models.DurationStats{EnvName: "env", TestName: "TestA", BaselineMedian: 10, BaselineMAD: 2, BaselineRuns: 5, RecentMedian: 21, RecentRuns: 2}
+	if got != want {
+		t.Errorf("DurationStatsOf() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDurationRegression(t *testing.T) {
+	stats := func(baselineMedian, baselineMAD float64, baselineRuns int, recentMedian float64, recentRuns int) models.DurationStats {
+		return models.DurationStats{EnvName: "env", TestName: "TestA", BaselineMedian: baselineMedian, BaselineMAD: baselineMAD,
+			BaselineRuns: baselineRuns, RecentMedian: recentMedian, RecentRuns: recentRuns}
+	}
+	tests := []struct {
+		name   string
+		stats  models.DurationStats
+		want   models.DurationRegression
+		wantOK bool
+	}{
+		{
+			name:   "regressed",
+			stats:  stats(10, 1, 5, 20, 3),
+			want:   models.DurationRegression{EnvName: "env", TestName: "TestA", BaselineMedian: 10, RecentMedian: 20, Growth: 100, Score: 6.74, BaselineRuns: 5, RecentRuns: 3},
+			wantOK: true,
+		},
+		{
+			name:   "constant duration",
+			stats:  stats(10, 0, 8, 12.5, 1),
+			want:   models.DurationRegression{EnvName: "env", TestName: "TestA", BaselineMedian: 10, RecentMedian: 12.5, Growth: 25, Score: 5, BaselineRuns: 8, RecentRuns: 1},
+			wantOK: true,
+		},
+		{name: "too few baseline runs", stats: stats(10, 1, 4, 20, 3)},
+		{name: "no recent runs", stats: stats(10, 1, 5, 0, 0)},
+		{name: "no baseline duration", stats: stats(0, 0, 5, 20, 3)},
+		{name: "noisy baseline", stats: stats(10, 3, 5, 20, 3)},
+		{name: "short test", stats: stats(0.5, 0, 5, 0.9, 3)},
+		{name: "small growth", stats: stats(100, 0, 5, 115, 3)},
+		{name: "faster", stats: stats(10, 1, 5, 5, 3)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := DurationRegression(tc.stats)
+			if ok != tc.wantOK || got != tc.want {
+				t.Errorf("DurationRegression(%+v) = %+v, %v, want %+v, %v", tc.stats, got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestSlowestGrowing(t *testing.T) {
+	stats := []models.DurationStats{
+		{TestName: "Stable", BaselineMedian: 10, BaselineMAD: 1, BaselineRuns: 5, RecentMedian: 10, RecentRuns: 3},
+		{TestName: "Doubled", BaselineMedian: 10, BaselineMAD: 1, BaselineRuns: 5, RecentMedian: 20, RecentRuns: 3},
+		{TestName: "DoubledSteady", BaselineMedian: 10, BaselineMAD: 0, BaselineRuns: 5, RecentMedian: 20, RecentRuns: 3},
+		{TestName: "Tripled", BaselineMedian: 10, BaselineMAD: 1, BaselineRuns: 5, RecentMedian: 30, RecentRuns: 3},
+	}
+	names := func(regressions []models.DurationRegression) []string {
+		got := []string{}
+		for _, r := range regressions {
+			got = append(got, r.TestName)
+		}
+		return got
+	}
+	tests := []struct {
+		limit int
+		want  []string
+	}{
+		{10, []string{"Tripled", "DoubledSteady", "Doubled"}},
+		{2, []string{"Tripled", "DoubledSteady"}},
+		{0, []string{}},
+	}
+	for _, tc := range tests {
+		if got := names(SlowestGrowing(stats, tc.limit)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("SlowestGrowing(limit %d) = %v, want %v", tc.limit, got, tc.want)
+		}
+	}
+}
+
+func TestDurationWindows(t *testing.T) {
+	now := time.Date(2026, 3, 31, 12, 0, 0, 0, time.UTC)
+	recentSince, baselineSince := DurationWindows(now)
+	if want := now.AddDate(0, 0, -7); !recentSince.Equal(want) {
+		t.Errorf("DurationWindows() recent since %v, want %v", recentSince, want)
+	}
+	if want := now.AddDate(0, 0, -37); !baselineSince.Equal(want) {
+		t.Errorf("DurationWindows() baseline since %v, want %v", baselineSince, want)
+	}
+}
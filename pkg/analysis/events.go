@@ -0,0 +1,142 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// The kinds of the events found in an ingested run
+const (
+	// EventFlakeRate is a failed test whose flake rate in the last FlakeHistoryDays days reached the threshold with the run
+	EventFlakeRate = "flake_rate"
+	// EventNewFailure is a failed test whose previous runs all passed
+	EventNewFailure = "new_failure"
+	// EventFailJump is a run with many more failures than the previous runs of the environment
+	EventFailJump = "fail_jump"
+)
+
+// EventParams are the thresholds of the events of a run, the json names are the ones of the webhooks file
+type EventParams struct {
+	FlakeRate     float32 `json:"flakeRate"`     // flake percentage a test must reach
+	MinRuns       int     `json:"minRuns"`       // number of runs a test needs for its flake rate to count
+	StableRuns    int     `json:"stableRuns"`    // number of previous runs that must have passed for a failure to be new
+	FailJumpRatio float64 `json:"failJumpRatio"` // ratio of the failures of the run to the median of the previous runs
+	FailJumpMin   int     `json:"failJumpMin"`   // number of failures the run must have above the median of the previous runs
+	BaselineRuns  int     `json:"baselineRuns"`  // number of previous runs of the environment the median is taken from
+	Branch        string  `json:"branch"`        // only runs of this branch or uploaded without a branch have new failures, every run outside of pull requests if empty
+}
+
+// DefaultEventParams are the thresholds of the events by default
+var DefaultEventParams = EventParams{FlakeRate: 20, MinRuns: 5, StableRuns: 10, FailJumpRatio: 2, FailJumpMin: 5, BaselineRuns: 10}
+
+// eventReader is the part of the database the events are found from
+type eventReader interface {
+	EachEnvironmentTest(ctx context.Context, f models.RowFilter, fn func(models.DBEnvironmentTest) error) error
+	EachTestCase(ctx context.Context, f models.RowFilter, fn func(models.DBTestCase) error) error
+}
+
+// RunEvents compares a stored run outside of pull requests with the previous runs of its environment up to FlakeLookbackDays days before now,
+// returning the flake rates its failed tests raised over the threshold, its failed tests that were stable and the jump of its number of failures
+func RunEvents(ctx context.Context, database eventReader, run models.DBEnvironmentTest, failed []string, p EventParams, now time.Time) ([]models.RunEvent, error) {
+	w := FlakeHistoryWindow(now)
+	var events []models.RunEvent
+	event := func(kind, test, message string, value, baseline float64) {
+		events = append(events, models.RunEvent{
+			Kind:     kind,
+			EnvName:  run.EnvName,
+			TestName: test,
+			CommitID: run.CommitID,
+			Message:  message,
+			Value:    value,
+			Baseline: baseline,
+			Time:     now,
+		})
+	}
+
+	// previous runs of the failed tests outside of pull requests, most recent first
+	previousRuns := map[string][]models.DBTestCase{}
+	if len(failed) > 0 {
+		err := database.EachTestCase(ctx, models.RowFilter{Env: run.EnvName, Tests: failed, NoPR: true, Window: w}, func(row models.DBTestCase) error {
+			if row.CommitID != run.CommitID && row.PR == "" && row.Result != "skip" {
+				previousRuns[row.TestName] = append(previousRuns[row.TestName], row)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the history of the failed tests: %v", err)
+		}
+	}
+
+	// runs uploaded without -branch are taken to be of the branch, pull requests are left out by the caller
+	onBranch := p.Branch == "" || run.Branch == "" || run.Branch == p.Branch
+	recentSince := now.AddDate(0, 0, -w.Days)
+	for _, test := range failed {
+		previous := previousRuns[test]
+		runs, fails := 0, 0
+		for _, r := range previous {
+			if r.TestTime.Before(recentSince) {
+				break
+			}
+			runs++
+			if r.Result == "fail" {
+				fails++
+			}
+		}
+		before := 0.0
+		if runs > 0 {
+			before = round(float64(fails) * 100 / float64(runs))
+		}
+		after := round(float64(fails+1) * 100 / float64(runs+1))
+		if runs+1 >= p.MinRuns && before < float64(p.FlakeRate) && after >= float64(p.FlakeRate) {
+			event(EventFlakeRate, test, fmt.Sprintf("%s on %s failed %.0f%% of the last %d days (%d of %d runs), up from %.0f%%",
+				test, run.EnvName, after, w.Days, fails+1, runs+1, before), after, before)
+		}
+
+		if onBranch && len(previous) >= p.StableRuns && p.StableRuns > 0 && allPassed(previous[:p.StableRuns]) {
+			event(EventNewFailure, test, fmt.Sprintf("%s on %s failed on %s after passing its last %d runs",
+				test, run.EnvName, ShortCommit(run.CommitID), p.StableRuns), 1, 0)
+		}
+	}
+
+	// the baseline is the previous runs outside of pull requests, the run itself may be one of the most recent ones
+	var baseline []float64
+	err := database.EachEnvironmentTest(ctx, models.RowFilter{Env: run.EnvName, NoPR: true, Window: w, Limit: p.BaselineRuns + 1}, func(row models.DBEnvironmentTest) error {
+		if row.CommitID != run.CommitID && len(baseline) < p.BaselineRuns {
+			baseline = append(baseline, float64(row.NumberOfFail))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the runs of %s: %v", run.EnvName, err)
+	}
+	if len(baseline) > 0 {
+		median := Median(baseline)
+		fails := float64(run.NumberOfFail)
+		if fails >= median*p.FailJumpRatio && fails-median >= float64(p.FailJumpMin) {
+			event(EventFailJump, "", fmt.Sprintf("%s had %d failures on %s, up from a median of %.0f in its last %d runs",
+				run.EnvName, run.NumberOfFail, ShortCommit(run.CommitID), median, len(baseline)), fails, median)
+		}
+	}
+	return events, nil
+}
+
+// allPassed checks whether every run passed
+func allPassed(runs []models.DBTestCase) bool {
+	for _, r := range runs {
+		if r.Result != "pass" {
+			return false
+		}
+	}
+	return true
+}
+
+// ShortCommit abbreviates a commit id like git does
+func ShortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
@@ -0,0 +1,108 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+func TestRunEvents(t *testing.T) {
+	now := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	run := models.DBEnvironmentTest{CommitID: "new", EnvName: "env", Branch: "master", TestTime: now, NumberOfFail: 12}
+	p := EventParams{FlakeRate: 20, MinRuns: 5, StableRuns: 3, FailJumpRatio: 2, FailJumpMin: 5, BaselineRuns: 3, Branch: "master"}
+
+	reader := &fakeReader{runs: []models.DBEnvironmentTest{run}, rows: []models.DBTestCase{
+		{CommitID: "new", EnvName: "env", TestName: "TestStable", Result: "fail", TestTime: now},
+		{CommitID: "new", EnvName: "env", TestName: "TestFlaky", Result: "fail", TestTime: now},
+	}}
+	for i := 1; i <= 6; i++ {
+		commit := fmt.Sprintf("c%d", i)
+		tt := now.Add(-time.Duration(i) * time.Hour)
+		reader.runs = append(reader.runs, models.DBEnvironmentTest{CommitID: commit, EnvName: "env", TestTime: tt, NumberOfFail: 1})
+		flaky := "pass"
+		if i == 2 {
+			flaky = "fail"
+		}
+		reader.rows = append(reader.rows,
+			models.DBTestCase{CommitID: commit, EnvName: "env", TestName: "TestStable", Result: "pass", TestTime: tt},
+			models.DBTestCase{CommitID: commit, EnvName: "env", TestName: "TestFlaky", Result: flaky, TestTime: tt})
+	}
+	// a pull request failing many tests is neither in the history of the tests nor in the baseline of the failures
+	prTime := now.Add(-30 * time.Minute)
+	reader.runs = append(reader.runs, models.DBEnvironmentTest{CommitID: "pr", EnvName: "env", TestTime: prTime, NumberOfFail: 40})
+	reader.rows = append(reader.rows,
+		models.DBTestCase{PR: "7", CommitID: "pr", EnvName: "env", TestName: "TestStable", Result: "fail", TestTime: prTime},
+		models.DBTestCase{PR: "7", CommitID: "pr", EnvName: "env", TestName: "TestFlaky", Result: "fail", TestTime: prTime})
+
+	events, err := RunEvents(context.Background(), reader, run, []string{"TestStable", "TestFlaky"}, p, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.queries != 2 {
+		t.Errorf("read the database %d times, want twice", reader.queries)
+	}
+	got := map[string]bool{}
+	for _, e := range events {
+		got[e.Kind+" "+e.TestName] = true
+	}
+	want := map[string]bool{
+		EventNewFailure + " TestStable": true,
+		EventFlakeRate + " TestFlaky":   true,
+		EventFailJump + " ":             true,
+	}
+	if len(got) != len(want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+	for k := range want {
+		if !got[k] {
+			t.Errorf("missing event %q, got %v", k, got)
+		}
+	}
+}
+
+func TestRunEventsBranch(t *testing.T) {
+	now := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		runBranch string
+		branch    string
+		want      bool
+	}{
+		{"uploaded without a branch", "", "master", true},
+		{"uploaded without a branch by default", "", DefaultEventParams.Branch, true},
+		{"on the branch", "master", "master", true},
+		{"on another branch", "feature", "master", false},
+		{"on any branch by default", "feature", DefaultEventParams.Branch, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			run := models.DBEnvironmentTest{CommitID: "new", EnvName: "env", Branch: tc.runBranch, TestTime: now, NumberOfFail: 1}
+			reader := &fakeReader{runs: []models.DBEnvironmentTest{run}, rows: []models.DBTestCase{
+				{CommitID: "new", EnvName: "env", TestName: "TestStable", Result: "fail", TestTime: now},
+			}}
+			for i := 1; i <= 3; i++ {
+				commit := fmt.Sprintf("c%d", i)
+				tt := now.Add(-time.Duration(i) * time.Hour)
+				reader.runs = append(reader.runs, models.DBEnvironmentTest{CommitID: commit, EnvName: "env", TestTime: tt})
+				reader.rows = append(reader.rows, models.DBTestCase{CommitID: commit, EnvName: "env", TestName: "TestStable", Result: "pass", TestTime: tt})
+			}
+			p := DefaultEventParams
+			p.StableRuns = 3
+			p.Branch = tc.branch
+			events, err := RunEvents(context.Background(), reader, run, []string{"TestStable"}, p, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := false
+			for _, e := range events {
+				got = got || e.Kind == EventNewFailure
+			}
+			if got != tc.want {
+				t.Errorf("RunEvents() raised %s: %v, want %v, events %+v", EventNewFailure, got, tc.want, events)
+			}
+		})
+	}
+}
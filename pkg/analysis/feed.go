@@ -0,0 +1,163 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// runChangeReader is the part of the database the changes of the runs are found from
+type runChangeReader interface {
+	EachEnvironmentTest(ctx context.Context, f models.RowFilter, fn func(models.DBEnvironmentTest) error) error
+	EachTestCase(ctx context.Context, f models.RowFilter, fn func(models.DBTestCase) error) error
+}
+
+// RunChanges returns the limit most recent runs of the environment, or of every environment if env is empty, in the window,
+// each compared with the previous run of its environment in the window outside of pull requests, or of the same pull request as the run
+func RunChanges(ctx context.Context, database runChangeReader, env string, w models.Window, limit int) ([]models.RunChange, error) {
+	var runs []models.DBEnvironmentTest
+	if err := database.EachEnvironmentTest(ctx, models.RowFilter{Env: env, Window: w, Limit: limit}, func(row models.DBEnvironmentTest) error {
+		runs = append(runs, row)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to read the runs: %v", err)
+	}
+	results, err := readRunResults(ctx, database, runs, w)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]models.RunChange, 0, len(runs))
+	var unread []models.DBEnvironmentTest
+	for i, run := range runs {
+		current := results[keyOf(run)]
+		change := models.RunChange{Run: run, PR: current.pr}
+
+		// the runs are most recent first, so the previous run of the environment is the next one of the environment if the limit kept it
+		for _, r := range runs[i+1:] {
+			if r.EnvName == run.EnvName && comparesWith(current.pr, results[keyOf(r)].pr) {
+				previous := r
+				change.Previous = &previous
+				break
+			}
+		}
+		if change.Previous == nil {
+			change.Previous, err = previousRun(ctx, database, run, current.pr, w)
+			if err != nil {
+				return nil, err
+			}
+			if change.Previous != nil {
+				unread = append(unread, *change.Previous)
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	// the previous runs the limit left out are read at once too
+	previousResults, err := readRunResults(ctx, database, unread, w)
+	if err != nil {
+		return nil, err
+	}
+	for k, r := range previousResults {
+		results[k] = r
+	}
+	for i := range changes {
+		change := &changes[i]
+		if change.Previous == nil {
+			continue
+		}
+		current, previous := results[keyOf(change.Run)], results[keyOf(*change.Previous)]
+		for test, result := range current.results {
+			switch {
+			case result == "fail" && previous.results[test] != "fail":
+				change.NewFailures = append(change.NewFailures, test)
+			case result == "pass" && previous.results[test] == "fail":
+				change.Fixed = append(change.Fixed, test)
+			}
+		}
+		sort.Strings(change.NewFailures)
+		sort.Strings(change.Fixed)
+	}
+	return changes, nil
+}
+
+// comparesWith checks whether a run of the pull request pr, empty outside of pull requests, is compared with a previous run of previousPR:
+// the previous run must be outside of pull requests or of the same pull request
+func comparesWith(pr string, previousPR string) bool {
+	return previousPR == "" || previousPR == pr
+}
+
+// previousRun reads the run of the environment before the run in the window that it is compared with, nil if there is none
+func previousRun(ctx context.Context, database runChangeReader, run models.DBEnvironmentTest, pr string, w models.Window) (*models.DBEnvironmentTest, error) {
+	cursor := models.RowCursor{TestTime: run.TestTime, EnvName: run.EnvName, CommitID: run.CommitID}
+	filters := []models.RowFilter{{Env: run.EnvName, NoPR: true, Window: w, Limit: 1, After: &cursor}}
+	if pr != "" {
+		filters = append(filters, models.RowFilter{Env: run.EnvName, PR: pr, Window: w, Limit: 1, After: &cursor})
+	}
+	var previous *models.DBEnvironmentTest
+	for _, f := range filters {
+		if err := database.EachEnvironmentTest(ctx, f, func(row models.DBEnvironmentTest) error {
+			if previous == nil || row.TestTime.After(previous.TestTime) {
+				previous = &row
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("failed to read the run before %s on %s: %v", run.CommitID, run.EnvName, err)
+		}
+	}
+	return previous, nil
+}
+
+// runKey identifies a run by its environment and commit
+type runKey struct {
+	env    string
+	commit string
+}
+
+// keyOf returns the key of the run
+func keyOf(run models.DBEnvironmentTest) runKey {
+	return runKey{run.EnvName, run.CommitID}
+}
+
+// runResult is the pull request of a run and the result of each of its tests
+type runResult struct {
+	pr      string
+	results map[string]string
+}
+
+// readRunResults reads the pull request and the test results of the runs with a single query of the test cases of their commits
+func readRunResults(ctx context.Context, database runChangeReader, runs []models.DBEnvironmentTest, w models.Window) (map[runKey]runResult, error) {
+	results := map[runKey]runResult{}
+	if len(runs) == 0 {
+		return results, nil
+	}
+	f := models.RowFilter{Env: runs[0].EnvName, Window: w}
+	seen := map[string]bool{}
+	for _, run := range runs {
+		results[keyOf(run)] = runResult{results: map[string]string{}}
+		if run.EnvName != f.Env {
+			f.Env = ""
+		}
+		if !seen[run.CommitID] {
+			seen[run.CommitID] = true
+			f.Commits = append(f.Commits, run.CommitID)
+		}
+	}
+	err := database.EachTestCase(ctx, f, func(row models.DBTestCase) error {
+		r, ok := results[runKey{row.EnvName, row.CommitID}]
+		if !ok {
+			// the commit also ran on an environment that is not compared
+			return nil
+		}
+		r.pr = row.PR
+		r.results[row.TestName] = row.Result
+		results[runKey{row.EnvName, row.CommitID}] = r
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the tests of the runs: %v", err)
+	}
+	return results, nil
+}
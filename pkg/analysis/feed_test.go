@@ -0,0 +1,80 @@
+package analysis
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+func TestRunChanges(t *testing.T) {
+	now := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	w := models.Window{From: now.AddDate(0, 0, -30), To: now.Add(time.Hour)}
+	reader := &fakeReader{}
+	// the runs of env, oldest first, with the pull request of each and the result of TestA and TestB
+	for i, r := range []struct {
+		commit, pr, a, b string
+	}{
+		{"c0", "", "fail", "pass"},
+		{"c1", "", "pass", "pass"},
+		{"p0", "7", "fail", "fail"},
+		{"p1", "7", "fail", "pass"},
+		{"c2", "", "pass", "fail"},
+	} {
+		tt := now.Add(time.Duration(i-5) * time.Hour)
+		reader.runs = append(reader.runs, models.DBEnvironmentTest{CommitID: r.commit, EnvName: "env", TestTime: tt})
+		reader.rows = append(reader.rows,
+			models.DBTestCase{PR: r.pr, CommitID: r.commit, EnvName: "env", TestName: "TestA", Result: r.a, TestTime: tt},
+			models.DBTestCase{PR: r.pr, CommitID: r.commit, EnvName: "env", TestName: "TestB", Result: r.b, TestTime: tt})
+	}
+
+	tests := []struct {
+		limit        int
+		wantPrevious []string
+		wantFailures [][]string
+		wantFixed    [][]string
+		// the tests of the listed runs and of the previous runs the limit left out are read with one query each,
+		// the previous runs the limit left out with one query per run, and one more for a run of a pull request
+		wantQueries int
+	}{
+		{
+			limit:        5,
+			wantPrevious: []string{"c1", "p0", "c1", "c0", ""},
+			wantFailures: [][]string{{"TestB"}, nil, {"TestA", "TestB"}, nil, nil},
+			wantFixed:    [][]string{nil, {"TestB"}, nil, {"TestA"}, nil},
+			wantQueries:  3,
+		},
+		{
+			limit:        2,
+			wantPrevious: []string{"c1", "p0"},
+			wantFailures: [][]string{{"TestB"}, nil},
+			wantFixed:    [][]string{nil, {"TestB"}},
+			wantQueries:  6,
+		},
+	}
+	for _, tc := range tests {
+		reader.queries = 0
+		changes, err := RunChanges(context.Background(), reader, "env", w, tc.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != tc.limit {
+			t.Fatalf("limit %d: got %d changes", tc.limit, len(changes))
+		}
+		for i, c := range changes {
+			previous := ""
+			if c.Previous != nil {
+				previous = c.Previous.CommitID
+			}
+			if previous != tc.wantPrevious[i] || !reflect.DeepEqual(c.NewFailures, tc.wantFailures[i]) || !reflect.DeepEqual(c.Fixed, tc.wantFixed[i]) {
+				t.Errorf("limit %d: %s compared with %q, new failures %v, fixed %v, want %q, %v and %v",
+					tc.limit, c.Run.CommitID, previous, c.NewFailures, c.Fixed, tc.wantPrevious[i], tc.wantFailures[i], tc.wantFixed[i])
+			}
+		}
+		if reader.queries != tc.wantQueries {
+			t.Errorf("limit %d: read the database %d times, want %d", tc.limit, reader.queries, tc.wantQueries)
+		}
+	}
+}
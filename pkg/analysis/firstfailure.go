@@ -0,0 +1,155 @@
+// Package analysis provides analyses of the stored test history
+package analysis
+
+import (
+	"context"
+	"math"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// historyLimit is the number of most recent runs of an environment the commit order is taken from
+const historyLimit = 1000
+
+// The first failure of a test is found by walking its history back in commit order from the most recent commit it ran on:
+//   - the failing streak is the commits with a failed run since the most recent commit where every run passed, the last good commit
+//   - the first bad commit is the oldest commit of the failing streak
+//   - the commits between the last good and the first bad commit that the test did not run on (or only skipped) are candidates too
+//
+// The confidence is the product of how stable the test passed before the last good commit (up to 10 passing commits),
+// how long it has been failing since (up to 5 failing commits) and the fraction of runs that failed in the failing streak,
+// so a flaky test that flips between results gets a low confidence.
+const (
+	stablePassTarget = 10
+	failingTarget    = 5
+)
+
+// historyReader is the part of the database the first failure is found from
+type historyReader interface {
+	GetTestHistory(ctx context.Context, env string, test string, branch string, w models.Window) (models.CommitResults, error)
+	GetCommitHistory(ctx context.Context, env string, branch string, limit int, w models.Window) (*models.CommitHistory, error)
+}
+
+// outcome is the aggregated result of the runs of a test on a commit
+type outcome struct {
+	commit string
+	passes int
+	fails  int
+}
+
+// FindFirstFailure reads the history of the test on the environment in the window and finds the range of commits it started failing in
+func FindFirstFailure(ctx context.Context, database historyReader, env string, test string, branch string, w models.Window) (*models.FirstFailure, error) {
+	history, err := database.GetTestHistory(ctx, env, test, branch, w)
+	if err != nil {
+		return nil, err
+	}
+	if history == nil {
+		return nil, nil
+	}
+	runs, err := database.GetCommitHistory(ctx, env, branch, historyLimit, w)
+	if err != nil {
+		return nil, err
+	}
+	if runs == nil {
+		return nil, nil
+	}
+	ff := FirstFailure(history, runs.Commits)
+	ff.EnvName = env
+	ff.TestName = test
+	ff.Branch = branch
+	return &ff, nil
+}
+
+// FirstFailure finds the range of commits a test started failing in
+// history is the results of the test oldest first and runs are the runs of the environment most recent first, as returned by the database
+func FirstFailure(history models.CommitResults, runs []models.DBEnvironmentTest) models.FirstFailure {
+	outcomes := commitOutcomes(history, runs)
+
+	ff := models.FirstFailure{}
+	for _, o := range outcomes {
+		if o.passes+o.fails > 0 {
+			ff.TestedCommits++
+		}
+	}
+	newest := len(outcomes) - 1
+	for newest >= 0 && outcomes[newest].passes+outcomes[newest].fails == 0 {
+		newest--
+	}
+	if newest < 0 || outcomes[newest].fails == 0 {
+		return ff
+	}
+	ff.Failing = true
+
+	failRuns, streakRuns := 0, 0
+	firstBad := newest
+	lastGood := -1
+	for i := newest; i >= 0; i-- {
+		o := outcomes[i]
+		if o.passes+o.fails == 0 {
+			continue
+		}
+		if o.fails == 0 {
+			lastGood = i
+			break
+		}
+		firstBad = i
+		ff.FailingCommits++
+		failRuns += o.fails
+		streakRuns += o.passes + o.fails
+	}
+	ff.FirstBadCommit = outcomes[firstBad].commit
+
+	if lastGood < 0 {
+		ff.Candidates = []string{ff.FirstBadCommit}
+		return ff
+	}
+	ff.LastGoodCommit = outcomes[lastGood].commit
+	for i := lastGood + 1; i <= firstBad; i++ {
+		ff.Candidates = append(ff.Candidates, outcomes[i].commit)
+	}
+	ff.UntestedCommits = len(ff.Candidates) - 1
+
+	for i := lastGood; i >= 0; i-- {
+		o := outcomes[i]
+		if o.passes+o.fails == 0 {
+			continue
+		}
+		if o.fails > 0 {
+			break
+		}
+		ff.StablePassCommits++
+	}
+
+	stability := math.Min(float64(ff.StablePassCommits), stablePassTarget) / stablePassTarget
+	persistence := math.Min(float64(ff.FailingCommits), failingTarget) / failingTarget
+	consistency := float64(failRuns) / float64(streakRuns)
+	ff.Confidence = float32(round(stability * persistence * consistency * 100))
+	return ff
+}
+
+// commitOutcomes aggregates the results of the test by commit in the commit order of the runs, oldest first
+// commits of the runs the test has no results on are kept as gaps, results of commits without a run are left out
+func commitOutcomes(history models.CommitResults, runs []models.DBEnvironmentTest) []outcome {
+	byCommit := map[string]*outcome{}
+	outcomes := make([]outcome, 0, len(runs))
+	for i := len(runs) - 1; i >= 0; i-- {
+		if _, ok := byCommit[runs[i].CommitID]; ok {
+			continue
+		}
+		outcomes = append(outcomes, outcome{commit: runs[i].CommitID})
+		byCommit[runs[i].CommitID] = &outcomes[len(outcomes)-1]
+	}
+	for _, r := range history {
+		o, ok := byCommit[r.Commit]
+		if !ok {
+			continue
+		}
+		switch r.Result {
+		case "pass":
+			o.passes++
+		case "fail":
+			o.fails++
+		}
+	}
+	return outcomes
+}
@@ -0,0 +1,106 @@
+package analysis
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// commitHistory returns the runs of commits c0, c1... most recent first and the results of the test on them oldest first,
+// results having a letter per run of the test on the commit: p for pass, f for fail and s for skip
+func commitHistory(results ...string) (models.CommitResults, []models.DBEnvironmentTest) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var history models.CommitResults
+	var runs []models.DBEnvironmentTest
+	for i, letters := range results {
+		commit := fmt.Sprintf("c%d", i)
+		runs = append([]models.DBEnvironmentTest{{CommitID: commit, EnvName: "env", TestTime: start.Add(time.Duration(i) * time.Hour)}}, runs...)
+		for _, l := range letters {
+			result := map[rune]string{'p': "pass", 'f': "fail", 's': "skip"}[l]
+			history = append(history, models.CommitResult{Commit: commit, Result: result})
+		}
+	}
+	return history, runs
+}
+
+func TestFirstFailure(t *testing.T) {
+	repeat := func(letters string, n int) []string {
+		results := make([]string, n)
+		for i := range results {
+			results[i] = letters
+		}
+		return results
+	}
+	tests := []struct {
+		name    string
+		results []string
+		want    models.FirstFailure
+	}{
+		{
+			name: "no history",
+			want: models.FirstFailure{},
+		},
+		{
+			name:    "passing on the most recent commit",
+			results: []string{"p", "f", "p"},
+			want:    models.FirstFailure{TestedCommits: 3},
+		},
+		{
+			name:    "commits the test did not run on are skipped",
+			results: []string{"p", "f", ""},
+			want: models.FirstFailure{TestedCommits: 2, Failing: true, FirstBadCommit: "c1", LastGoodCommit: "c0", Candidates: []string{"c1"},
+				FailingCommits: 1, StablePassCommits: 1, Confidence: 2},
+		},
+		{
+			name:    "untested commits are candidates",
+			results: []string{"p", "p", "", "s", "f", "f"},
+			want: models.FirstFailure{TestedCommits: 4, Failing: true, FirstBadCommit: "c4", LastGoodCommit: "c1", Candidates: []string{"c2", "c3", "c4"},
+				UntestedCommits: 2, FailingCommits: 2, StablePassCommits: 2, Confidence: 8},
+		},
+		{
+			name:    "failing on every commit",
+			results: []string{"f", "ff"},
+			want:    models.FirstFailure{TestedCommits: 2, Failing: true, FirstBadCommit: "c0", Candidates: []string{"c0"}, FailingCommits: 2},
+		},
+		{
+			name:    "stable passes stop at an older failure",
+			results: []string{"p", "f", "p", "p", "f"},
+			want: models.FirstFailure{TestedCommits: 5, Failing: true, FirstBadCommit: "c4", LastGoodCommit: "c3", Candidates: []string{"c4"},
+				FailingCommits: 1, StablePassCommits: 2, Confidence: 4},
+		},
+		{
+			name:    "stable and persistent failure",
+			results: append(repeat("p", 12), repeat("f", 6)...),
+			want: models.FirstFailure{TestedCommits: 18, Failing: true, FirstBadCommit: "c12", LastGoodCommit: "c11", Candidates: []string{"c12"},
+				FailingCommits: 6, StablePassCommits: 12, Confidence: 100},
+		},
+		{
+			name:    "flaky failing streak",
+			results: append(repeat("p", 10), "pf", "f", "fp", "f", "f"),
+			want: models.FirstFailure{TestedCommits: 15, Failing: true, FirstBadCommit: "c10", LastGoodCommit: "c9", Candidates: []string{"c10"},
+				FailingCommits: 5, StablePassCommits: 10, Confidence: 71.43},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			history, runs := commitHistory(tc.results...)
+			if got := FirstFailure(history, runs); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("FirstFailure() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFirstFailureIgnoresCommitsWithoutRun(t *testing.T) {
+	history, runs := commitHistory("p", "f")
+	// a result of a commit of another branch, which has no run on the environment
+	history = append(history, models.CommitResult{Commit: "other", Result: "pass"})
+	want := models.FirstFailure{TestedCommits: 2, Failing: true, FirstBadCommit: "c1", LastGoodCommit: "c0", Candidates: []string{"c1"},
+		FailingCommits: 1, StablePassCommits: 1, Confidence: 2}
+	if got := FirstFailure(history, runs); !reflect.DeepEqual(got, want) {
+		t.Errorf("FirstFailure() = %+v, want %+v", got, want)
+	}
+}
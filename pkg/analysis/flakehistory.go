@@ -0,0 +1,85 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+const (
+	// FlakeHistoryDays is the number of days of the flake percentage of a failed test in a report
+	FlakeHistoryDays = 15
+	// FlakeLookbackDays is the number of days a failed test is looked up for previous failures
+	FlakeLookbackDays = 30
+)
+
+// testCaseReader is the part of the database the flake history is read from
+type testCaseReader interface {
+	EachTestCase(ctx context.Context, f models.RowFilter, fn func(models.DBTestCase) error) error
+}
+
+// FlakeHistoryWindow returns the window of the flake history ending at now
+func FlakeHistoryWindow(now time.Time) models.Window {
+	return models.Window{From: now.AddDate(0, 0, -FlakeLookbackDays), To: now, Days: FlakeHistoryDays}
+}
+
+// FlakeHistories reads the history in the window of each test on the environment outside of pull requests, leaving out the runs of the reported commit
+func FlakeHistories(ctx context.Context, database testCaseReader, env string, tests []string, commit string, w models.Window) (map[string]models.FlakeHistory, error) {
+	histories := map[string]models.FlakeHistory{}
+	if len(tests) == 0 {
+		return histories, nil
+	}
+	rows := map[string][]models.DBTestCase{}
+	err := database.EachTestCase(ctx, models.RowFilter{Env: env, Tests: tests, NoPR: true, Window: w}, func(row models.DBTestCase) error {
+		if row.CommitID != commit && row.PR == "" {
+			rows[row.TestName] = append(rows[row.TestName], row)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the history of the failed tests: %v", err)
+	}
+	for _, test := range tests {
+		histories[test] = FlakeHistoryOf(rows[test], w)
+	}
+	return histories, nil
+}
+
+// FlakeHistoryOf summarizes the runs of a test in the window: its flake percentage in the last w.Days days and its failures in the whole window
+func FlakeHistoryOf(rows []models.DBTestCase, w models.Window) models.FlakeHistory {
+	recentSince := w.To.AddDate(0, 0, -w.Days)
+	h := models.FlakeHistory{Days: w.Days, LookbackDays: int(w.To.Sub(w.From).Round(24*time.Hour).Hours() / 24)}
+	fails, lookbackRuns := 0, 0
+	for _, r := range rows {
+		if r.Result == "skip" {
+			continue
+		}
+		lookbackRuns++
+		if r.Result == "fail" {
+			h.LookbackFailures++
+		}
+		if r.TestTime.Before(recentSince) {
+			continue
+		}
+		h.Runs++
+		if r.Result == "fail" {
+			fails++
+		}
+	}
+	if h.Runs > 0 {
+		h.FlakePercentage = float32(round(float64(fails) * 100 / float64(h.Runs)))
+	}
+	switch {
+	case lookbackRuns == 0:
+		h.Note = fmt.Sprintf("no other runs in the last %d days", h.LookbackDays)
+	case h.LookbackFailures == 0:
+		h.Note = fmt.Sprintf("first failure in %d days", h.LookbackDays)
+	case fails == 0:
+		h.Note = fmt.Sprintf("failed %d times in the last %d days, none in the last %d days", h.LookbackFailures, h.LookbackDays, h.Days)
+	default:
+		h.Note = fmt.Sprintf("failed %.0f%% of last %d days (%d of %d runs)", h.FlakePercentage, h.Days, fails, h.Runs)
+	}
+	return h
+}
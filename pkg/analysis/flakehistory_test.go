@@ -0,0 +1,129 @@
+package analysis
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// fakeReader serves the rows matching the filter like the database, most recent first, counting the queries
+type fakeReader struct {
+	rows    []models.DBTestCase
+	runs    []models.DBEnvironmentTest
+	queries int
+}
+
+// matches checks the filters of f on the columns of a row
+func matches(f models.RowFilter, env, commit, test, pr string) bool {
+	in := func(values []string, v string) bool {
+		for _, value := range values {
+			if value == v {
+				return true
+			}
+		}
+		return len(values) == 0
+	}
+	return (f.Env == "" || env == f.Env) && (f.Commit == "" || commit == f.Commit) && in(f.Commits, commit) &&
+		(f.Test == "" || test == f.Test) && in(f.Tests, test) && (!f.NoPR || pr == "") && (f.PR == "" || pr == f.PR)
+}
+
+func (f *fakeReader) EachTestCase(_ context.Context, filter models.RowFilter, fn func(models.DBTestCase) error) error {
+	f.queries++
+	rows := append([]models.DBTestCase(nil), f.rows...)
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].TestTime.After(rows[j].TestTime) })
+	sent := 0
+	for _, r := range rows {
+		if !matches(filter, r.EnvName, r.CommitID, r.TestName, r.PR) || (filter.Window.To.After(filter.Window.From) && (r.TestTime.Before(filter.Window.From) || !r.TestTime.Before(filter.Window.To))) {
+			continue
+		}
+		if filter.Limit > 0 && sent == filter.Limit {
+			break
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+		sent++
+	}
+	return nil
+}
+
+func (f *fakeReader) EachEnvironmentTest(_ context.Context, filter models.RowFilter, fn func(models.DBEnvironmentTest) error) error {
+	f.queries++
+	runs := append([]models.DBEnvironmentTest(nil), f.runs...)
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].TestTime.After(runs[j].TestTime) })
+	sent := 0
+	for _, r := range runs {
+		// a run is of the pull request of its test cases
+		pr := ""
+		for _, c := range f.rows {
+			if c.EnvName == r.EnvName && c.CommitID == r.CommitID && c.PR != "" {
+				pr = c.PR
+			}
+		}
+		if !matches(models.RowFilter{Env: filter.Env, Commit: filter.Commit, Commits: filter.Commits, PR: filter.PR, NoPR: filter.NoPR}, r.EnvName, r.CommitID, "", pr) {
+			continue
+		}
+		if filter.After != nil && !r.TestTime.Before(filter.After.TestTime) {
+			continue
+		}
+		if filter.Limit > 0 && sent == filter.Limit {
+			break
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+		sent++
+	}
+	return nil
+}
+
+func TestFlakeHistories(t *testing.T) {
+	now := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	w := FlakeHistoryWindow(now)
+	row := func(test, commit, result, pr string, daysAgo int) models.DBTestCase {
+		return models.DBTestCase{EnvName: "env", TestName: test, CommitID: commit, Result: result, PR: pr, TestTime: now.AddDate(0, 0, -daysAgo)}
+	}
+	reader := &fakeReader{rows: []models.DBTestCase{
+		row("TestA", "reported", "fail", "", 0),
+		row("TestA", "c1", "fail", "", 1),
+		row("TestA", "c2", "pass", "", 2),
+		row("TestA", "pr", "fail", "7", 3),
+		row("TestB", "c3", "fail", "", 20),
+		row("TestB", "c4", "pass", "", 1),
+		row("TestD", "c1", "fail", "", 1),
+	}}
+	histories, err := FlakeHistories(context.Background(), reader, "env", []string{"TestA", "TestB", "TestC"}, "reported", w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.queries != 1 {
+		t.Errorf("read the test cases %d times, want once", reader.queries)
+	}
+	tests := []struct {
+		test             string
+		wantRuns         int
+		wantFlake        float32
+		wantLookbackFail int
+	}{
+		{test: "TestA", wantRuns: 2, wantFlake: 50, wantLookbackFail: 1},
+		{test: "TestB", wantRuns: 1, wantFlake: 0, wantLookbackFail: 1},
+		{test: "TestC"},
+	}
+	if len(histories) != len(tests) {
+		t.Errorf("got the histories of %d tests, want %d", len(histories), len(tests))
+	}
+	for _, tc := range tests {
+		h, ok := histories[tc.test]
+		if !ok {
+			t.Errorf("no history of %s", tc.test)
+			continue
+		}
+		if h.Runs != tc.wantRuns || h.FlakePercentage != tc.wantFlake || h.LookbackFailures != tc.wantLookbackFail {
+			t.Errorf("history of %s has %d runs, %v%% flaky and %d failures, want %d, %v%% and %d",
+				tc.test, h.Runs, h.FlakePercentage, h.LookbackFailures, tc.wantRuns, tc.wantFlake, tc.wantLookbackFail)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package analysis
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// The failures of the quarantined tests do not fail a run:
+//   - a failed test is quarantined if it or one of its parent tests is in the quarantine
+//   - a failed parent test is quarantined too if every failed subtest without failed subtests of its own is quarantined,
+//     since go test fails the parents of a failed subtest
+
+// quarantineReader is the part of the database the quarantine is read from
+type quarantineReader interface {
+	GetQuarantine(ctx context.Context, env string) ([]models.DBQuarantine, error)
+}
+
+// FindQuarantined reads the quarantine of the environment active at now and returns the entry of each failed test it quarantines
+func FindQuarantined(ctx context.Context, database quarantineReader, env string, failed []string, now time.Time) (map[string]models.DBQuarantine, error) {
+	if len(failed) == 0 {
+		return nil, nil
+	}
+	entries, err := database.GetQuarantine(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+	return QuarantinedFailures(failed, ActiveQuarantine(entries, now)), nil
+}
+
+// ActiveQuarantine returns the entries of the quarantine that have not expired at now
+func ActiveQuarantine(entries []models.DBQuarantine, now time.Time) []models.DBQuarantine {
+	var active []models.DBQuarantine
+	for _, q := range entries {
+		if q.Expiry == nil || now.Before(*q.Expiry) {
+			active = append(active, q)
+		}
+	}
+	return active
+}
+
+// QuarantinedFailures returns the quarantine entry of each failed test that is quarantined by the entries, by test name
+func QuarantinedFailures(failed []string, entries []models.DBQuarantine) map[string]models.DBQuarantine {
+	byName := map[string]models.DBQuarantine{}
+	for _, q := range entries {
+		// the quarantine of the environment takes precedence over the quarantine on every environment
+		if _, ok := byName[q.TestName]; !ok || q.EnvName != "" {
+			byName[q.TestName] = q
+		}
+	}
+	// entry returns the entry quarantining the test or one of its parents
+	entry := func(test string) (models.DBQuarantine, bool) {
+		for name := test; ; {
+			if q, ok := byName[name]; ok {
+				return q, true
+			}
+			i := strings.LastIndex(name, "/")
+			if i < 0 {
+				return models.DBQuarantine{}, false
+			}
+			name = name[:i]
+		}
+	}
+
+	hasFailedSubtest := map[string]bool{}
+	for _, test := range failed {
+		for i := strings.LastIndex(test, "/"); i >= 0; i = strings.LastIndex(test[:i], "/") {
+			hasFailedSubtest[test[:i]] = true
+		}
+	}
+	quarantined := map[string]models.DBQuarantine{}
+	for _, test := range failed {
+		if q, ok := entry(test); ok {
+			quarantined[test] = q
+			continue
+		}
+		if !hasFailedSubtest[test] {
+			continue
+		}
+		var first *models.DBQuarantine
+		all := true
+		for _, sub := range failed {
+			if !strings.HasPrefix(sub, test+"/") || hasFailedSubtest[sub] {
+				continue
+			}
+			q, ok := entry(sub)
+			if !ok {
+				all = false
+				break
+			}
+			if first == nil {
+				first = &q
+			}
+		}
+		if all && first != nil {
+			quarantined[test] = *first
+		}
+	}
+	return quarantined
+}
+
+// QuarantineRegex returns the go test -run or -skip pattern of the quarantined tests, which matches them with their subtests and nothing else,
+// empty if there are no entries
+func QuarantineRegex(entries []models.DBQuarantine) string {
+	names := map[string]bool{}
+	for _, q := range entries {
+		names[q.TestName] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	// go test splits the pattern into alternatives by the unbracketed |, and each alternative into the levels of the subtests by the unbracketed /
+	alternatives := make([]string, 0, len(sorted))
+	for _, name := range sorted {
+		levels := strings.Split(name, "/")
+		for i, l := range levels {
+			levels[i] = "^" + regexp.QuoteMeta(l) + "$"
+		}
+		alternatives = append(alternatives, strings.Join(levels, "/"))
+	}
+	return strings.Join(alternatives, "|")
+}
@@ -0,0 +1,160 @@
+package analysis
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// fakeQuarantineReader serves the quarantine entries of every environment
+type fakeQuarantineReader []models.DBQuarantine
+
+func (f fakeQuarantineReader) GetQuarantine(_ context.Context, env string) ([]models.DBQuarantine, error) {
+	var entries []models.DBQuarantine
+	for _, q := range f {
+		if q.EnvName == "" || q.EnvName == env {
+			entries = append(entries, q)
+		}
+	}
+	return entries, nil
+}
+
+// reasons returns the reason of the entry of each quarantined test, which tells the entries of the tests apart
+func reasons(quarantined map[string]models.DBQuarantine) map[string]string {
+	got := map[string]string{}
+	for test, q := range quarantined {
+		got[test] = q.Reason
+	}
+	return got
+}
+
+func TestActiveQuarantine(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		tt := now.Add(d)
+		return &tt
+	}
+	entries := []models.DBQuarantine{
+		{TestName: "Forever"},
+		{TestName: "Expired", Expiry: at(-time.Hour)},
+		{TestName: "ExpiringNow", Expiry: at(0)},
+		{TestName: "Later", Expiry: at(time.Hour)},
+	}
+	var got []string
+	for _, q := range ActiveQuarantine(entries, now) {
+		got = append(got, q.TestName)
+	}
+	if want := []string{"Forever", "Later"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ActiveQuarantine() = %v, want %v", got, want)
+	}
+}
+
+func TestQuarantinedFailures(t *testing.T) {
+	entries := []models.DBQuarantine{
+		{EnvName: "env", TestName: "TestA", Reason: "a on env"},
+		{TestName: "TestA", Reason: "a"},
+		{TestName: "TestB/one", Reason: "b/one"},
+		{TestName: "TestB/two", Reason: "b/two"},
+		{TestName: "TestC/sub", Reason: "c/sub"},
+	}
+	tests := []struct {
+		name   string
+		failed []string
+		want   map[string]string
+	}{
+		{
+			name:   "quarantined test",
+			failed: []string{"TestA"},
+			want:   map[string]string{"TestA": "a on env"},
+		},
+		{
+			name:   "subtest of a quarantined test",
+			failed: []string{"TestA", "TestA/sub"},
+			want:   map[string]string{"TestA": "a on env", "TestA/sub": "a on env"},
+		},
+		{
+			name:   "parent of quarantined subtests",
+			failed: []string{"TestB", "TestB/one", "TestB/two"},
+			want:   map[string]string{"TestB": "b/one", "TestB/one": "b/one", "TestB/two": "b/two"},
+		},
+		{
+			name:   "parent of a subtest not quarantined",
+			failed: []string{"TestB", "TestB/one", "TestB/three"},
+			want:   map[string]string{"TestB/one": "b/one"},
+		},
+		{
+			name:   "parent of nested subtests",
+			failed: []string{"TestC", "TestC/sub", "TestC/sub/deep"},
+			want:   map[string]string{"TestC": "c/sub", "TestC/sub": "c/sub", "TestC/sub/deep": "c/sub"},
+		},
+		{
+			name:   "name starting with a quarantined test",
+			failed: []string{"TestAB"},
+			want:   map[string]string{},
+		},
+		{
+			name: "no failures",
+			want: map[string]string{},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := reasons(QuarantinedFailures(tc.failed, entries)); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("QuarantinedFailures(%v) = %v, want %v", tc.failed, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestQuarantinedFailuresEnvFirst(t *testing.T) {
+	entries := [][]models.DBQuarantine{
+		{{TestName: "TestA", Reason: "every env"}, {EnvName: "env", TestName: "TestA", Reason: "env"}},
+		{{EnvName: "env", TestName: "TestA", Reason: "env"}, {TestName: "TestA", Reason: "every env"}},
+	}
+	for _, e := range entries {
+		if got := reasons(QuarantinedFailures([]string{"TestA"}, e)); got["TestA"] != "env" {
+			t.Errorf("QuarantinedFailures(%+v) = %v, want the entry of the environment", e, got)
+		}
+	}
+}
+
+func TestFindQuarantined(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	expired := now.Add(-time.Hour)
+	database := fakeQuarantineReader{
+		{TestName: "TestA", Reason: "a"},
+		{EnvName: "other", TestName: "TestB", Reason: "b on other"},
+		{TestName: "TestC", Reason: "expired", Expiry: &expired},
+	}
+	got, err := FindQuarantined(context.Background(), database, "env", []string{"TestA", "TestB", "TestC"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"TestA": "a"}; !reflect.DeepEqual(reasons(got), want) {
+		t.Errorf("FindQuarantined() = %v, want %v", reasons(got), want)
+	}
+}
+
+func TestQuarantineRegex(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []models.DBQuarantine
+		want    string
+	}{
+		{"none", nil, ""},
+		{"test", []models.DBQuarantine{{TestName: "TestA"}}, "^TestA$"},
+		{"subtest", []models.DBQuarantine{{TestName: "TestA/sub"}}, "^TestA$/^sub$"},
+		{"sorted and deduplicated", []models.DBQuarantine{{TestName: "TestB"}, {EnvName: "env", TestName: "TestA"}, {TestName: "TestA"}}, "^TestA$|^TestB$"},
+		{"special characters", []models.DBQuarantine{{TestName: "TestA/a.b(c)"}}, `^TestA$/^a\.b\(c\)$`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := QuarantineRegex(tc.entries); got != tc.want {
+				t.Errorf("QuarantineRegex() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// Tests are suggested from their runs outside of pull requests in the window, once they ran at least MinRuns times:
+//   - a test is failing, a candidate for a fix, if its last FailingStreak runs all failed
+//   - otherwise it is flaky, a candidate for quarantine, if at least MinFlipRate percent of its consecutive runs have different results
+const (
+	// SuggestionDays is the number of days of the window of the suggestions by default
+	SuggestionDays = 14
+	// maxFailingCommits is the number of most recent failing commits kept as the evidence of a suggestion
+	maxFailingCommits = 10
+	// maxSignatures is the number of most frequent failure signatures kept as the evidence of a suggestion
+	maxSignatures = 3
+)
+
+// SuggestionParams are the thresholds of the quarantine suggestions
+type SuggestionParams struct {
+	MinFlipRate   float32 // percentage of the consecutive runs with different results
+	MinRuns       int
+	FailingStreak int
+}
+
+// DefaultSuggestionParams are the thresholds of the quarantine suggestions by default
+var DefaultSuggestionParams = SuggestionParams{MinFlipRate: 20, MinRuns: 5, FailingStreak: 5}
+
+// suggestionReader is the part of the database the suggestions are found from
+type suggestionReader interface {
+	EachTestCase(ctx context.Context, f models.RowFilter, fn func(models.DBTestCase) error) error
+	GetQuarantine(ctx context.Context, env string) ([]models.DBQuarantine, error)
+}
+
+// testStats aggregates the runs of a test on an environment, most recent first
+type testStats struct {
+	runs           int
+	failures       int
+	flips          int
+	previous       string // result of the previous, more recent, run
+	streak         int    // number of failed runs since the most recent run
+	streakEnded    bool
+	lastFailure    time.Time
+	failingCommits []string
+	signatures     map[string]int
+}
+
+// FindSuggestions reads the runs outside of pull requests in the window and suggests the failing and flaky tests
+func FindSuggestions(ctx context.Context, database suggestionReader, w models.Window, p SuggestionParams, now time.Time) (*models.SuggestionReport, error) {
+	type key struct{ env, test string }
+	stats := map[key]*testStats{}
+	failingEnvs := map[string]map[string]bool{}
+	err := database.EachTestCase(ctx, models.RowFilter{NoPR: true, Window: w}, func(row models.DBTestCase) error {
+		if row.Result == "skip" {
+			return nil
+		}
+		k := key{row.EnvName, row.TestName}
+		s, ok := stats[k]
+		if !ok {
+			s = &testStats{signatures: map[string]int{}}
+			stats[k] = s
+		}
+		s.runs++
+		if s.previous != "" && s.previous != row.Result {
+			s.flips++
+		}
+		s.previous = row.Result
+		if row.Result != "fail" {
+			s.streakEnded = true
+			return nil
+		}
+		if !s.streakEnded {
+			s.streak++
+		}
+		s.failures++
+		if s.lastFailure.IsZero() {
+			s.lastFailure = row.TestTime
+		}
+		if len(s.failingCommits) < maxFailingCommits {
+			s.failingCommits = append(s.failingCommits, row.CommitID)
+		}
+		if row.Signature != "" {
+			s.signatures[row.Signature]++
+		}
+		if failingEnvs[row.TestName] == nil {
+			failingEnvs[row.TestName] = map[string]bool{}
+		}
+		failingEnvs[row.TestName][row.EnvName] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the test cases: %v", err)
+	}
+	entries, err := database.GetQuarantine(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	quarantined := map[key]bool{}
+	for _, q := range ActiveQuarantine(entries, now) {
+		quarantined[key{q.EnvName, q.TestName}] = true
+	}
+
+	data := &models.SuggestionReport{
+		GeneratedAt:   now,
+		From:          w.From,
+		To:            w.To,
+		MinFlipRate:   p.MinFlipRate,
+		MinRuns:       p.MinRuns,
+		FailingStreak: p.FailingStreak,
+		Suggestions:   []models.QuarantineSuggestion{},
+	}
+	for k, s := range stats {
+		if s.runs < p.MinRuns || s.failures == 0 {
+			continue
+		}
+		// a single run has no consecutive runs to flip between
+		var flipRate float32
+		if s.runs > 1 {
+			flipRate = float32(round(float64(s.flips) * 100 / float64(s.runs-1)))
+		}
+		kind := ""
+		switch {
+		case s.streak >= p.FailingStreak:
+			kind = "failing"
+		case flipRate >= p.MinFlipRate:
+			kind = "flaky"
+		default:
+			continue
+		}
+		data.Suggestions = append(data.Suggestions, models.QuarantineSuggestion{
+			Kind:           kind,
+			EnvName:        k.env,
+			TestName:       k.test,
+			Runs:           s.runs,
+			Failures:       s.failures,
+			FlipRate:       flipRate,
+			LastFailure:    s.lastFailure,
+			FailingCommits: s.failingCommits,
+			FailingEnvs:    sortedKeys(failingEnvs[k.test]),
+			Signatures:     topSignatures(s.signatures),
+			Quarantined:    quarantined[k] || quarantined[key{"", k.test}],
+		})
+	}
+	// failing tests first as they fail every run, then the flakiest
+	sort.Slice(data.Suggestions, func(i, j int) bool {
+		a, b := data.Suggestions[i], data.Suggestions[j]
+		if a.Kind != b.Kind {
+			return a.Kind == "failing"
+		}
+		if a.FlipRate != b.FlipRate {
+			return a.FlipRate > b.FlipRate
+		}
+		if a.EnvName != b.EnvName {
+			return a.EnvName < b.EnvName
+		}
+		return a.TestName < b.TestName
+	})
+	return data, nil
+}
+
+// topSignatures returns the most frequent failure signatures
+func topSignatures(counts map[string]int) []models.SignatureCount {
+	signatures := make([]models.SignatureCount, 0, len(counts))
+	for s, c := range counts {
+		signatures = append(signatures, models.SignatureCount{Signature: s, Count: c})
+	}
+	sort.Slice(signatures, func(i, j int) bool {
+		if signatures[i].Count != signatures[j].Count {
+			return signatures[i].Count > signatures[j].Count
+		}
+		return signatures[i].Signature < signatures[j].Signature
+	})
+	return signatures[:min(len(signatures), maxSignatures)]
+}
+
+// sortedKeys returns the keys of the set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,98 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// fakeSuggestionReader serves the test cases most recent first, like the database
+type fakeSuggestionReader struct {
+	rows []models.DBTestCase
+}
+
+func (f fakeSuggestionReader) EachTestCase(_ context.Context, filter models.RowFilter, fn func(models.DBTestCase) error) error {
+	for _, r := range f.rows {
+		if !matches(filter, r.EnvName, r.CommitID, r.TestName, r.PR) {
+			continue
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f fakeSuggestionReader) GetQuarantine(context.Context, string) ([]models.DBQuarantine, error) {
+	return nil, nil
+}
+
+func TestFindSuggestions(t *testing.T) {
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	row := func(test, result, pr string, daysAgo int) models.DBTestCase {
+		return models.DBTestCase{EnvName: "env", TestName: test, Result: result, PR: pr, CommitID: test + result, TestTime: now.AddDate(0, 0, -daysAgo)}
+	}
+	tests := []struct {
+		name     string
+		params   SuggestionParams
+		rows     []models.DBTestCase
+		wantKind map[string]string
+		wantFlip map[string]float32
+	}{
+		{
+			name:     "single run",
+			params:   SuggestionParams{MinFlipRate: 20, MinRuns: 1, FailingStreak: 1},
+			rows:     []models.DBTestCase{row("TestA", "fail", "", 1)},
+			wantKind: map[string]string{"TestA": "failing"},
+			wantFlip: map[string]float32{"TestA": 0},
+		},
+		{
+			name:   "flaky and failing",
+			params: DefaultSuggestionParams,
+			rows: []models.DBTestCase{
+				row("TestFlaky", "pass", "", 1), row("TestBroken", "fail", "", 1),
+				row("TestFlaky", "fail", "", 2), row("TestBroken", "fail", "", 2),
+				row("TestFlaky", "pass", "", 3), row("TestBroken", "fail", "", 3),
+				row("TestFlaky", "fail", "", 4), row("TestBroken", "fail", "", 4),
+				row("TestFlaky", "pass", "", 5), row("TestBroken", "fail", "", 5),
+			},
+			wantKind: map[string]string{"TestBroken": "failing", "TestFlaky": "flaky"},
+			wantFlip: map[string]float32{"TestBroken": 0, "TestFlaky": 100},
+		},
+		{
+			name:   "pull request runs are ignored",
+			params: DefaultSuggestionParams,
+			rows: []models.DBTestCase{
+				row("TestA", "fail", "1", 1), row("TestA", "fail", "1", 2), row("TestA", "fail", "1", 3),
+				row("TestA", "fail", "1", 4), row("TestA", "fail", "1", 5),
+			},
+		},
+		{
+			name:   "too few runs",
+			params: DefaultSuggestionParams,
+			rows:   []models.DBTestCase{row("TestA", "fail", "", 1), row("TestA", "pass", "", 2)},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := FindSuggestions(context.Background(), fakeSuggestionReader{tc.rows}, models.Window{}, tc.params, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := json.Marshal(data); err != nil {
+				t.Errorf("the report does not marshal: %v", err)
+			}
+			if len(data.Suggestions) != len(tc.wantKind) {
+				t.Fatalf("got %d suggestions, want %d: %+v", len(data.Suggestions), len(tc.wantKind), data.Suggestions)
+			}
+			for _, s := range data.Suggestions {
+				if s.Kind != tc.wantKind[s.TestName] || s.FlipRate != tc.wantFlip[s.TestName] {
+					t.Errorf("%s suggested as %q with flip rate %v, want %q with %v", s.TestName, s.Kind, s.FlipRate, tc.wantKind[s.TestName], tc.wantFlip[s.TestName])
+				}
+			}
+		})
+	}
+}
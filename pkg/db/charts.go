@@ -0,0 +1,572 @@
+package db
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// truncateDay returns the start of the day of t, like DATE_TRUNC('day', t)
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// truncateWeek returns the start of the monday of the week of t, like DATE_TRUNC('week', t)
+func truncateWeek(t time.Time) time.Time {
+	t = truncateDay(t)
+	daysSinceMonday := (int(t.Weekday()) + 6) % 7
+	return t.AddDate(0, 0, -daysSinceMonday)
+}
+
+// truncateMonth returns the start of the month of t, like DATE_TRUNC('month', t)
+func truncateMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// testRateAndDurations groups the test case rows by the truncated test time, calculating the flake percentage and average duration of each group
+// the groups are ordered by the most recent first
+func testRateAndDurations(rows []models.DBTestCase, truncate func(time.Time) time.Time) []models.DBTestRateAndDuration {
+	type group struct {
+		fails    int
+		duration float64
+		commits  models.CommitResults
+	}
+	groups := map[time.Time]*group{}
+	for _, r := range rows {
+		start := truncate(r.TestTime)
+		g, ok := groups[start]
+		if !ok {
+			g = &group{}
+			groups[start] = g
+		}
+		if r.Result == "fail" {
+			g.fails++
+		}
+		g.duration += r.Duration
+		g.commits = append(g.commits, models.CommitResult{
+			Commit:   r.CommitID,
+			Result:   r.Result,
+			Duration: r.Duration,
+			PR:       r.PR,
+			Time:     r.TestTime,
+		})
+	}
+
+	charts := make([]models.DBTestRateAndDuration, 0, len(groups))
+	for start, g := range groups {
+		n := float64(len(g.commits))
+		sort.Slice(g.commits, func(i, j int) bool { return g.commits[i].Time.Before(g.commits[j].Time) })
+		charts = append(charts, models.DBTestRateAndDuration{
+			StartOfDate:               start,
+			AvgDuration:               float32(g.duration / n),
+			FlakePercentage:           float32(roundTo(float64(g.fails)*100/n, 2)),
+			CommitResultsAndDurations: g.commits,
+		})
+	}
+	sort.Slice(charts, func(i, j int) bool { return charts[i].StartOfDate.After(charts[j].StartOfDate) })
+	return charts
+}
+
+// roundTo rounds f to the number of decimal places, like ROUND(f, places)
+func roundTo(f float64, places int) float64 {
+	p := math.Pow10(places)
+	return math.Round(f*p) / p
+}
+
+// commitOrder is the time a run is ordered by in the commit history, like pgCommitOrder
+func commitOrder(run models.DBEnvironmentTest) time.Time {
+	if run.CommitTime != nil {
+		return *run.CommitTime
+	}
+	return run.TestTime
+}
+
+// resultsByCommit orders the test case rows by the commit order of the runs they belong to, oldest first
+// rows of commits without a run (for example not on the branch of the runs) are left out
+func resultsByCommit(rows []models.DBTestCase, runs []models.DBEnvironmentTest) models.CommitResults {
+	commitTimes := map[string]time.Time{}
+	for _, run := range runs {
+		commitTimes[run.CommitID] = commitOrder(run)
+	}
+	var results models.CommitResults
+	for _, r := range rows {
+		commitTime, ok := commitTimes[r.CommitID]
+		if !ok {
+			continue
+		}
+		results = append(results, models.CommitResult{
+			Commit:   r.CommitID,
+			Result:   r.Result,
+			Duration: r.Duration,
+			PR:       r.PR,
+			Time:     commitTime,
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Time.Before(results[j].Time) })
+	return results
+}
+
+// recentResultsPerEnv is the number of most recent results of a test listed per environment in the cross environment comparison
+const recentResultsPerEnv = 20
+
+// testEnvSummaries summarizes the rows of a test on each environment, ordered by commit with the runs of the environments, the flakiest environment first
+func testEnvSummaries(rows []models.DBTestCase, runs []models.DBEnvironmentTest) []models.DBTestEnvSummary {
+	commitTimes := runCommitTimes(runs)
+	outcomes := newCommitOutcomes(rows)
+	byEnv := map[string][]models.DBTestCase{}
+	for _, r := range rows {
+		byEnv[r.EnvName] = append(byEnv[r.EnvName], r)
+	}
+	summaries := make([]models.DBTestEnvSummary, 0, len(byEnv))
+	for env, envRows := range byEnv {
+		sort.Slice(envRows, func(i, j int) bool { return envRows[i].TestTime.Before(envRows[j].TestTime) })
+		s := models.DBTestEnvSummary{EnvName: env, TotalTestNum: len(envRows)}
+		duration := 0.0
+		var flips flipCounts
+		flips.add(append([]models.DBTestCase(nil), envRows...), commitTimes[env], outcomes)
+		s.FlipRate, s.ConsistentFailureRate = flips.rates()
+		for i, r := range envRows {
+			if r.Result == "fail" {
+				s.FailedTestNum++
+			}
+			duration += r.Duration
+			if i >= len(envRows)-recentResultsPerEnv {
+				s.RecentResults = append(s.RecentResults, models.CommitResult{
+					Commit:   r.CommitID,
+					Result:   r.Result,
+					Duration: r.Duration,
+					PR:       r.PR,
+					Time:     r.TestTime,
+				})
+			}
+		}
+		n := float64(len(envRows))
+		s.FlakePercentage = float32(roundTo(float64(s.FailedTestNum)*100/n, 2))
+		s.AvgDuration = float32(duration / n)
+		summaries = append(summaries, s)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].FlakePercentage != summaries[j].FlakePercentage {
+			return summaries[i].FlakePercentage > summaries[j].FlakePercentage
+		}
+		return summaries[i].EnvName < summaries[j].EnvName
+	})
+	return summaries
+}
+
+// prResults groups the failed tests of a pull request by the runs they failed in
+func prResults(pr string, runs []models.DBEnvironmentTest, failed []models.DBPRFailedTest) *models.PRResults {
+	type run struct{ env, commit string }
+	failedByRun := map[run][]models.DBPRFailedTest{}
+	for _, f := range failed {
+		failedByRun[run{f.EnvName, f.CommitID}] = append(failedByRun[run{f.EnvName, f.CommitID}], f)
+	}
+	data := &models.PRResults{PR: pr, Runs: []models.PRRun{}}
+	for _, r := range runs {
+		failedTests := failedByRun[run{r.EnvName, r.CommitID}]
+		if failedTests == nil {
+			failedTests = []models.DBPRFailedTest{}
+		}
+		sort.Slice(failedTests, func(i, j int) bool { return failedTests[i].TestName < failedTests[j].TestName })
+		data.Runs = append(data.Runs, models.PRRun{
+			EnvName:       r.EnvName,
+			CommitID:      r.CommitID,
+			TestTime:      r.TestTime,
+			NumberOfPass:  r.NumberOfPass,
+			NumberOfFail:  r.NumberOfFail,
+			NumberOfSkip:  r.NumberOfSkip,
+			TotalDuration: r.TotalDuration,
+			FailedTests:   failedTests,
+		})
+	}
+	sort.SliceStable(data.Runs, func(i, j int) bool {
+		if data.Runs[i].EnvName != data.Runs[j].EnvName {
+			return data.Runs[i].EnvName < data.Runs[j].EnvName
+		}
+		return data.Runs[i].TestTime.After(data.Runs[j].TestTime)
+	})
+	return data
+}
+
+// runCommitTimes returns the commit order of the runs by environment and commit
+func runCommitTimes(runs []models.DBEnvironmentTest) map[string]map[string]time.Time {
+	times := map[string]map[string]time.Time{}
+	for _, r := range runs {
+		if times[r.EnvName] == nil {
+			times[r.EnvName] = map[string]time.Time{}
+		}
+		times[r.EnvName][r.CommitID] = commitOrder(r)
+	}
+	return times
+}
+
+// percentage returns n as a percentage of total rounded to 2 decimal places, 0 if total is 0
+func percentage(n, total int) float32 {
+	if total == 0 {
+		return 0
+	}
+	return float32(roundTo(float64(n)*100/float64(total), 2))
+}
+
+// dayCutoffs returns the start of the days-th and 2*days-th most recent days with a time, like the recentCutoff and prevCutoff of the postgres queries.
+// The recent days are from the recent cutoff and the previous days are from the previous cutoff to the recent cutoff.
+// Unlike the postgres queries, with fewer days every day is recent, and with fewer than 2*days days every older day is previous
+func dayCutoffs(times []time.Time, days int) (time.Time, time.Time) {
+	seen := map[time.Time]bool{}
+	var dates []time.Time
+	for _, t := range times {
+		d := truncateDay(t)
+		if !seen[d] {
+			seen[d] = true
+			dates = append(dates, d)
+		}
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].After(dates[j]) })
+	var recent, prev time.Time
+	if days > 0 && len(dates) >= days {
+		recent = dates[days-1]
+	}
+	if days > 0 && len(dates) >= 2*days {
+		prev = dates[2*days-1]
+	}
+	return recent, prev
+}
+
+// flakeBy groups the test case rows by test and truncated test time, calculating the flake percentage of each group,
+// the groups are ordered by the most recent first
+func flakeBy(rows []models.DBTestCase, truncate func(time.Time) time.Time) []models.DBFlakeBy {
+	type key struct {
+		test  string
+		start time.Time
+	}
+	groups := map[key]*models.DBFlakeBy{}
+	fails := map[key]int{}
+	for _, r := range rows {
+		k := key{r.TestName, truncate(r.TestTime)}
+		g, ok := groups[k]
+		if !ok {
+			g = &models.DBFlakeBy{TestName: r.TestName, StartOfDate: k.start}
+			groups[k] = g
+		}
+		if r.Result == "fail" {
+			fails[k]++
+		}
+		g.CommitResults = append(g.CommitResults, models.CommitResult{
+			Commit:   r.CommitID,
+			Result:   r.Result,
+			Duration: r.Duration,
+			PR:       r.PR,
+			Time:     r.TestTime,
+		})
+	}
+	charts := make([]models.DBFlakeBy, 0, len(groups))
+	for k, g := range groups {
+		sort.Slice(g.CommitResults, func(i, j int) bool { return g.CommitResults[i].Time.Before(g.CommitResults[j].Time) })
+		g.FlakePercentage = percentage(fails[k], len(g.CommitResults))
+		charts = append(charts, *g)
+	}
+	sort.Slice(charts, func(i, j int) bool {
+		if !charts[i].StartOfDate.Equal(charts[j].StartOfDate) {
+			return charts[i].StartOfDate.After(charts[j].StartOfDate)
+		}
+		return charts[i].TestName < charts[j].TestName
+	})
+	return charts
+}
+
+// envCharts calculates the charts of an environment from its non skipped test cases in the window and its runs,
+// comparing the runs with the other environments through the outcomes of the commits (see flakiness.go), like the postgres queries.
+// The slowest growing tests are left to the caller
+func envCharts(rows []models.DBTestCase, outcomes commitOutcomes, runs []models.DBEnvironmentTest, testsInTop int, w models.Window) *models.EnvCharts {
+	commitTimes := map[string]time.Time{}
+	for _, r := range runs {
+		commitTimes[r.CommitID] = commitOrder(r)
+	}
+	times := make([]time.Time, len(rows))
+	for i, r := range rows {
+		times[i] = r.TestTime
+	}
+	recentCutoff, prevCutoff := dayCutoffs(times, w.Days)
+
+	// the flake table ranks the tests by their flip rate in the recent days
+	type testRows struct {
+		recent, prev           []models.DBTestCase
+		recentFails, prevFails int
+	}
+	byTest := map[string]*testRows{}
+	for _, r := range rows {
+		t, ok := byTest[r.TestName]
+		if !ok {
+			t = &testRows{}
+			byTest[r.TestName] = t
+		}
+		day := truncateDay(r.TestTime)
+		switch {
+		case !day.Before(recentCutoff):
+			t.recent = append(t.recent, r)
+			if r.Result == "fail" {
+				t.recentFails++
+			}
+		case !day.Before(prevCutoff):
+			t.prev = append(t.prev, r)
+			if r.Result == "fail" {
+				t.prevFails++
+			}
+		}
+	}
+	table := make([]models.DBFlakeRow, 0, len(byTest))
+	for test, t := range byTest {
+		var flips flipCounts
+		flips.add(t.recent, commitTimes, outcomes)
+		row := models.DBFlakeRow{
+			TestName:              test,
+			RecentFlakePercentage: percentage(t.recentFails, len(t.recent)),
+			FailedTestNum:         float32(t.recentFails),
+			TotalTestNum:          float32(len(t.recent)),
+		}
+		row.GrowthRate = float32(roundTo(float64(row.RecentFlakePercentage-percentage(t.prevFails, len(t.prev))), 2))
+		row.FlipRate, row.ConsistentFailureRate = flips.rates()
+		table = append(table, row)
+	}
+	sort.Slice(table, func(i, j int) bool {
+		if table[i].FlipRate != table[j].FlipRate {
+			return table[i].FlipRate > table[j].FlipRate
+		}
+		if table[i].RecentFlakePercentage != table[j].RecentFlakePercentage {
+			return table[i].RecentFlakePercentage > table[j].RecentFlakePercentage
+		}
+		return table[i].TestName < table[j].TestName
+	})
+	topByDay := map[string]bool{}
+	for _, row := range table {
+		if len(topByDay) >= testsInTop {
+			break
+		}
+		topByDay[row.TestName] = true
+	}
+
+	// the weekly chart ranks the tests by their flip rate in the most recent week
+	var latestWeek time.Time
+	for _, r := range rows {
+		if week := truncateWeek(r.TestTime); week.After(latestWeek) {
+			latestWeek = week
+		}
+	}
+	weekRows := map[string][]models.DBTestCase{}
+	for _, r := range rows {
+		if !r.TestTime.Before(latestWeek) {
+			weekRows[r.TestName] = append(weekRows[r.TestName], r)
+		}
+	}
+	type weekRank struct {
+		test      string
+		flipRate  float32
+		flakeRate float32
+	}
+	ranks := make([]weekRank, 0, len(weekRows))
+	for test, testRows := range weekRows {
+		fails := 0
+		for _, r := range testRows {
+			if r.Result == "fail" {
+				fails++
+			}
+		}
+		var flips flipCounts
+		flips.add(testRows, commitTimes, outcomes)
+		flipRate, _ := flips.rates()
+		ranks = append(ranks, weekRank{test, flipRate, percentage(fails, len(testRows))})
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		if ranks[i].flipRate != ranks[j].flipRate {
+			return ranks[i].flipRate > ranks[j].flipRate
+		}
+		if ranks[i].flakeRate != ranks[j].flakeRate {
+			return ranks[i].flakeRate > ranks[j].flakeRate
+		}
+		return ranks[i].test < ranks[j].test
+	})
+	topByWeek := map[string]bool{}
+	for _, r := range ranks {
+		if len(topByWeek) >= testsInTop {
+			break
+		}
+		topByWeek[r.test] = true
+	}
+
+	var dayRows, weekChartRows []models.DBTestCase
+	for _, r := range rows {
+		if topByDay[r.TestName] {
+			dayRows = append(dayRows, r)
+		}
+		if topByWeek[r.TestName] {
+			weekChartRows = append(weekChartRows, r)
+		}
+	}
+	var windowRuns []models.DBEnvironmentTest
+	for _, r := range runs {
+		if inWindow(r.TestTime, w) {
+			windowRuns = append(windowRuns, r)
+		}
+	}
+	return &models.EnvCharts{
+		RecentFlakePercentTable: table,
+		FlakeRateByWeek:         flakeBy(weekChartRows, truncateWeek),
+		FlakeRateByDay:          flakeBy(dayRows, truncateDay),
+		CountsAndDurations:      envDurations(windowRuns),
+	}
+}
+
+// envDurations groups the runs of an environment by day, averaging their test counts and durations, the most recent day first
+func envDurations(runs []models.DBEnvironmentTest) []models.DBEnvDuration {
+	groups := map[time.Time]*models.DBEnvDuration{}
+	for _, r := range runs {
+		day := truncateDay(r.TestTime)
+		g, ok := groups[day]
+		if !ok {
+			g = &models.DBEnvDuration{StartOfDate: day}
+			groups[day] = g
+		}
+		g.Commits = append(g.Commits, models.CommitResult{
+			Commit:    r.CommitID,
+			TestCount: r.NumberOfPass + r.NumberOfFail,
+			Duration:  r.TotalDuration,
+			Time:      r.TestTime,
+		})
+	}
+	charts := make([]models.DBEnvDuration, 0, len(groups))
+	for _, g := range groups {
+		sort.Slice(g.Commits, func(i, j int) bool { return g.Commits[i].Time.Before(g.Commits[j].Time) })
+		count, duration := 0, 0.0
+		for _, c := range g.Commits {
+			count += c.TestCount
+			duration += c.Duration
+		}
+		n := float64(len(g.Commits))
+		g.TestCount = float32(float64(count) / n)
+		g.Duration = float32(duration / n)
+		charts = append(charts, *g)
+	}
+	sort.Slice(charts, func(i, j int) bool { return charts[i].StartOfDate.After(charts[j].StartOfDate) })
+	return charts
+}
+
+// overview calculates the overview charts of every environment from their non skipped test cases in the window and their runs,
+// like the postgres queries. The slowest growing tests are left to the caller
+func overview(rows []models.DBTestCase, runs []models.DBEnvironmentTest, w models.Window) *models.Overview {
+	var windowRuns []models.DBEnvironmentTest
+	for _, r := range runs {
+		if inWindow(r.TestTime, w) {
+			windowRuns = append(windowRuns, r)
+		}
+	}
+	sort.Slice(windowRuns, func(i, j int) bool { return windowRuns[i].TestTime.Before(windowRuns[j].TestTime) })
+
+	type dayEnv struct {
+		day time.Time
+		env string
+	}
+	type sums struct {
+		runs, fails int
+		duration    float64
+	}
+	byDay := map[dayEnv]*sums{}
+	var keys []dayEnv
+	times := make([]time.Time, len(windowRuns))
+	byEnv := map[string][]models.DBEnvironmentTest{}
+	var envs []string
+	for i, r := range windowRuns {
+		times[i] = r.TestTime
+		k := dayEnv{truncateDay(r.TestTime), r.EnvName}
+		s, ok := byDay[k]
+		if !ok {
+			s = &sums{}
+			byDay[k] = s
+			keys = append(keys, k)
+		}
+		s.runs++
+		s.fails += r.NumberOfFail
+		s.duration += r.TotalDuration
+		if byEnv[r.EnvName] == nil {
+			envs = append(envs, r.EnvName)
+		}
+		byEnv[r.EnvName] = append(byEnv[r.EnvName], r)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].day.Equal(keys[j].day) {
+			return keys[i].day.Before(keys[j].day)
+		}
+		return keys[i].env < keys[j].env
+	})
+	avgFail := make([]models.DBSummaryAvgFail, 0, len(keys))
+	for _, k := range keys {
+		s := byDay[k]
+		avgFail = append(avgFail, models.DBSummaryAvgFail{
+			StartOfDate:    k.day,
+			EnvName:        k.env,
+			AvgFailedTests: float32(float64(s.fails) / float64(s.runs)),
+			AvgDuration:    float32(s.duration / float64(s.runs)),
+		})
+	}
+
+	// the flip rates of each environment are of the runs of its tests since the recent cutoff
+	recentCutoff, prevCutoff := dayCutoffs(times, w.Days)
+	commitTimes := runCommitTimes(runs)
+	outcomes := newCommitOutcomes(rows)
+	type envTest struct{ env, test string }
+	recentRows := map[envTest][]models.DBTestCase{}
+	for _, r := range rows {
+		if !truncateDay(r.TestTime).Before(recentCutoff) {
+			recentRows[envTest{r.EnvName, r.TestName}] = append(recentRows[envTest{r.EnvName, r.TestName}], r)
+		}
+	}
+	flips := map[string]*flipCounts{}
+	for k, testRows := range recentRows {
+		if flips[k.env] == nil {
+			flips[k.env] = &flipCounts{}
+		}
+		flips[k.env].add(testRows, commitTimes[k.env], outcomes)
+	}
+
+	table := make([]models.DBSummaryTable, 0, len(envs))
+	for _, env := range envs {
+		envRuns := byEnv[env]
+		var recent, prev sums
+		for _, r := range envRuns {
+			day := truncateDay(r.TestTime)
+			switch {
+			case !day.Before(recentCutoff):
+				recent.runs++
+				recent.fails += r.NumberOfFail
+			case !day.Before(prevCutoff):
+				prev.runs++
+				prev.fails += r.NumberOfFail
+			}
+		}
+		avg := func(s sums) float32 {
+			if s.runs == 0 {
+				return 0
+			}
+			return float32(roundTo(float64(s.fails)/float64(s.runs), 2))
+		}
+		row := models.DBSummaryTable{
+			EnvName:            env,
+			RecentNumberOfFail: avg(recent),
+			Growth:             float32(roundTo(float64(avg(recent)-avg(prev)), 2)),
+			TestDuration:       float32(envRuns[len(envRuns)-1].TotalDuration),
+		}
+		if len(envRuns) > 1 {
+			row.PreviousTestDuration = float32(envRuns[len(envRuns)-2].TotalDuration)
+		}
+		row.TestDurationGrowth = row.TestDuration - row.PreviousTestDuration
+		if f := flips[env]; f != nil {
+			row.FlipRate, row.ConsistentFailureRate = f.rates()
+		}
+		table = append(table, row)
+	}
+	sort.SliceStable(table, func(i, j int) bool { return table[i].RecentNumberOfFail > table[j].RecentNumberOfFail })
+	return &models.Overview{SummaryAvgFail: avgFail, SummaryTable: table}
+}
@@ -1,8 +1,11 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/medyagh/gopogh/pkg/models"
 )
@@ -14,6 +17,8 @@ type FlagValues struct {
 	Path        string
 	UseCloudSQL bool
 	UseIAMAuth  bool
+	// QueryTimeout cancels the calls to the database taking longer, 0 to not time them out
+	QueryTimeout time.Duration
 }
 
 // config is database configuration
@@ -25,18 +30,168 @@ type config struct {
 }
 
 // Datab is the database interface we support
+// the getters analyze the test runs in the window, see DefaultWindow
 type Datab interface {
-	Set(models.DBEnvironmentTest, []models.DBTestCase) error
+	Set(context.Context, models.DBEnvironmentTest, []models.DBTestCase) error
 
-	Initialize() error
+	Initialize(ctx context.Context) error
 
-	GetEnvironmentTestsAndTestCases() (map[string]interface{}, error)
+	// EachEnvironmentTest calls fn on the environment tests matching the filter, most recent first, stopping at the first error
+	EachEnvironmentTest(ctx context.Context, f models.RowFilter, fn func(models.DBEnvironmentTest) error) error
 
-	GetEnvCharts(string, int) (map[string]interface{}, error)
+	// EachTestCase calls fn on the test cases matching the filter, most recent first, stopping at the first error
+	EachTestCase(ctx context.Context, f models.RowFilter, fn func(models.DBTestCase) error) error
 
-	GetOverview(dataRange int) (map[string]interface{}, error)
+	GetEnvCharts(ctx context.Context, env string, testsInTop int, w models.Window) (*models.EnvCharts, error)
 
-	GetTestCharts(string, string) (map[string]interface{}, error)
+	GetOverview(ctx context.Context, w models.Window) (*models.Overview, error)
+
+	GetTestCharts(ctx context.Context, env string, test string, branch string, w models.Window) (*models.TestCharts, error)
+
+	GetTestAcrossEnvs(ctx context.Context, test string, w models.Window) (*models.TestAcrossEnvs, error)
+
+	SetCommits(context.Context, []models.DBCommit) error
+
+	GetCommitHistory(ctx context.Context, env string, branch string, limit int, w models.Window) (*models.CommitHistory, error)
+
+	GetTestHistory(ctx context.Context, env string, test string, branch string, w models.Window) (models.CommitResults, error)
+
+	GetDurationStats(ctx context.Context, env string, recentSince time.Time, baselineSince time.Time, until time.Time) ([]models.DurationStats, error)
+
+	GetPRResults(ctx context.Context, pr string, w models.Window) (*models.PRResults, error)
+
+	// GetEnvs lists the environments with their most recent run regardless of any window
+	GetEnvs(ctx context.Context) (*models.EnvList, error)
+
+	// GetEnvHealth lists the environments with their most recent run regardless of the window,
+	// and the number of their tests whose flake percentage in the recent window of w is at least flakeRate
+	GetEnvHealth(ctx context.Context, flakeRate float32, w models.Window) ([]models.DBEnvHealth, error)
+
+	SearchTests(ctx context.Context, query string, regex bool, page int, perPage int, w models.Window) (*models.TestList, error)
+
+	// SetQuarantine adds/updates a quarantined test
+	SetQuarantine(context.Context, models.DBQuarantine) error
+
+	// GetQuarantine lists the quarantined tests of an environment including the tests quarantined on every environment,
+	// or every quarantined test if env is empty, expired ones included
+	GetQuarantine(ctx context.Context, env string) ([]models.DBQuarantine, error)
+
+	// DeleteQuarantine removes a test from the quarantine of an environment, reporting whether it was quarantined
+	DeleteQuarantine(ctx context.Context, env string, test string) (bool, error)
+
+	// Ping checks that the database can be reached
+	Ping(ctx context.Context) error
+
+	// GetSchemaVersion returns the version of the tables recorded by Initialize, 0 if none is recorded
+	GetSchemaVersion(ctx context.Context) (int, error)
+
+	// GetLastViewRefresh returns when the materialized views were last refreshed successfully, nil if it is unknown or there are no views
+	GetLastViewRefresh(ctx context.Context) (*time.Time, error)
+}
+
+// SchemaVersion is the version of the tables created by Initialize,
+// it is increased by the changes to the tables that servers of the previous versions cannot work with
+const SchemaVersion = 1
+
+const (
+	// defaultWindowDays is the number of days analyzed by default, the number of days of the postgres materialized views
+	defaultWindowDays = 90
+	// defaultRecentDays is the number of days of the recent window by default
+	defaultRecentDays = 15
+)
+
+// DefaultWindow returns the window of the last 90 days with a recent window of 15 days
+func DefaultWindow(now time.Time) models.Window {
+	return models.Window{
+		From: now.AddDate(0, 0, -defaultWindowDays),
+		To:   now,
+		Days: defaultRecentDays,
+	}
+}
+
+// inWindow checks whether t is in the window, the window includes From and excludes To
+func inWindow(t time.Time, w models.Window) bool {
+	return !t.Before(w.From) && t.Before(w.To)
+}
+
+// recentWindow returns the recent window of w, its last w.Days days
+func recentWindow(w models.Window) models.Window {
+	w.From = w.To.AddDate(0, 0, -w.Days)
+	return w
+}
+
+// rowConditions returns the SQL conditions, with ? placeholders, and the arguments of the column filters of f on db_environment_tests or db_test_cases
+func rowConditions(f models.RowFilter, testCases bool) ([]string, []interface{}) {
+	var c sqlConditions
+	c.equal("EnvName", f.Env)
+	c.equal("CommitID", f.Commit)
+	c.in("CommitID", f.Commits)
+	if testCases {
+		c.equal("PR", f.PR)
+		if f.NoPR {
+			c.conditions = append(c.conditions, "COALESCE(PR, '') = ''")
+		}
+		c.equal("Result", f.Result)
+		c.equal("TestName", f.Test)
+		c.in("TestName", f.Tests)
+		return c.conditions, c.args
+	}
+	// a run is outside of pull requests if none of its test cases is of one, so that runs without test cases are too
+	if f.NoPR {
+		c.conditions = append(c.conditions,
+			"NOT EXISTS (SELECT 1 FROM db_test_cases t WHERE t.CommitID = db_environment_tests.CommitID AND t.EnvName = db_environment_tests.EnvName AND COALESCE(t.PR, '') != '')")
+	}
+	var cases sqlConditions
+	cases.equal("t.PR", f.PR)
+	cases.equal("t.Result", f.Result)
+	cases.equal("t.TestName", f.Test)
+	cases.in("t.TestName", f.Tests)
+	if len(cases.conditions) == 0 {
+		return c.conditions, c.args
+	}
+	c.conditions = append(c.conditions, fmt.Sprintf(
+		"EXISTS (SELECT 1 FROM db_test_cases t WHERE t.CommitID = db_environment_tests.CommitID AND t.EnvName = db_environment_tests.EnvName AND %s)",
+		strings.Join(cases.conditions, " AND ")))
+	return c.conditions, append(c.args, cases.args...)
+}
+
+// sqlConditions collects SQL conditions with ? placeholders and their arguments
+type sqlConditions struct {
+	conditions []string
+	args       []interface{}
+}
+
+// equal adds the condition of the column being the value, unless the value is empty
+func (c *sqlConditions) equal(column string, value string) {
+	if value != "" {
+		c.conditions = append(c.conditions, column+" = ?")
+		c.args = append(c.args, value)
+	}
+}
+
+// in adds the condition of the column being one of the values, unless there are none
+func (c *sqlConditions) in(column string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	c.conditions = append(c.conditions, fmt.Sprintf("%s IN (%s)", column, strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")))
+	for _, v := range values {
+		c.args = append(c.args, v)
+	}
+}
+
+// rowBefore checks whether the row is before the other in the most recent first order of the db tables
+func rowBefore(row models.RowCursor, other models.RowCursor) bool {
+	if !row.TestTime.Equal(other.TestTime) {
+		return row.TestTime.After(other.TestTime)
+	}
+	if row.EnvName != other.EnvName {
+		return row.EnvName < other.EnvName
+	}
+	if row.CommitID != other.CommitID {
+		return row.CommitID < other.CommitID
+	}
+	return row.TestName < other.TestName
 }
 
 // newDB handles which database driver to use and initializes the db
@@ -80,6 +235,7 @@ func FromEnv(fv FlagValues) (c Datab, err error) {
 	if err != nil {
 		return nil, fmt.Errorf("new from %s: %s: %v", backend, path, err)
 	}
+	c = WithQueryTimeout(c, fv.QueryTimeout)
 
 	return c, nil
 }
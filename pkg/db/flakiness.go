@@ -0,0 +1,128 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// The raw failure percentage ranks a test that is deterministically broken as the flakiest one,
+// so the flakiness model looks for a test flipping between pass and fail where nothing relevant changed:
+//   - on the same commit, a run flips when the test both passed and failed on its commit, on any environment
+//   - across consecutive commits, a run flips when its result differs from the result of the previous run of the test in commit order
+//
+// The flip rate is the percentage of the runs that flip, of the runs that have a previous run or other runs of their commit to compare with.
+// The consistent failure rate is the percentage of those runs that failed right after a failed run, while no run of their commit passed.
+// A flaky test flips between pass and fail while a broken test keeps failing, so the two rates separate them.
+// A rerun of a commit on an environment replaces its previous result, so only the runs of the commit on the other environments are compared.
+
+// pgFlipRate aggregates the rows of pgFlipOrdered
+const pgFlipRate = `ROUND(COALESCE(AVG(CASE WHEN PrevResult IS NULL AND NOT CommitFlip THEN NULL WHEN CommitFlip OR Result != PrevResult THEN 1 ELSE 0 END) * 100, 0), 2)`
+
+// pgConsistentFailureRate aggregates the rows of pgFlipOrdered
+const pgConsistentFailureRate = `ROUND(COALESCE(AVG(CASE WHEN PrevResult IS NULL AND NOT CommitFlip THEN NULL WHEN Result = 'fail' AND PrevResult = 'fail' AND NOT CommitFlip THEN 1 ELSE 0 END) * 100, 0), 2)`
+
+// pgFlipOrdered selects the columns of the non skipped test cases t of source matching the condition, with
+// PrevResult, the result of the previous run of the test in the partition in commit order,
+// and CommitFlip, whether the test both passed and failed on the commit of the run on any environment
+func pgFlipOrdered(columns, source, partition, condition string) string {
+	return fmt.Sprintf(`
+	SELECT %s,
+	LAG(t.Result) OVER (PARTITION BY %s ORDER BY %s, t.TestTime) AS PrevResult,
+	COALESCE(o.Passed AND o.Failed, FALSE) AS CommitFlip
+	FROM %s AS t
+	JOIN db_environment_tests e ON e.CommitID = t.CommitID AND e.EnvName = t.EnvName
+	LEFT JOIN db_commits c ON c.CommitID = t.CommitID
+	LEFT JOIN (
+		SELECT CommitID, TestName, BOOL_OR(Result = 'pass') AS Passed, BOOL_OR(Result = 'fail') AS Failed
+		FROM db_test_cases
+		WHERE Result != 'skip' AND CommitID IN (SELECT CommitID FROM %s AS s)
+		GROUP BY CommitID, TestName
+	) o ON o.CommitID = t.CommitID AND o.TestName = t.TestName
+	WHERE t.Result != 'skip' AND %s`, columns, partition, pgCommitOrder, source, source, condition)
+}
+
+// commitTest identifies the runs of a test on a commit
+type commitTest struct {
+	commit string
+	test   string
+}
+
+// commitOutcomes records whether each test passed and failed on each commit, on any environment
+type commitOutcomes map[commitTest]struct{ passed, failed bool }
+
+// newCommitOutcomes records the results of the rows
+func newCommitOutcomes(rows []models.DBTestCase) commitOutcomes {
+	o := commitOutcomes{}
+	for _, r := range rows {
+		k := commitTest{r.CommitID, r.TestName}
+		v := o[k]
+		switch r.Result {
+		case "pass":
+			v.passed = true
+		case "fail":
+			v.failed = true
+		}
+		o[k] = v
+	}
+	return o
+}
+
+// flipped checks whether the test both passed and failed on the commit
+func (o commitOutcomes) flipped(commit, test string) bool {
+	v := o[commitTest{commit, test}]
+	return v.passed && v.failed
+}
+
+// flipCounts are the counts the flip rate and consistent failure rate are the percentages of, they add up over tests and environments
+type flipCounts struct {
+	compared           int
+	flips              int
+	consistentFailures int
+}
+
+// add counts the runs of a single test on a single environment, like pgFlipOrdered does.
+// The rows are sorted by their commit order, the order of the run of their commit from commitTimes, or their test time without a run
+func (c *flipCounts) add(rows []models.DBTestCase, commitTimes map[string]time.Time, outcomes commitOutcomes) {
+	order := func(r models.DBTestCase) time.Time {
+		if t, ok := commitTimes[r.CommitID]; ok {
+			return t
+		}
+		return r.TestTime
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		oi, oj := order(rows[i]), order(rows[j])
+		if !oi.Equal(oj) {
+			return oi.Before(oj)
+		}
+		return rows[i].TestTime.Before(rows[j].TestTime)
+	})
+	for i, r := range rows {
+		commitFlip := outcomes.flipped(r.CommitID, r.TestName)
+		if i == 0 && !commitFlip {
+			continue
+		}
+		c.compared++
+		prev := ""
+		if i > 0 {
+			prev = rows[i-1].Result
+		}
+		if commitFlip || (prev != "" && r.Result != prev) {
+			c.flips++
+		}
+		if !commitFlip && r.Result == "fail" && prev == "fail" {
+			c.consistentFailures++
+		}
+	}
+}
+
+// rates returns the flip rate and consistent failure rate as percentages, like pgFlipRate and pgConsistentFailureRate
+func (c flipCounts) rates() (float32, float32) {
+	if c.compared == 0 {
+		return 0, 0
+	}
+	n := float64(c.compared)
+	return float32(roundTo(float64(c.flips)*100/n, 2)), float32(roundTo(float64(c.consistentFailures)*100/n, 2))
+}
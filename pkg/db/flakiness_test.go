@@ -0,0 +1,85 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+func TestFlipCounts(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	row := func(env, commit, result string, d int) models.DBTestCase {
+		return models.DBTestCase{EnvName: env, CommitID: commit, TestName: "TestA", Result: result, TestTime: day(d)}
+	}
+	tests := []struct {
+		name           string
+		rows           []models.DBTestCase
+		others         []models.DBTestCase
+		commitTimes    map[string]time.Time
+		wantFlip       float32
+		wantConsistent float32
+	}{
+		{
+			name: "no runs",
+		},
+		{
+			name: "single run",
+			rows: []models.DBTestCase{row("env", "a", "fail", 1)},
+		},
+		{
+			name:     "alternating",
+			rows:     []models.DBTestCase{row("env", "a", "pass", 1), row("env", "b", "fail", 2), row("env", "c", "pass", 3)},
+			wantFlip: 100,
+		},
+		{
+			name:           "broken",
+			rows:           []models.DBTestCase{row("env", "a", "fail", 1), row("env", "b", "fail", 2), row("env", "c", "fail", 3)},
+			wantConsistent: 100,
+		},
+		{
+			name:        "commit order over test time",
+			rows:        []models.DBTestCase{row("env", "a", "pass", 1), row("env", "c", "pass", 2), row("env", "b", "fail", 3)},
+			commitTimes: map[string]time.Time{"a": day(1), "b": day(2), "c": day(3)},
+			wantFlip:    100,
+		},
+		{
+			name:     "same commit passing on another environment",
+			rows:     []models.DBTestCase{row("env", "a", "fail", 1), row("env", "b", "fail", 2)},
+			others:   []models.DBTestCase{row("other", "b", "pass", 2)},
+			wantFlip: 100,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var c flipCounts
+			outcomes := newCommitOutcomes(append(append([]models.DBTestCase(nil), tc.rows...), tc.others...))
+			c.add(tc.rows, tc.commitTimes, outcomes)
+			flip, consistent := c.rates()
+			if flip != tc.wantFlip || consistent != tc.wantConsistent {
+				t.Errorf("rates() = %v, %v, want %v, %v", flip, consistent, tc.wantFlip, tc.wantConsistent)
+			}
+		})
+	}
+}
+
+func TestDayCutoffs(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	times := []time.Time{day(1), day(2), day(2), day(3), day(4), day(5)}
+	tests := []struct {
+		days       int
+		wantRecent time.Time
+		wantPrev   time.Time
+	}{
+		{days: 1, wantRecent: truncateDay(day(5)), wantPrev: truncateDay(day(4))},
+		{days: 2, wantRecent: truncateDay(day(4)), wantPrev: truncateDay(day(2))},
+		{days: 3, wantRecent: truncateDay(day(3))},
+		{days: 6},
+	}
+	for _, tc := range tests {
+		recent, prev := dayCutoffs(times, tc.days)
+		if !recent.Equal(tc.wantRecent) || !prev.Equal(tc.wantPrev) {
+			t.Errorf("dayCutoffs(%d) = %v, %v, want %v, %v", tc.days, recent, prev, tc.wantRecent, tc.wantPrev)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/metrics"
+	"github.com/medyagh/gopogh/pkg/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gopogh_db_query_duration_seconds",
+		Help:    "Duration of the database queries by Datab method, including the callbacks of the Each methods.",
+		Buckets: metrics.DefBuckets,
+	}, []string{"query"})
+	queryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gopogh_db_query_errors_total",
+		Help: "Number of failed database queries by Datab method.",
+	}, []string{"query"})
+)
+
+// instrumented times the queries of a database
+type instrumented struct {
+	d Datab
+}
+
+// Instrument returns the database recording the duration and errors of each of its queries in the metrics
+func Instrument(d Datab) Datab {
+	return instrumented{d}
+}
+
+// observe records a query that started at start and returned err
+func observe(query string, start time.Time, err error) {
+	queryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+	if err != nil {
+		queryErrors.WithLabelValues(query).Inc()
+	}
+}
+
+func (m instrumented) Set(ctx context.Context, env models.DBEnvironmentTest, tests []models.DBTestCase) (err error) {
+	defer func(start time.Time) { observe("Set", start, err) }(time.Now())
+	return m.d.Set(ctx, env, tests)
+}
+
+func (m instrumented) Initialize(ctx context.Context) (err error) {
+	defer func(start time.Time) { observe("Initialize", start, err) }(time.Now())
+	return m.d.Initialize(ctx)
+}
+
+func (m instrumented) EachEnvironmentTest(ctx context.Context, f models.RowFilter, fn func(models.DBEnvironmentTest) error) (err error) {
+	defer func(start time.Time) { observe("EachEnvironmentTest", start, err) }(time.Now())
+	return m.d.EachEnvironmentTest(ctx, f, fn)
+}
+
+func (m instrumented) EachTestCase(ctx context.Context, f models.RowFilter, fn func(models.DBTestCase) error) (err error) {
+	defer func(start time.Time) { observe("EachTestCase", start, err) }(time.Now())
+	return m.d.EachTestCase(ctx, f, fn)
+}
+
+func (m instrumented) GetEnvCharts(ctx context.Context, env string, testsInTop int, w models.Window) (data *models.EnvCharts, err error) {
+	defer func(start time.Time) { observe("GetEnvCharts", start, err) }(time.Now())
+	return m.d.GetEnvCharts(ctx, env, testsInTop, w)
+}
+
+func (m instrumented) GetOverview(ctx context.Context, w models.Window) (data *models.Overview, err error) {
+	defer func(start time.Time) { observe("GetOverview", start, err) }(time.Now())
+	return m.d.GetOverview(ctx, w)
+}
+
+func (m instrumented) GetTestCharts(ctx context.Context, env string, test string, branch string, w models.Window) (data *models.TestCharts, err error) {
+	defer func(start time.Time) { observe("GetTestCharts", start, err) }(time.Now())
+	return m.d.GetTestCharts(ctx, env, test, branch, w)
+}
+
+func (m instrumented) GetTestAcrossEnvs(ctx context.Context, test string, w models.Window) (data *models.TestAcrossEnvs, err error) {
+	defer func(start time.Time) { observe("GetTestAcrossEnvs", start, err) }(time.Now())
+	return m.d.GetTestAcrossEnvs(ctx, test, w)
+}
+
+func (m instrumented) SetCommits(ctx context.Context, commits []models.DBCommit) (err error) {
+	defer func(start time.Time) { observe("SetCommits", start, err) }(time.Now())
+	return m.d.SetCommits(ctx, commits)
+}
+
+func (m instrumented) GetCommitHistory(ctx context.Context, env string, branch string, limit int, w models.Window) (data *models.CommitHistory, err error) {
+	defer func(start time.Time) { observe("GetCommitHistory", start, err) }(time.Now())
+	return m.d.GetCommitHistory(ctx, env, branch, limit, w)
+}
+
+func (m instrumented) GetTestHistory(ctx context.Context, env string, test string, branch string, w models.Window) (data models.CommitResults, err error) {
+	defer func(start time.Time) { observe("GetTestHistory", start, err) }(time.Now())
+	return m.d.GetTestHistory(ctx, env, test, branch, w)
+}
+
+func (m instrumented) GetDurationStats(ctx context.Context, env string, recentSince time.Time, baselineSince time.Time, until time.Time) (data []models.DurationStats, err error) {
+	defer func(start time.Time) { observe("GetDurationStats", start, err) }(time.Now())
+	return m.d.GetDurationStats(ctx, env, recentSince, baselineSince, until)
+}
+
+func (m instrumented) GetPRResults(ctx context.Context, pr string, w models.Window) (data *models.PRResults, err error) {
+	defer func(start time.Time) { observe("GetPRResults", start, err) }(time.Now())
+	return m.d.GetPRResults(ctx, pr, w)
+}
+
+func (m instrumented) GetEnvs(ctx context.Context) (data *models.EnvList, err error) {
+	defer func(start time.Time) { observe("GetEnvs", start, err) }(time.Now())
+	return m.d.GetEnvs(ctx)
+}
+
+func (m instrumented) SearchTests(ctx context.Context, query string, regex bool, page int, perPage int, w models.Window) (data *models.TestList, err error) {
+	defer func(start time.Time) { observe("SearchTests", start, err) }(time.Now())
+	return m.d.SearchTests(ctx, query, regex, page, perPage, w)
+}
+
+func (m instrumented) SetQuarantine(ctx context.Context, q models.DBQuarantine) (err error) {
+	defer func(start time.Time) { observe("SetQuarantine", start, err) }(time.Now())
+	return m.d.SetQuarantine(ctx, q)
+}
+
+func (m instrumented) GetQuarantine(ctx context.Context, env string) (data []models.DBQuarantine, err error) {
+	defer func(start time.Time) { observe("GetQuarantine", start, err) }(time.Now())
+	return m.d.GetQuarantine(ctx, env)
+}
+
+func (m instrumented) DeleteQuarantine(ctx context.Context, env string, test string) (deleted bool, err error) {
+	defer func(start time.Time) { observe("DeleteQuarantine", start, err) }(time.Now())
+	return m.d.DeleteQuarantine(ctx, env, test)
+}
+
+func (m instrumented) Ping(ctx context.Context) (err error) {
+	defer func(start time.Time) { observe("Ping", start, err) }(time.Now())
+	return m.d.Ping(ctx)
+}
+
+func (m instrumented) GetSchemaVersion(ctx context.Context) (version int, err error) {
+	defer func(start time.Time) { observe("GetSchemaVersion", start, err) }(time.Now())
+	return m.d.GetSchemaVersion(ctx)
+}
+
+func (m instrumented) GetEnvHealth(ctx context.Context, flakeRate float32, w models.Window) (envs []models.DBEnvHealth, err error) {
+	defer func(start time.Time) { observe("GetEnvHealth", start, err) }(time.Now())
+	return m.d.GetEnvHealth(ctx, flakeRate, w)
+}
+
+func (m instrumented) GetLastViewRefresh(ctx context.Context) (last *time.Time, err error) {
+	defer func(start time.Time) { observe("GetLastViewRefresh", start, err) }(time.Now())
+	return m.d.GetLastViewRefresh(ctx)
+}
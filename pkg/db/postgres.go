@@ -1,13 +1,17 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq" // Blank import used for registering postgres driver as a database driver
+	"github.com/lib/pq" // Also registers the postgres driver as a database driver
+	"github.com/medyagh/gopogh/pkg/analysis"
 	"github.com/medyagh/gopogh/pkg/models"
 )
 
@@ -21,9 +25,18 @@ var pgEnvTableSchema = `
 		NumberOfPass INTEGER,
 		NumberOfSkip INTEGER,
 		TotalDuration FLOAT,
+		Branch TEXT NOT NULL DEFAULT '',
+		CommitTime TIMESTAMP,
 		PRIMARY KEY (CommitID, EnvName)
 	);
 `
+
+// pgEnvTableMigration adds the columns added after the environment tests table was first released
+var pgEnvTableMigration = `
+	ALTER TABLE db_environment_tests
+		ADD COLUMN IF NOT EXISTS Branch TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS CommitTime TIMESTAMP;
+`
 var pgTestCasesTableSchema = `
 	CREATE TABLE IF NOT EXISTS db_test_cases (
 		PR TEXT,
@@ -33,21 +46,92 @@ var pgTestCasesTableSchema = `
 		Result TEXT,
 		TestTime TIMESTAMP,
 		Duration FLOAT,
+		Signature TEXT NOT NULL DEFAULT '',
 		PRIMARY KEY (CommitID, EnvName, TestName)
 	);
 `
 
+// pgTestCasesTableMigration adds the columns added after the test cases table was first released
+var pgTestCasesTableMigration = `
+	ALTER TABLE db_test_cases
+		ADD COLUMN IF NOT EXISTS Signature TEXT NOT NULL DEFAULT '';
+`
+
+var pgCommitsTableSchema = `
+	CREATE TABLE IF NOT EXISTS db_commits (
+		CommitID TEXT,
+		ParentID TEXT,
+		Branch TEXT,
+		CommitTime TIMESTAMP,
+		PRIMARY KEY (CommitID)
+	);
+`
+
+var pgQuarantineTableSchema = `
+	CREATE TABLE IF NOT EXISTS db_quarantine (
+		EnvName TEXT NOT NULL DEFAULT '',
+		TestName TEXT,
+		Owner TEXT NOT NULL DEFAULT '',
+		Reason TEXT NOT NULL DEFAULT '',
+		IssueURL TEXT NOT NULL DEFAULT '',
+		Expiry TIMESTAMP,
+		CreatedAt TIMESTAMP,
+		PRIMARY KEY (EnvName, TestName)
+	);
+`
+
+var pgSchemaVersionTableSchema = `
+	CREATE TABLE IF NOT EXISTS db_schema_version (
+		Version INTEGER NOT NULL
+	);
+`
+
+// pgCommitOrder orders the runs of db_environment_tests e by commit, using the imported commits c when available
+const pgCommitOrder = `COALESCE(c.CommitTime, e.CommitTime, e.TestTime)`
+
+// pgCommitBranch is the branch of the runs of db_environment_tests e, using the imported commits c when available
+const pgCommitBranch = `COALESCE(NULLIF(e.Branch, ''), c.Branch, '')`
+
+// pgCommitResultJSON builds a json object matching models.CommitResult from a db_test_cases row
+const pgCommitResultJSON = `JSON_BUILD_OBJECT('commit', CommitID, 'result', Result, 'duration', Duration, 'pr', PR, 'time', TestTime AT TIME ZONE 'UTC')`
+
 // Postgres is a Postgres database database struct instance
 type Postgres struct {
 	db   *sqlx.DB
 	path string
+
+	// knownEnvs caches the environments found by validEnv, environments are never removed
+	knownEnvsMu sync.Mutex
+	knownEnvs   map[string]bool
+}
+
+// Set adds/updates rows to the database, retrying with backoff when it fails on the connection.
+// Retrying is safe as the rows are upserted, even if the commit of a failed attempt went through
+func (m *Postgres) Set(ctx context.Context, commitRow models.DBEnvironmentTest, dbRows []models.DBTestCase) error {
+	return m.setAttempts(ctx, 0, commitRow, dbRows)
+}
+
+// setAttempts is Set canceling each of its attempts after attemptTimeout, 0 to not time them out
+func (m *Postgres) setAttempts(ctx context.Context, attemptTimeout time.Duration, commitRow models.DBEnvironmentTest, dbRows []models.DBTestCase) error {
+	what := fmt.Sprintf("store the run of %s at %s", commitRow.EnvName, commitRow.CommitID)
+	return retry(ctx, attemptTimeout, setBackoff, what, func(ctx context.Context) error {
+		return m.set(ctx, commitRow, dbRows)
+	})
 }
 
-// Set adds/updates rows to the database
-func (m *Postgres) Set(commitRow models.DBEnvironmentTest, dbRows []models.DBTestCase) error {
-	tx, err := m.db.Begin()
+// set adds/updates rows to the database in a transaction, copying the test cases of large runs
+func (m *Postgres) set(ctx context.Context, commitRow models.DBEnvironmentTest, dbRows []models.DBTestCase) error {
+	// the copy needs the connection of the transaction
+	conn, err := m.db.Conn(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create SQL transaction: %v", err)
+		return retryable(fmt.Errorf("failed to get a database connection: %v", err), err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return retryable(fmt.Errorf("failed to create SQL transaction: %v", err), err)
 	}
 
 	var rollbackError error
@@ -57,43 +141,56 @@ func (m *Postgres) Set(commitRow models.DBEnvironmentTest, dbRows []models.DBTes
 		}
 	}()
 
+	if len(dbRows) >= copyMinRows {
+		err = copyTestCases(ctx, conn, tx, dbRows)
+	} else {
+		err = insertTestCases(ctx, tx, dbRows)
+	}
+	if err != nil {
+		return err
+	}
+
 	sqlInsert := `
-		INSERT INTO db_test_cases (PR, CommitId, EnvName, TestName, Result, TestTime, Duration)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO db_environment_tests (CommitID, EnvName, GopoghTime, TestTime, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration, Branch, CommitTime) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (CommitId, EnvName)
+		DO UPDATE SET (GopoghTime, TestTime, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration, Branch, CommitTime) = (EXCLUDED.GopoghTime, EXCLUDED.TestTime, EXCLUDED.NumberOfFail, EXCLUDED.NumberOfPass, EXCLUDED.NumberOfSkip, EXCLUDED.TotalDuration, EXCLUDED.Branch, EXCLUDED.CommitTime)
+		`
+	_, err = tx.ExecContext(ctx, sqlInsert, commitRow.CommitID, commitRow.EnvName, commitRow.GopoghTime, commitRow.TestTime, commitRow.NumberOfFail, commitRow.NumberOfPass, commitRow.NumberOfSkip, commitRow.TotalDuration, commitRow.Branch, commitRow.CommitTime)
+	if err != nil {
+		return retryable(fmt.Errorf("failed to execute SQL insert: %v", err), err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return retryable(fmt.Errorf("failed to commit SQL insert transaction: %v", err), err)
+	}
+	return rollbackError
+}
+
+// insertTestCases upserts the test cases one statement at a time
+func insertTestCases(ctx context.Context, tx *sql.Tx, dbRows []models.DBTestCase) error {
+	sqlInsert := `
+		INSERT INTO db_test_cases (PR, CommitId, EnvName, TestName, Result, TestTime, Duration, Signature)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		ON CONFLICT (CommitId, EnvName, TestName)
-		DO UPDATE SET (PR, Result, TestTime, Duration) = (EXCLUDED.PR, EXCLUDED.Result, EXCLUDED.TestTime, EXCLUDED.Duration)
+		DO UPDATE SET (PR, Result, TestTime, Duration, Signature) = (EXCLUDED.PR, EXCLUDED.Result, EXCLUDED.TestTime, EXCLUDED.Duration, EXCLUDED.Signature)
 	`
-	stmt, err := tx.Prepare(sqlInsert)
+	stmt, err := tx.PrepareContext(ctx, sqlInsert)
 	if err != nil {
-		return fmt.Errorf("failed to prepare SQL insert statement: %v", err)
+		return retryable(fmt.Errorf("failed to prepare SQL insert statement: %v", err), err)
 	}
 	defer func() {
 		_ = stmt.Close()
 	}()
 
 	for _, r := range dbRows {
-		_, err := stmt.Exec(r.PR, r.CommitID, r.EnvName, r.TestName, r.Result, r.TestTime, r.Duration)
+		_, err := stmt.ExecContext(ctx, r.PR, r.CommitID, r.EnvName, r.TestName, r.Result, r.TestTime, r.Duration, r.Signature)
 		if err != nil {
-			return fmt.Errorf("failed to execute SQL insert: %v", err)
+			return retryable(fmt.Errorf("failed to execute SQL insert: %v", err), err)
 		}
 	}
-
-	sqlInsert = `
-		INSERT INTO db_environment_tests (CommitID, EnvName, GopoghTime, TestTime, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		ON CONFLICT (CommitId, EnvName)
-		DO UPDATE SET (GopoghTime, TestTime, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration) = (EXCLUDED.GopoghTime, EXCLUDED.TestTime, EXCLUDED.NumberOfFail, EXCLUDED.NumberOfPass, EXCLUDED.NumberOfSkip, EXCLUDED.TotalDuration)
-		`
-	_, err = tx.Exec(sqlInsert, commitRow.CommitID, commitRow.EnvName, commitRow.GopoghTime, commitRow.TestTime, commitRow.NumberOfFail, commitRow.NumberOfPass, commitRow.NumberOfSkip, commitRow.TotalDuration)
-	if err != nil {
-		return fmt.Errorf("failed to execute SQL insert: %v", err)
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		return fmt.Errorf("failed to commit SQL insert transaction: %v", err)
-	}
-	return rollbackError
+	return nil
 }
 
 // newPostgres opens the database returning a Postgres database struct instance
@@ -111,84 +208,483 @@ func newPostgres(cfg config) (*Postgres, error) {
 }
 
 // Initialize creates the tables within the Postgres database
-func (m *Postgres) Initialize() error {
-	if _, err := m.db.Exec(pgEnvTableSchema); err != nil {
+func (m *Postgres) Initialize(ctx context.Context) error {
+	if _, err := m.db.ExecContext(ctx, pgEnvTableSchema); err != nil {
 		return fmt.Errorf("failed to initialize environment tests table: %v", err)
 	}
-	if _, err := m.db.Exec(pgTestCasesTableSchema); err != nil {
+	if _, err := m.db.ExecContext(ctx, pgEnvTableMigration); err != nil {
+		return fmt.Errorf("failed to migrate environment tests table: %v", err)
+	}
+	if _, err := m.db.ExecContext(ctx, pgTestCasesTableSchema); err != nil {
 		return fmt.Errorf("failed to initialize test cases table: %v", err)
 	}
+	if _, err := m.db.ExecContext(ctx, pgTestCasesTableMigration); err != nil {
+		return fmt.Errorf("failed to migrate test cases table: %v", err)
+	}
+	if _, err := m.db.ExecContext(ctx, pgCommitsTableSchema); err != nil {
+		return fmt.Errorf("failed to initialize commits table: %v", err)
+	}
+	if _, err := m.db.ExecContext(ctx, pgQuarantineTableSchema); err != nil {
+		return fmt.Errorf("failed to initialize quarantine table: %v", err)
+	}
+	if _, err := m.db.ExecContext(ctx, pgSchemaVersionTableSchema); err != nil {
+		return fmt.Errorf("failed to initialize schema version table: %v", err)
+	}
+	// the version is never lowered, so that a server of a previous version does not hide the changes of a newer one
+	if _, err := m.db.ExecContext(ctx, `INSERT INTO db_schema_version (Version) SELECT $1 WHERE NOT EXISTS (SELECT 1 FROM db_schema_version)`, SchemaVersion); err != nil {
+		return fmt.Errorf("failed to record schema version: %v", err)
+	}
+	if _, err := m.db.ExecContext(ctx, `UPDATE db_schema_version SET Version = $1 WHERE Version < $1`, SchemaVersion); err != nil {
+		return fmt.Errorf("failed to record schema version: %v", err)
+	}
 	return nil
 }
 
-// GetEnvironmentTestsAndTestCases writes the database tables to a map with the keys environmentTests and testCases
-func (m *Postgres) GetEnvironmentTestsAndTestCases() (map[string]interface{}, error) {
+// Ping checks that the database can be reached
+func (m *Postgres) Ping(ctx context.Context) error {
+	return m.db.PingContext(ctx)
+}
+
+// GetSchemaVersion returns the version of the tables recorded by Initialize, 0 if none is recorded
+func (m *Postgres) GetSchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	if err := m.db.GetContext(ctx, &version, `SELECT COALESCE(MAX(Version), 0) FROM db_schema_version`); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	return version, nil
+}
+
+// GetLastViewRefresh returns when the pg_cron job refreshing the materialized views last succeeded,
+// nil if pg_cron is not installed in the database
+func (m *Postgres) GetLastViewRefresh(ctx context.Context) (*time.Time, error) {
+	var installed bool
+	if err := m.db.GetContext(ctx, &installed, `SELECT to_regclass('cron.job_run_details') IS NOT NULL`); err != nil {
+		return nil, fmt.Errorf("failed to look for pg_cron: %v", err)
+	}
+	if !installed {
+		return nil, nil
+	}
+	var last *time.Time
+	err := m.db.GetContext(ctx, &last, `
+	SELECT MAX(end_time) FROM cron.job_run_details
+	WHERE status = 'succeeded' AND command ILIKE '%REFRESH MATERIALIZED VIEW%'
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the pg_cron job runs: %v", err)
+	}
+	return last, nil
+}
+
+// SetCommits adds/updates the imported commits
+func (m *Postgres) SetCommits(ctx context.Context, commits []models.DBCommit) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create SQL transaction: %v", err)
+	}
+
+	var rollbackError error
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil {
+			rollbackError = fmt.Errorf("error occurred during rollback: %v", rErr)
+		}
+	}()
+
+	sqlInsert := `
+		INSERT INTO db_commits (CommitID, ParentID, Branch, CommitTime)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (CommitID)
+		DO UPDATE SET (ParentID, Branch, CommitTime) = (EXCLUDED.ParentID, EXCLUDED.Branch, EXCLUDED.CommitTime)
+	`
+	stmt, err := tx.PrepareContext(ctx, sqlInsert)
+	if err != nil {
+		return fmt.Errorf("failed to prepare SQL insert statement: %v", err)
+	}
+	defer func() {
+		_ = stmt.Close()
+	}()
+
+	for _, c := range commits {
+		if _, err := stmt.ExecContext(ctx, c.CommitID, c.ParentID, c.Branch, c.CommitTime); err != nil {
+			return fmt.Errorf("failed to execute SQL insert: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit SQL insert transaction: %v", err)
+	}
+	return rollbackError
+}
+
+// GetCommitHistory returns the last runs of an environment in the window in commit order, optionally only the commits of a branch
+func (m *Postgres) GetCommitHistory(ctx context.Context, env string, branch string, limit int, w models.Window) (*models.CommitHistory, error) {
 	start := time.Now()
 
-	var environmentTests []models.DBEnvironmentTest
-	var testCases []models.DBTestCase
+	sqlQuery := fmt.Sprintf(`
+	SELECT e.CommitID, e.EnvName, e.GopoghTime, e.TestTime, e.NumberOfFail, e.NumberOfPass, e.NumberOfSkip, e.TotalDuration,
+	%s AS Branch, COALESCE(c.CommitTime, e.CommitTime) AS CommitTime
+	FROM db_environment_tests e
+	LEFT JOIN db_commits c ON c.CommitID = e.CommitID
+	WHERE e.EnvName = $1 AND ($2 = '' OR %s = $2) AND %s
+	ORDER BY %s DESC
+	LIMIT $3
+	`, pgCommitBranch, pgCommitBranch, pgWindow("e.TestTime", w), pgCommitOrder)
+	var commits []models.DBEnvironmentTest
+	err := m.db.SelectContext(ctx, &commits, sqlQuery, env, branch, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for commit history: %v", err)
+	}
+	log.Printf("\nduration metric: took %f seconds to gather commit history since start of handler\n\n", time.Since(start).Seconds())
+	return &models.CommitHistory{EnvName: env, Branch: branch, Commits: commits}, nil
+}
+
+// pgRowsQuery returns the query and arguments of the rows of the table matching the filter, most recent first
+func (m *Postgres) pgRowsQuery(columns string, table string, f models.RowFilter) (string, []interface{}) {
+	testCases := table == "db_test_cases"
+	conditions, args := rowConditions(f, testCases)
+	conditions = append(conditions, pgWindow("TestTime", f.Window))
+	order := "TestTime DESC, EnvName, CommitID"
+	if f.After != nil {
+		if testCases {
+			conditions = append(conditions, "(TestTime < ? OR (TestTime = ? AND (EnvName, CommitID, TestName) > (?, ?, ?)))")
+			args = append(args, f.After.TestTime, f.After.TestTime, f.After.EnvName, f.After.CommitID, f.After.TestName)
+		} else {
+			conditions = append(conditions, "(TestTime < ? OR (TestTime = ? AND (EnvName, CommitID) > (?, ?)))")
+			args = append(args, f.After.TestTime, f.After.TestTime, f.After.EnvName, f.After.CommitID)
+		}
+	}
+	if testCases {
+		order += ", TestName"
+	}
+	sqlQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s", columns, table, strings.Join(conditions, " AND "), order)
+	if f.Limit > 0 {
+		sqlQuery += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+	return m.db.Rebind(sqlQuery), args
+}
 
-	err := m.db.Select(&environmentTests, "SELECT * FROM db_environment_tests ORDER BY TestTime DESC LIMIT 100")
+// EachEnvironmentTest calls fn on the environment tests matching the filter, most recent first, stopping at the first error
+func (m *Postgres) EachEnvironmentTest(ctx context.Context, f models.RowFilter, fn func(models.DBEnvironmentTest) error) error {
+	start := time.Now()
+
+	sqlQuery, args := m.pgRowsQuery("CommitID, EnvName, GopoghTime, TestTime, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration, Branch, CommitTime", "db_environment_tests", f)
+	rows, err := m.db.QueryxContext(ctx, sqlQuery, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute SQL query for environment tests: %v", err)
+		return fmt.Errorf("failed to execute SQL query for environment tests: %v", err)
 	}
+	defer rows.Close()
+	for rows.Next() {
+		var row models.DBEnvironmentTest
+		if err := rows.StructScan(&row); err != nil {
+			return fmt.Errorf("failed to scan environment test: %v", err)
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	log.Printf("\nduration metric: took %f seconds to gather environment tests since start of handler\n\n", time.Since(start).Seconds())
+	return rows.Err()
+}
 
-	err = m.db.Select(&testCases, "SELECT * FROM db_test_cases ORDER BY TestTime DESC LIMIT 100")
+// EachTestCase calls fn on the test cases matching the filter, most recent first, stopping at the first error
+func (m *Postgres) EachTestCase(ctx context.Context, f models.RowFilter, fn func(models.DBTestCase) error) error {
+	start := time.Now()
+
+	sqlQuery, args := m.pgRowsQuery("PR, CommitID, EnvName, TestName, Result, TestTime, Duration, Signature", "db_test_cases", f)
+	rows, err := m.db.QueryxContext(ctx, sqlQuery, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute SQL query for test cases: %v", err)
+		return fmt.Errorf("failed to execute SQL query for test cases: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row models.DBTestCase
+		if err := rows.StructScan(&row); err != nil {
+			return fmt.Errorf("failed to scan test case: %v", err)
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	log.Printf("\nduration metric: took %f seconds to gather test cases since start of handler\n\n", time.Since(start).Seconds())
+	return rows.Err()
+}
 
+// GetPRResults returns the runs of a pull request in the window on every environment,
+// with the flake rate of their failed tests in the recent window of the runs of the environment that are not of a pull request
+func (m *Postgres) GetPRResults(ctx context.Context, pr string, w models.Window) (*models.PRResults, error) {
+	start := time.Now()
+
+	sqlQuery := fmt.Sprintf(`
+	SELECT e.CommitID, e.EnvName, e.GopoghTime, e.TestTime, e.NumberOfFail, e.NumberOfPass, e.NumberOfSkip, e.TotalDuration, e.Branch, e.CommitTime
+	FROM db_environment_tests e
+	WHERE %s AND EXISTS (SELECT 1 FROM db_test_cases t WHERE t.CommitID = e.CommitID AND t.EnvName = e.EnvName AND t.PR = $1)
+	`, pgWindow("e.TestTime", w))
+	var runs []models.DBEnvironmentTest
+	if err := m.db.SelectContext(ctx, &runs, sqlQuery, pr); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for pull request runs: %v", err)
 	}
-	data := map[string]interface{}{
-		"environmentTests": environmentTests,
-		"testCases":        testCases,
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for pull request runs since start of handler", time.Since(start).Seconds())
+
+	// Finds the failed tests of the pull request
+	// Then calculates their flake rate in the recent runs of their environment that are not of a pull request
+	sqlQuery = fmt.Sprintf(`
+	WITH failed AS (
+		SELECT EnvName, CommitID, TestName
+		FROM db_test_cases
+		WHERE PR = $1 AND Result = 'fail' AND %s
+	), base AS (
+		SELECT EnvName, TestName,
+		AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100 AS FlakePercentage,
+		COUNT(*) AS BaseRuns
+		FROM db_test_cases
+		WHERE COALESCE(PR, '') = '' AND Result != 'skip' AND %s
+		AND (EnvName, TestName) IN (SELECT EnvName, TestName FROM failed)
+		GROUP BY EnvName, TestName
+	)
+	SELECT f.EnvName, f.CommitID, f.TestName,
+	ROUND(COALESCE(b.FlakePercentage, 0), 2) AS FlakePercentage, COALESCE(b.BaseRuns, 0) AS BaseRuns
+	FROM failed f
+	LEFT JOIN base b ON b.EnvName = f.EnvName AND b.TestName = f.TestName
+	`, pgWindow("TestTime", w), pgWindow("TestTime", recentWindow(w)))
+	var failed []models.DBPRFailedTest
+	if err := m.db.SelectContext(ctx, &failed, sqlQuery, pr); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for pull request failed tests: %v", err)
 	}
-	log.Printf("\nduration metric: took %f seconds to gather all table data since start of handler\n\n", time.Since(start).Seconds())
+	log.Printf("\nduration metric: took %f seconds to gather pull request results since start of handler\n\n", time.Since(start).Seconds())
+	return prResults(pr, runs, failed), nil
+}
+
+// validEnv checks the environment is in the database, the environment name is used in the SQL of its materialized view so it must be an existing one
+func (m *Postgres) validEnv(ctx context.Context, env string) error {
+	m.knownEnvsMu.Lock()
+	defer m.knownEnvsMu.Unlock()
+	if m.knownEnvs[env] {
+		return nil
+	}
+	var exists bool
+	if err := m.db.GetContext(ctx, &exists, "SELECT EXISTS (SELECT 1 FROM db_environment_tests WHERE EnvName = $1)", env); err != nil {
+		return fmt.Errorf("failed to execute SQL query for valid environment: %v", err)
+	}
+	if !exists {
+		return fmt.Errorf("invalid environment. Not found in database: %s", env)
+	}
+	if m.knownEnvs == nil {
+		m.knownEnvs = map[string]bool{}
+	}
+	m.knownEnvs[env] = true
+	return nil
+}
+
+// GetEnvs returns every environment with its most recent run
+func (m *Postgres) GetEnvs(ctx context.Context) (*models.EnvList, error) {
+	start := time.Now()
+
+	sqlQuery := `
+	SELECT EnvName, COUNT(*) AS Runs, MIN(TestTime) AS FirstTestTime, MAX(TestTime) AS LastTestTime,
+	(ARRAY_AGG(CommitID ORDER BY TestTime DESC))[1] AS LastCommitID,
+	(ARRAY_AGG(NumberOfPass + NumberOfFail + NumberOfSkip ORDER BY TestTime DESC))[1] AS LastNumberOfTests,
+	(ARRAY_AGG(NumberOfFail ORDER BY TestTime DESC))[1] AS LastNumberOfFail
+	FROM db_environment_tests
+	GROUP BY EnvName
+	ORDER BY EnvName;
+	`
+	var envs []models.DBEnvInfo
+	if err := m.db.SelectContext(ctx, &envs, sqlQuery); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for environments: %v", err)
+	}
+	log.Printf("\nduration metric: took %f seconds to gather environments since start of handler\n\n", time.Since(start).Seconds())
+	return &models.EnvList{Envs: envs}, nil
+}
+
+// GetEnvHealth lists the environments with their most recent run regardless of the window,
+// and the number of their tests whose flake percentage in the recent window of w is at least flakeRate
+func (m *Postgres) GetEnvHealth(ctx context.Context, flakeRate float32, w models.Window) ([]models.DBEnvHealth, error) {
+	start := time.Now()
+
+	sqlQuery := fmt.Sprintf(`
+	WITH latest AS (
+		SELECT DISTINCT ON (EnvName) EnvName, TestTime, NumberOfPass + NumberOfFail + NumberOfSkip AS NumberOfTests, NumberOfFail
+		FROM db_environment_tests
+		ORDER BY EnvName, TestTime DESC
+	), rates AS (
+		SELECT EnvName, ROUND(COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0), 2) AS FlakePercentage
+		FROM db_test_cases
+		WHERE Result != 'skip' AND %s
+		GROUP BY EnvName, TestName
+	), flaky AS (
+		SELECT EnvName, COUNT(*) AS FlakyTests
+		FROM rates
+		WHERE FlakePercentage >= $1
+		GROUP BY EnvName
+	)
+	SELECT l.EnvName, l.TestTime AS LastTestTime, l.NumberOfTests AS LastNumberOfTests, l.NumberOfFail AS LastNumberOfFail,
+	COALESCE(f.FlakyTests, 0) AS FlakyTests
+	FROM latest l
+	LEFT JOIN flaky f USING (EnvName)
+	ORDER BY l.EnvName;
+	`, pgWindow("TestTime", recentWindow(w)))
+	var envs []models.DBEnvHealth
+	if err := m.db.SelectContext(ctx, &envs, sqlQuery, flakeRate); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for environment health: %v", err)
+	}
+	log.Printf("\nduration metric: took %f seconds to gather environment health since start of handler\n\n", time.Since(start).Seconds())
+	return envs, nil
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchTests returns a page of the names of the tests in the window containing the query, or matching it as a regular expression, ignoring case
+func (m *Postgres) SearchTests(ctx context.Context, query string, regex bool, page int, perPage int, w models.Window) (*models.TestList, error) {
+	start := time.Now()
+
+	match := "TestName ILIKE '%' || $1 || '%'"
+	if regex {
+		match = "TestName ~* $1"
+	} else {
+		query = likeEscaper.Replace(query)
+	}
+	sqlQuery := fmt.Sprintf(`
+	WITH tests AS (
+		SELECT TestName, COUNT(DISTINCT EnvName) AS EnvCount, MAX(TestTime) AS LastTestTime
+		FROM db_test_cases
+		WHERE %s AND %s
+		GROUP BY TestName
+	)
+	SELECT TestName, EnvCount, LastTestTime, COUNT(*) OVER () AS Total
+	FROM tests
+	ORDER BY TestName
+	LIMIT $2 OFFSET $3;
+	`, pgWindow("TestTime", w), match)
+	var rows []struct {
+		models.DBTestInfo
+		Total int
+	}
+	if err := m.db.SelectContext(ctx, &rows, sqlQuery, query, perPage, (page-1)*perPage); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for test search: %v", err)
+	}
+	data := &models.TestList{Tests: []models.DBTestInfo{}, Page: page, PerPage: perPage}
+	for _, r := range rows {
+		data.Tests = append(data.Tests, r.DBTestInfo)
+		data.Total = r.Total
+	}
+	// the total is only known from the rows of the page, past the last page it is counted separately
+	if len(rows) == 0 && page > 1 {
+		countQuery := fmt.Sprintf(`
+		SELECT COUNT(DISTINCT TestName)
+		FROM db_test_cases
+		WHERE %s AND %s
+		`, pgWindow("TestTime", w), match)
+		if err := m.db.GetContext(ctx, &data.Total, countQuery, query); err != nil {
+			return nil, fmt.Errorf("failed to execute SQL query for test search count: %v", err)
+		}
+	}
+	log.Printf("\nduration metric: took %f seconds to search tests since start of handler\n\n", time.Since(start).Seconds())
 	return data, nil
 }
 
-func (m *Postgres) createMaterializedView(env string, viewName string) error {
+// createMaterializedView creates the view of the last 90 days of test cases of the environment,
+// statements creating views cannot take bind parameters so the environment is quoted as a literal
+func (m *Postgres) createMaterializedView(ctx context.Context, env string, viewName string) error {
 	createView := fmt.Sprintf(`
 	CREATE MATERIALIZED VIEW IF NOT EXISTS %s AS 
 		SELECT * FROM db_test_cases
-		WHERE Result != 'skip' AND EnvName = '%s' AND TestTime >= NOW() - INTERVAL '90 days'
-	`, viewName, env)
+		WHERE Result != 'skip' AND EnvName = %s AND TestTime >= NOW() - INTERVAL '90 days'
+	`, viewName, pq.QuoteLiteral(env))
 
-	if _, err := m.db.Exec(createView); err != nil {
+	if _, err := m.db.ExecContext(ctx, createView); err != nil {
 		return err
 	}
 
 	// if we add a new test environment the service account will be the owner of the newly created materalized view above
 	// but we require postgres to be the owner for the cron that refreshes the materialized view to run
 	alterOwner := fmt.Sprintf("ALTER MATERIALIZED VIEW %s OWNER TO postgres;", viewName)
-	if _, err := m.db.Exec(alterOwner); err != nil {
+	if _, err := m.db.ExecContext(ctx, alterOwner); err != nil {
 		return err
 	}
 	return nil
 }
 
-// GetTestCharts writes the individual test chart data to a map with the keys flakeByDay and flakeByWeek
-func (m *Postgres) GetTestCharts(env string, test string) (map[string]interface{}, error) {
-	start := time.Now()
+// pgTimeLayout formats the times of a window as timestamp literals, they are formatted from time.Time values so they are safe in SQL
+const pgTimeLayout = "2006-01-02 15:04:05.999999"
+
+// pgWindow is the SQL condition of a time column being in the window
+func pgWindow(column string, w models.Window) string {
+	return fmt.Sprintf("%s >= '%s' AND %s < '%s'", column, w.From.UTC().Format(pgTimeLayout), column, w.To.UTC().Format(pgTimeLayout))
+}
 
-	var validEnvs []string
-	err := m.db.Select(&validEnvs, "SELECT DISTINCT EnvName FROM db_environment_tests")
+// viewSlack is how far before 90 days ago a window can start and still be read from the materialized view,
+// the default window is created shortly before its query and the views are refreshed periodically so they reach back further than 90 days
+const viewSlack = time.Hour
+
+// testSource validates the environment and returns a subquery of its non skipped test cases in the window
+// reading its materialized view of the last 90 days if the window starts within it.
+// The subquery takes the environment as the bind parameter $1 of the queries reading it
+func (m *Postgres) testSource(ctx context.Context, env string, w models.Window) (string, error) {
+	viewName, err := m.testView(ctx, env)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute SQL query for list of valid environments: %v", err)
+		return "", err
 	}
-	isValidEnv := false
-	for _, e := range validEnvs {
-		if env == e {
-			isValidEnv = true
-		}
+	if !w.From.Before(time.Now().AddDate(0, 0, -defaultWindowDays).Add(-viewSlack)) {
+		return fmt.Sprintf("(SELECT * FROM %s WHERE EnvName = $1 AND %s)", viewName, pgWindow("TestTime", w)), nil
+	}
+	return fmt.Sprintf("(SELECT * FROM db_test_cases WHERE Result != 'skip' AND EnvName = $1 AND %s)", pgWindow("TestTime", w)), nil
+}
+
+// testView validates the environment and returns the name of its materialized view of the last 90 days of test cases
+func (m *Postgres) testView(ctx context.Context, env string) (string, error) {
+	if err := m.validEnv(ctx, env); err != nil {
+		return "", err
+	}
+
+	viewName := pq.QuoteIdentifier("lastn_data_" + env)
+	if err := m.createMaterializedView(ctx, env, viewName); err != nil {
+		return "", fmt.Errorf("failed to execute SQL query for view creation: %v", err)
 	}
-	if !isValidEnv {
-		return nil, fmt.Errorf("invalid environment. Not found in database: %v", err)
+	return viewName, nil
+}
+
+// testHistory returns the results of a test in the source of testSource
+// ordered by the commit they ran on, optionally only on the commits of a branch
+func (m *Postgres) testHistory(ctx context.Context, source string, env string, test string, branch string) (models.CommitResults, error) {
+	sqlQuery := fmt.Sprintf(`
+	SELECT
+	JSON_AGG(JSON_BUILD_OBJECT('commit', t.CommitID, 'result', t.Result, 'duration', t.Duration, 'pr', t.PR, 'time', %s AT TIME ZONE 'UTC') ORDER BY %s)
+	FROM %s AS t
+	JOIN db_environment_tests e ON e.CommitID = t.CommitID AND e.EnvName = t.EnvName
+	LEFT JOIN db_commits c ON c.CommitID = t.CommitID
+	WHERE t.TestName = $2 AND ($3 = '' OR %s = $3)
+	`, pgCommitOrder, pgCommitOrder, source, pgCommitBranch)
+	var history models.CommitResults
+	if err := m.db.GetContext(ctx, &history, sqlQuery, env, test, branch); err != nil {
+		return nil, err
 	}
+	return history, nil
+}
+
+// GetTestHistory returns the results of a test in commit order, oldest first, optionally only on the commits of a branch
+func (m *Postgres) GetTestHistory(ctx context.Context, env string, test string, branch string, w models.Window) (models.CommitResults, error) {
+	start := time.Now()
 
-	viewName := fmt.Sprintf("\"lastn_data_%s\"", env)
-	err = m.createMaterializedView(env, viewName)
+	source, err := m.testSource(ctx, env, w)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute SQL query for view creation: %v", err)
+		return nil, err
+	}
+	history, err := m.testHistory(ctx, source, env, test, branch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for test history: %v", err)
+	}
+	log.Printf("\nduration metric: took %f seconds to gather test history since start of handler\n\n", time.Since(start).Seconds())
+	return history, nil
+}
+
+// GetTestCharts returns the individual test charts by day, week, month and commit, optionally only the commits of a branch are charted by commit
+func (m *Postgres) GetTestCharts(ctx context.Context, env string, test string, branch string, w models.Window) (*models.TestCharts, error) {
+	start := time.Now()
+
+	source, err := m.testSource(ctx, env, w)
+	if err != nil {
+		return nil, err
 	}
 
 	log.Printf("\nduration metric: took %f seconds to execute SQL query for refreshing materialized view since start of handler", time.Since(start).Seconds())
@@ -199,15 +695,15 @@ func (m *Postgres) GetTestCharts(env string, test string) (map[string]interface{
 	DATE_TRUNC('day', TestTime) AS StartOfDate,
 	AVG(Duration) AS AvgDuration,
 	ROUND(COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0), 2) AS FlakePercentage,
-	STRING_AGG(CommitID || ': ' || Result || ': ' || Duration, ', ') AS CommitResultsAndDurations
-	FROM %s 
-	WHERE TestName = $1
+	JSON_AGG(%s ORDER BY TestTime) AS CommitResultsAndDurations
+	FROM %s AS lastn_data
+	WHERE TestName = $2
 	GROUP BY StartOfDate
 	ORDER BY StartOfDate DESC
-	`, viewName)
+	`, pgCommitResultJSON, source)
 
 	var flakeByDay []models.DBTestRateAndDuration
-	err = m.db.Select(&flakeByDay, sqlQuery, test)
+	err = m.db.SelectContext(ctx, &flakeByDay, sqlQuery, env, test)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for flake rate and duration by day chart: %v", err)
 	}
@@ -220,14 +716,14 @@ func (m *Postgres) GetTestCharts(env string, test string) (map[string]interface{
 	DATE_TRUNC('week', TestTime) AS StartOfDate,
 	AVG(Duration) AS AvgDuration,
 	ROUND(COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0), 2) AS FlakePercentage,
-	STRING_AGG(CommitID || ': ' || Result || ': ' || Duration, ', ') AS CommitResultsAndDurations
-	FROM %s 
-	WHERE TestName = $1
+	JSON_AGG(%s ORDER BY TestTime) AS CommitResultsAndDurations
+	FROM %s AS lastn_data
+	WHERE TestName = $2
 	GROUP BY StartOfDate
 	ORDER BY StartOfDate DESC
-	`, viewName)
+	`, pgCommitResultJSON, source)
 	var flakeByWeek []models.DBTestRateAndDuration
-	err = m.db.Select(&flakeByWeek, sqlQuery, test)
+	err = m.db.SelectContext(ctx, &flakeByWeek, sqlQuery, env, test)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for flake rate and duration by week chart: %v", err)
 	}
@@ -239,79 +735,110 @@ func (m *Postgres) GetTestCharts(env string, test string) (map[string]interface{
 	DATE_TRUNC('month', TestTime) AS StartOfDate,
 	AVG(Duration) AS AvgDuration,
 	ROUND(COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0), 2) AS FlakePercentage,
-	STRING_AGG(CommitID || ': ' || Result || ': ' || Duration, ', ') AS CommitResultsAndDurations
-	FROM %s 
-	WHERE TestName = $1
+	JSON_AGG(%s ORDER BY TestTime) AS CommitResultsAndDurations
+	FROM %s AS lastn_data
+	WHERE TestName = $2
 	GROUP BY StartOfDate
 	ORDER BY StartOfDate DESC
-	`, viewName)
+	`, pgCommitResultJSON, source)
 	var flakeByMonth []models.DBTestRateAndDuration
-	err = m.db.Select(&flakeByMonth, sqlQuery, test)
+	err = m.db.SelectContext(ctx, &flakeByMonth, sqlQuery, env, test)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for flake rate and duration by month chart: %v", err)
 	}
 	log.Printf("\nduration metric: took %f seconds to execute SQL query for flake rate and duration by month chart since start of handler", time.Since(start).Seconds())
 
-	data := map[string]interface{}{
-		"flakeByDay":   flakeByDay,
-		"flakeByWeek":  flakeByWeek,
-		"flakeByMonth": flakeByMonth,
+	byCommit, err := m.testHistory(ctx, source, env, test, branch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for results by commit chart: %v", err)
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for results by commit chart since start of handler", time.Since(start).Seconds())
+
+	data := &models.TestCharts{
+		FlakeByDay:   flakeByDay,
+		FlakeByWeek:  flakeByWeek,
+		FlakeByMonth: flakeByMonth,
+		ByCommit:     byCommit,
 	}
 	log.Printf("\nduration metric: took %f seconds to gather individual test chart data since start of handler\n\n", time.Since(start).Seconds())
 	return data, nil
 }
 
-// GetEnvCharts writes the overall environment charts to a map with the keys recentFlakePercentTable, flakeRateByWeek, flakeRateByDay, and countsAndDurations
-func (m *Postgres) GetEnvCharts(env string, testsInTop int) (map[string]interface{}, error) {
+// GetTestAcrossEnvs returns the results of a test in the recent window on every environment it ran on
+func (m *Postgres) GetTestAcrossEnvs(ctx context.Context, test string, w models.Window) (*models.TestAcrossEnvs, error) {
 	start := time.Now()
 
-	var validEnvs []string
-	err := m.db.Select(&validEnvs, "SELECT DISTINCT EnvName FROM db_environment_tests")
+	// Orders the recent runs of the test on each environment by commit (see flakiness.go)
+	// Then calculates the flake percentage, flip rate and consistent failure rate of each environment
+	// and aggregates its most recent results
+	source := fmt.Sprintf("(SELECT * FROM db_test_cases WHERE TestName = $1 AND %s)", pgWindow("TestTime", recentWindow(w)))
+	sqlQuery := fmt.Sprintf(`
+	WITH ordered AS (%s
+	)
+	SELECT EnvName,
+	ROUND(COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0), 2) AS FlakePercentage,
+	%s AS FlipRate,
+	%s AS ConsistentFailureRate,
+	SUM(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) AS FailedTestNum,
+	COUNT(*) AS TotalTestNum,
+	AVG(Duration) AS AvgDuration,
+	JSON_AGG(%s ORDER BY TestTime) FILTER (WHERE Recency <= $2) AS RecentResults
+	FROM ordered
+	GROUP BY EnvName
+	ORDER BY FlakePercentage DESC, EnvName;
+	`, pgFlipOrdered("t.*, ROW_NUMBER() OVER (PARTITION BY t.EnvName ORDER BY t.TestTime DESC) AS Recency", source, "t.EnvName", "TRUE"),
+		pgFlipRate, pgConsistentFailureRate, pgCommitResultJSON)
+	var envs []models.DBTestEnvSummary
+	err := m.db.SelectContext(ctx, &envs, sqlQuery, test, recentResultsPerEnv)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute SQL query for list of valid environments: %v", err)
-	}
-	isValidEnv := false
-	for _, e := range validEnvs {
-		if env == e {
-			isValidEnv = true
-		}
+		return nil, fmt.Errorf("failed to execute SQL query for test across environments: %v", err)
 	}
-	if !isValidEnv {
-		return nil, fmt.Errorf("invalid environment. Not found in database: %v", err)
+	data := &models.TestAcrossEnvs{
+		TestName: test,
+		Envs:     envs,
 	}
+	log.Printf("\nduration metric: took %f seconds to gather test across environments data since start of handler\n\n", time.Since(start).Seconds())
+	return data, nil
+}
 
-	viewName := fmt.Sprintf("\"lastn_data_%s\"", env)
-	err = m.createMaterializedView(env, viewName)
+// GetEnvCharts returns the overall environment charts
+func (m *Postgres) GetEnvCharts(ctx context.Context, env string, testsInTop int, w models.Window) (*models.EnvCharts, error) {
+	start := time.Now()
+
+	source, err := m.testSource(ctx, env, w)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute SQL query for view creation: %v", err)
+		return nil, err
 	}
 
 	log.Printf("\nduration metric: took %f seconds to execute SQL query for refreshing materialized view since start of handler", time.Since(start).Seconds())
 
 	// Number of days to use to look for "flaky-est" tests.
-	const dateRange = 15
+	dateRange := w.Days
 
-	// This query first makes a temp table containing the $1 (30) most recent dates
-	// Then it computes the recentCutoff and prevCutoff (15th most recent and 30th most recent dates)
+	// This query first makes a temp table containing the $2 (2 * dateRange) most recent dates
+	// Then it computes the recentCutoff and prevCutoff (dateRange-th most recent and 2 * dateRange-th most recent dates)
 	// Then we calculate the flake rate and the flake rate growth
-	// for the 15 most recent days and the 15 days following that
+	// for the dateRange most recent days and the dateRange days following that
+	// Then we calculate the flip rate and consistent failure rate of the dateRange most recent days (see flakiness.go)
+	// ranking the tests that flip the most as the flakiest
 	sqlQuer := fmt.Sprintf(`
-	WITH dates AS (
+	WITH lastn_data AS %s
+	, dates AS (
 		SELECT DISTINCT DATE_TRUNC('day', TestTime) AS Date
-		FROM %s
+		FROM lastn_data
 		ORDER BY Date DESC
-		LIMIT $1
+		LIMIT $2
 	), recentCutoff AS (
 		SELECT Date 
 		FROM dates 
 		ORDER BY Date DESC
-		OFFSET $2
+		OFFSET $3
 		LIMIT 1
 	), prevCutoff AS (
 		SELECT Date
 		FROM dates
 		ORDER BY Date DESC
-		OFFSET $3
+		OFFSET $4
 		LIMIT 1
 	), temp AS (
 	SELECT TestName,
@@ -319,152 +846,226 @@ func (m *Postgres) GetEnvCharts(env string, testsInTop int) (map[string]interfac
 	SUM(CASE WHEN TestTime > (SELECT Date FROM recentCutoff) THEN 1 ELSE 0 END) As TotalTestNum,
 	ROUND(COALESCE(AVG(CASE WHEN TestTime > (SELECT Date FROM recentCutoff) THEN CASE WHEN Result = 'fail' THEN 1 ELSE 0 END END) * 100, 0), 2) AS RecentFlakePercentage,
 	ROUND(COALESCE(AVG(CASE WHEN TestTime <= (SELECT Date FROM recentCutoff) AND TestTime > (SELECT Date FROM prevCutoff) THEN CASE WHEN Result = 'fail' THEN 1 ELSE 0 END END) * 100, 0), 2) AS PrevFlakePercentage
-	FROM %s
+	FROM lastn_data
 	GROUP BY TestName
 	ORDER BY RecentFlakePercentage DESC
+	), ordered AS (%s
+	), flips AS (
+	SELECT TestName,
+	%s AS FlipRate,
+	%s AS ConsistentFailureRate
+	FROM ordered
+	GROUP BY TestName
 	)
-	SELECT TestName, RecentFlakePercentage, RecentFlakePercentage - PrevFlakePercentage AS GrowthRate, FailedTestNum, TotalTestNum
+	SELECT TestName, RecentFlakePercentage, RecentFlakePercentage - PrevFlakePercentage AS GrowthRate, FailedTestNum, TotalTestNum,
+	COALESCE(FlipRate, 0) AS FlipRate, COALESCE(ConsistentFailureRate, 0) AS ConsistentFailureRate
 	FROM temp
-	ORDER BY RecentFlakePercentage DESC;
-	`, viewName, viewName)
+	LEFT JOIN flips USING (TestName)
+	ORDER BY FlipRate DESC, RecentFlakePercentage DESC;
+	`, source, pgFlipOrdered("t.TestName, t.Result", "lastn_data", "t.TestName", "t.TestTime > (SELECT Date FROM recentCutoff)"),
+		pgFlipRate, pgConsistentFailureRate)
 	var flakeRates []models.DBFlakeRow
-	err = m.db.Select(&flakeRates, sqlQuer, 2*dateRange, dateRange-1, 2*dateRange-1)
+	err = m.db.SelectContext(ctx, &flakeRates, sqlQuer, env, 2*dateRange, dateRange-1, 2*dateRange-1)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for flake table: %v", err)
 	}
 	log.Printf("\nduration metric: took %f seconds to execute SQL query for flake table since start of handler", time.Since(start).Seconds())
 
-	var topTestNames []string
+	// The top tests are bound as $2 onwards after the environment
+	topTestArgs := []interface{}{env}
+	var topTestParams []string
 	for _, row := range flakeRates {
-		topTestNames = append(topTestNames, row.TestName)
-		if len(topTestNames) >= testsInTop {
+		if len(topTestParams) >= testsInTop {
 			break
 		}
+		topTestArgs = append(topTestArgs, row.TestName)
+		topTestParams = append(topTestParams, fmt.Sprintf("$%d", len(topTestArgs)))
+	}
+	if len(topTestParams) == 0 {
+		topTestParams = append(topTestParams, "NULL")
 	}
 
 	// Gets the data on just the top ten previously calculated and aggregates flake rates and results per date
 	sqlQuer = fmt.Sprintf(`
 	WITH lastn_data_top AS (
 		SELECT *
-		FROM %s
-		WHERE TestName IN ('%s')
+		FROM %s AS lastn_data
+		WHERE TestName IN (%s)
 	)
 	SELECT TestName, 
 	DATE_TRUNC('day', TestTime) AS StartOfDate,
 	COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0) AS FlakePercentage,
-	STRING_AGG(CommitID || ': ' || Result, ', ') AS CommitResults
+	JSON_AGG(%s ORDER BY TestTime) AS CommitResults
 	FROM lastn_data_top
 	GROUP BY TestName, StartOfDate
 	ORDER BY StartOfDate DESC
-	`, viewName,
-		strings.Join(topTestNames, "', '"))
+	`, source,
+		strings.Join(topTestParams, ", "), pgCommitResultJSON)
 	var flakeRateByDay []models.DBFlakeBy
-	err = m.db.Select(&flakeRateByDay, sqlQuer)
+	err = m.db.SelectContext(ctx, &flakeRateByDay, sqlQuer, topTestArgs...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for by day flake chart: %v", err)
 	}
 	log.Printf("\nduration metric: took %f seconds to execute SQL query for day flake chart since start of handler", time.Since(start).Seconds())
 
-	// Filters to get the top flakiest in the past week, calculating flake rate per week for those tests
+	// Filters to get the top flakiest (by flip rate) in the past week, calculating flake rate per week for those tests
 	sqlQuer = fmt.Sprintf(`
-	WITH recent_week AS (
+	WITH lastn_data AS %s
+	, recent_week AS (
 		SELECT MAX (DATE_TRUNC('week', TestTime)) AS weekCutoff
-		FROM %s
+		FROM lastn_data
 	),
 	recent_week_data AS (
 		SELECT * 
-		FROM %s 
+		FROM lastn_data 
 		WHERE TestTime >= (SELECT weekCutoff FROM recent_week)
 	),
+	recent_week_ordered AS (%s
+	),
 	top_flakiest AS (
-		SELECT TestName, COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0) AS RecentFlakePercentage
-		FROM recent_week_data
+		SELECT TestName, %s AS FlipRate,
+		COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0) AS RecentFlakePercentage
+		FROM recent_week_ordered
 		GROUP BY TestName
-		ORDER BY RecentFlakePercentage DESC
-		LIMIT $1
+		ORDER BY FlipRate DESC, RecentFlakePercentage DESC
+		LIMIT $2
 	),
 	top_flakiest_data AS (
-		SELECT * FROM %s 
+		SELECT * FROM lastn_data 
 		WHERE TestName IN (SELECT TestName FROM top_flakiest)
 	)
 	SELECT TestName,
 	DATE_TRUNC('week', TestTime) AS StartOfDate,
 	ROUND(COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0), 2) AS FlakePercentage,
-	STRING_AGG(CommitID || ': ' || Result, ', ') AS CommitResults
+	JSON_AGG(%s ORDER BY TestTime) AS CommitResults
 	FROM top_flakiest_data
 	GROUP BY TestName, StartOfDate
 	ORDER BY StartOfDate DESC;
-	`, viewName, viewName, viewName)
+	`, source, pgFlipOrdered("t.TestName, t.Result", "recent_week_data", "t.TestName", "TRUE"), pgFlipRate, pgCommitResultJSON)
 	var flakeRateByWeek []models.DBFlakeBy
-	err = m.db.Select(&flakeRateByWeek, sqlQuer, testsInTop)
+	err = m.db.SelectContext(ctx, &flakeRateByWeek, sqlQuer, env, testsInTop)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for by week flake chart: %v", err)
 	}
 	log.Printf("\nduration metric: took %f seconds to execute SQL query for flake by week chart since start of handler", time.Since(start).Seconds())
 
-	// Filters out data prior to 90 days and with the incorrect environment
+	// Filters out data outside of the window and with the incorrect environment
 	// Then calculates for each date aggregates the duration and number of tests, calculating the average for both
-	sqlQuer = `
+	sqlQuer = fmt.Sprintf(`
 	WITH lastn_env_data AS (
 		SELECT *
 		FROM db_environment_tests
-		WHERE EnvName = $1 AND TestTime >= NOW() - INTERVAL '90 days'
+		WHERE EnvName = $1 AND %s
 	)
 	SELECT
 	DATE_TRUNC('day', TestTime) AS StartOfDate,
 	AVG(NumberOfPass + NumberOfFail) AS TestCount,
 	AVG(TotalDuration) AS Duration,
-	STRING_AGG(CommitID || ': ' || (NumberOfPass + NumberOfFail), ', ') AS CommitCounts,
-	STRING_AGG(CommitID || ': ' || TotalDuration, ', ') AS CommitDurations
+	JSON_AGG(JSON_BUILD_OBJECT('commit', CommitID, 'testCount', NumberOfPass + NumberOfFail, 'duration', TotalDuration, 'time', TestTime AT TIME ZONE 'UTC') ORDER BY TestTime) AS Commits
 	FROM lastn_env_data 
 	GROUP BY StartOfDate
 	ORDER BY StartOfDate DESC
-	`
+	`, pgWindow("TestTime", w))
 	var countsAndDurations []models.DBEnvDuration
-	err = m.db.Select(&countsAndDurations, sqlQuer, env)
+	err = m.db.SelectContext(ctx, &countsAndDurations, sqlQuer, env)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for environment test count and duration chart: %v", err)
 	}
 	log.Printf("\nduration metric: took %f seconds to execute SQL query for env duration chart since start of handler", time.Since(start).Seconds())
 
-	data := map[string]interface{}{
-		"recentFlakePercentTable": flakeRates,
-		"flakeRateByWeek":         flakeRateByWeek,
-		"flakeRateByDay":          flakeRateByDay,
-		"countsAndDurations":      countsAndDurations,
+	recentSince, baselineSince := analysis.DurationWindows(w.To)
+	durationStats, err := m.GetDurationStats(ctx, env, recentSince, baselineSince, w.To)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for duration regressions since start of handler", time.Since(start).Seconds())
+
+	data := &models.EnvCharts{
+		RecentFlakePercentTable: flakeRates,
+		FlakeRateByWeek:         flakeRateByWeek,
+		FlakeRateByDay:          flakeRateByDay,
+		CountsAndDurations:      countsAndDurations,
+		SlowestGrowing:          analysis.SlowestGrowing(durationStats, testsInTop),
 	}
 	log.Printf("\nduration metric: took %f seconds to gather env chart data since start of handler\n\n", time.Since(start).Seconds())
 	return data, nil
 }
 
-// GetOverview writes the overview charts to a map with the keys summaryAvgFail and summaryTable
-func (m *Postgres) GetOverview(dateRange int) (map[string]interface{}, error) {
+// GetDurationStats returns the duration statistics of the passing runs of each test of an environment (or of all the environments if empty)
+// in the baseline window from baselineSince to recentSince and the recent window from recentSince to until
+func (m *Postgres) GetDurationStats(ctx context.Context, env string, recentSince time.Time, baselineSince time.Time, until time.Time) ([]models.DurationStats, error) {
+	sqlQuery := `
+	WITH runs AS (
+		SELECT EnvName, TestName, Duration, TestTime >= $1 AS Recent
+		FROM db_test_cases
+		WHERE Result = 'pass' AND TestTime >= $2 AND TestTime < $4 AND ($3 = '' OR EnvName = $3)
+	), baseline AS (
+		SELECT EnvName, TestName,
+		PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY Duration) AS BaselineMedian,
+		COUNT(*) AS BaselineRuns
+		FROM runs
+		WHERE NOT Recent
+		GROUP BY EnvName, TestName
+	), deviation AS (
+		SELECT r.EnvName, r.TestName,
+		PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY ABS(r.Duration - b.BaselineMedian)) AS BaselineMAD
+		FROM runs r
+		JOIN baseline b ON b.EnvName = r.EnvName AND b.TestName = r.TestName
+		WHERE NOT r.Recent
+		GROUP BY r.EnvName, r.TestName
+	), recent AS (
+		SELECT EnvName, TestName,
+		PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY Duration) AS RecentMedian,
+		COUNT(*) AS RecentRuns
+		FROM runs
+		WHERE Recent
+		GROUP BY EnvName, TestName
+	)
+	SELECT b.EnvName, b.TestName, b.BaselineMedian, d.BaselineMAD, b.BaselineRuns,
+	COALESCE(r.RecentMedian, 0) AS RecentMedian, COALESCE(r.RecentRuns, 0) AS RecentRuns
+	FROM baseline b
+	JOIN deviation d ON d.EnvName = b.EnvName AND d.TestName = b.TestName
+	LEFT JOIN recent r ON r.EnvName = b.EnvName AND r.TestName = b.TestName
+	`
+	var stats []models.DurationStats
+	if err := m.db.SelectContext(ctx, &stats, sqlQuery, recentSince, baselineSince, env, until); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for duration statistics: %v", err)
+	}
+	return stats, nil
+}
+
+// slowestGrowingInOverview is the number of regressed tests of all the environments in the overview
+const slowestGrowingInOverview = 20
+
+// GetOverview returns the overview charts of all the environments in the window
+func (m *Postgres) GetOverview(ctx context.Context, w models.Window) (*models.Overview, error) {
 	// dateRange is the number of days to use to look for "flaky-est" envs.
+	dateRange := w.Days
 	start := time.Now()
-	// Filters out old data and calculates the average number of failures and average duration per day per environment
-	sqlQuery := `
+	// Filters out data outside of the window and calculates the average number of failures and average duration per day per environment
+	sqlQuery := fmt.Sprintf(`
 	SELECT DATE_TRUNC('day', TestTime) AS StartOfDate, EnvName, AVG(NumberOfFail) AS AvgFailedTests, AVG(TotalDuration) AS AvgDuration
 	FROM db_environment_tests
-	WHERE TestTime >= NOW() - INTERVAL '90 days'
+	WHERE %s
 	GROUP BY StartOfDate, EnvName
 	ORDER BY StartOfDate, EnvName;
-	`
+	`, pgWindow("TestTime", w))
 
 	var summaryAvgFail []models.DBSummaryAvgFail
-	err := m.db.Select(&summaryAvgFail, sqlQuery)
+	err := m.db.SelectContext(ctx, &summaryAvgFail, sqlQuery)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for summary chart: %v", err)
 	}
 	log.Printf("\nduration metric: took %f seconds to execute SQL query for summary duration and failure charts since start of handler", time.Since(start).Seconds())
 
-	// Filters out data from prior to 90 days
+	// Filters out data outside of the window
 	// Then computes average number of fails for each environment for each time frame
 	// Then calculates the change in the average number of fails between the time frames
-	sqlQuery = `
+	sqlQuery = fmt.Sprintf(`
 	WITH data AS (
 		SELECT * 
 		FROM db_environment_tests 
-		WHERE TestTime >= NOW() - INTERVAL '90 days'
+		WHERE %s
 	), dates AS (
 		SELECT DISTINCT DATE_TRUNC('day', TestTime) AS Date
 		FROM data
@@ -495,18 +1096,106 @@ func (m *Postgres) GetOverview(dateRange int) (map[string]interface{}, error) {
 	SELECT EnvName, RecentNumberOfFail, RecentNumberOfFail - PrevNumberOfFail AS Growth, TestDuration,PreviousTestDuration, TestDuration-PreviousTestDuration AS TestDurationGROWTH
 	FROM temp
 	ORDER BY RecentNumberOfFail DESC;
-	`
+	`, pgWindow("TestTime", w))
 	var summaryTable []models.DBSummaryTable
-	err = m.db.Select(&summaryTable, sqlQuery, 2*dateRange, dateRange-1, 2*dateRange-1)
+	err = m.db.SelectContext(ctx, &summaryTable, sqlQuery, 2*dateRange, dateRange-1, 2*dateRange-1)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for flake table: %v", err)
 	}
 	log.Printf("\nduration metric: took %f seconds to execute SQL query for summary failure change table since start of handler", time.Since(start).Seconds())
 
-	data := map[string]interface{}{
-		"summaryAvgFail": summaryAvgFail,
-		"summaryTable":   summaryTable,
+	// Orders the runs of each test of each environment since the recentCutoff
+	// Then calculates the flip rate and consistent failure rate of each environment (see flakiness.go)
+	sqlQuery = fmt.Sprintf(`
+	WITH dates AS (
+		SELECT DISTINCT DATE_TRUNC('day', TestTime) AS Date
+		FROM db_environment_tests
+		WHERE %s
+		ORDER BY Date DESC
+		LIMIT $1
+	), recentCutoff AS (
+		SELECT Date
+		FROM dates
+		ORDER BY Date DESC
+		OFFSET $2
+		LIMIT 1
+	), ordered AS (%s
+	)
+	SELECT EnvName, %s AS FlipRate, %s AS ConsistentFailureRate
+	FROM ordered
+	GROUP BY EnvName;
+	`, pgWindow("TestTime", w),
+		pgFlipOrdered("t.EnvName, t.Result", fmt.Sprintf("(SELECT * FROM db_test_cases WHERE TestTime > (SELECT Date FROM recentCutoff) AND TestTime < '%s')", w.To.UTC().Format(pgTimeLayout)), "t.EnvName, t.TestName", "TRUE"),
+		pgFlipRate, pgConsistentFailureRate)
+	var envFlakiness []models.DBEnvFlakiness
+	err = m.db.SelectContext(ctx, &envFlakiness, sqlQuery, dateRange, dateRange-1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for environment flakiness: %v", err)
+	}
+	flakinessByEnv := map[string]models.DBEnvFlakiness{}
+	for _, f := range envFlakiness {
+		flakinessByEnv[f.EnvName] = f
+	}
+	for i := range summaryTable {
+		summaryTable[i].FlipRate = flakinessByEnv[summaryTable[i].EnvName].FlipRate
+		summaryTable[i].ConsistentFailureRate = flakinessByEnv[summaryTable[i].EnvName].ConsistentFailureRate
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for environment flakiness since start of handler", time.Since(start).Seconds())
+
+	recentSince, baselineSince := analysis.DurationWindows(w.To)
+	durationStats, err := m.GetDurationStats(ctx, "", recentSince, baselineSince, w.To)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for duration regressions since start of handler", time.Since(start).Seconds())
+
+	data := &models.Overview{
+		SummaryAvgFail: summaryAvgFail,
+		SummaryTable:   summaryTable,
+		SlowestGrowing: analysis.SlowestGrowing(durationStats, slowestGrowingInOverview),
 	}
 	log.Printf("\nduration metric: took %f seconds to gather summary data since start of handler\n\n", time.Since(start).Seconds())
 	return data, nil
 }
+
+// SetQuarantine adds/updates a quarantined test
+func (m *Postgres) SetQuarantine(ctx context.Context, q models.DBQuarantine) error {
+	sqlInsert := `
+		INSERT INTO db_quarantine (EnvName, TestName, Owner, Reason, IssueURL, Expiry, CreatedAt)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (EnvName, TestName)
+		DO UPDATE SET (Owner, Reason, IssueURL, Expiry, CreatedAt) = (EXCLUDED.Owner, EXCLUDED.Reason, EXCLUDED.IssueURL, EXCLUDED.Expiry, EXCLUDED.CreatedAt)
+	`
+	if _, err := m.db.ExecContext(ctx, sqlInsert, q.EnvName, q.TestName, q.Owner, q.Reason, q.IssueURL, q.Expiry, q.CreatedAt); err != nil {
+		return fmt.Errorf("failed to execute SQL insert: %v", err)
+	}
+	return nil
+}
+
+// GetQuarantine lists the quarantined tests of an environment including the tests quarantined on every environment, or every quarantined test if env is empty
+func (m *Postgres) GetQuarantine(ctx context.Context, env string) ([]models.DBQuarantine, error) {
+	sqlQuery := `
+	SELECT EnvName, TestName, Owner, Reason, IssueURL, Expiry, CreatedAt
+	FROM db_quarantine
+	WHERE $1 = '' OR EnvName IN ('', $1)
+	ORDER BY EnvName, TestName
+	`
+	entries := []models.DBQuarantine{}
+	if err := m.db.SelectContext(ctx, &entries, sqlQuery, env); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for quarantine: %v", err)
+	}
+	return entries, nil
+}
+
+// DeleteQuarantine removes a test from the quarantine of an environment, reporting whether it was quarantined
+func (m *Postgres) DeleteQuarantine(ctx context.Context, env string, test string) (bool, error) {
+	res, err := m.db.ExecContext(ctx, `DELETE FROM db_quarantine WHERE EnvName = $1 AND TestName = $2`, env, test)
+	if err != nil {
+		return false, fmt.Errorf("failed to execute SQL delete: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count deleted rows: %v", err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/lib/pq"
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+const (
+	// copyMinRows is the number of test cases from which a run is copied into a staging table rather than inserted row by row,
+	// which saves a round trip per row
+	copyMinRows = 100
+	// setAttempts is the number of times Set tries to store a run that fails on the connection
+	setAttempts = 4
+	// setBackoff is how long Set waits before its first retry, doubled before each of the next ones
+	setBackoff = 500 * time.Millisecond
+)
+
+// pgTestCasesStaging is the table the test cases of a run are copied into, dropped with the transaction.
+// RowOrder keeps the last of the rows of the same test, as inserting them row by row does
+var pgTestCasesStaging = `
+	CREATE TEMPORARY TABLE db_test_cases_staging (
+		RowOrder INTEGER,
+		PR TEXT,
+		CommitID TEXT,
+		EnvName TEXT,
+		TestName TEXT,
+		Result TEXT,
+		TestTime TIMESTAMP,
+		Duration FLOAT,
+		Signature TEXT
+	) ON COMMIT DROP;
+`
+
+// pgTestCasesStagingColumns are the columns of the staging table, lowercase as the copy quotes them
+var pgTestCasesStagingColumns = []string{"roworder", "pr", "commitid", "envname", "testname", "result", "testtime", "duration", "signature"}
+
+// pgTestCasesMerge upserts the test cases of the staging table
+var pgTestCasesMerge = `
+	INSERT INTO db_test_cases (PR, CommitId, EnvName, TestName, Result, TestTime, Duration, Signature)
+	SELECT DISTINCT ON (CommitID, EnvName, TestName) PR, CommitID, EnvName, TestName, Result, TestTime, Duration, Signature
+	FROM db_test_cases_staging
+	ORDER BY CommitID, EnvName, TestName, RowOrder DESC
+	ON CONFLICT (CommitId, EnvName, TestName)
+	DO UPDATE SET (PR, Result, TestTime, Duration, Signature) = (EXCLUDED.PR, EXCLUDED.Result, EXCLUDED.TestTime, EXCLUDED.Duration, EXCLUDED.Signature)
+`
+
+// copyTestCases upserts the test cases by copying them into a staging table and merging it in a single statement,
+// tx must be a transaction of conn
+func copyTestCases(ctx context.Context, conn *sql.Conn, tx *sql.Tx, dbRows []models.DBTestCase) error {
+	if _, err := tx.ExecContext(ctx, pgTestCasesStaging); err != nil {
+		return retryable(fmt.Errorf("failed to create the staging table: %v", err), err)
+	}
+	values := make([][]interface{}, len(dbRows))
+	for i, r := range dbRows {
+		values[i] = []interface{}{i, r.PR, r.CommitID, r.EnvName, r.TestName, r.Result, r.TestTime, r.Duration, r.Signature}
+	}
+
+	// the IAM authenticated connections use pgx, which copies through its own connection rather than a prepared statement
+	copied := false
+	err := conn.Raw(func(driverConn interface{}) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return nil
+		}
+		copied = true
+		_, err := c.Conn().CopyFrom(ctx, pgx.Identifier{"db_test_cases_staging"}, pgTestCasesStagingColumns, pgx.CopyFromRows(values))
+		return err
+	})
+	if err == nil && !copied {
+		err = pqCopy(ctx, tx, values)
+	}
+	if err != nil {
+		return retryable(fmt.Errorf("failed to copy the test cases: %v", err), err)
+	}
+
+	if _, err := tx.ExecContext(ctx, pgTestCasesMerge); err != nil {
+		return retryable(fmt.Errorf("failed to merge the staging table: %v", err), err)
+	}
+	return nil
+}
+
+// pqCopy copies the values into the staging table with the lib/pq driver
+func pqCopy(ctx context.Context, tx *sql.Tx, values [][]interface{}) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("db_test_cases_staging", pgTestCasesStagingColumns...))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = stmt.Close()
+	}()
+	for _, v := range values {
+		if _, err := stmt.ExecContext(ctx, v...); err != nil {
+			return err
+		}
+	}
+	// executing the statement without values ends the copy
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
+
+// retry calls attempt until it succeeds, fails for good or was called setAttempts times, waiting backoff before the first retry and twice as long before each of the next ones.
+// Each attempt is canceled after attemptTimeout, 0 to not time them out, and an attempt that timed out is retried as long as ctx is not done
+func retry(ctx context.Context, attemptTimeout time.Duration, backoff time.Duration, what string, attempt func(context.Context) error) error {
+	for n := 1; ; n++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if attemptTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, attemptTimeout)
+		}
+		err := done(attemptCtx, attempt(attemptCtx))
+		timedOut := err != nil && attemptCtx.Err() != nil && ctx.Err() == nil
+		cancel()
+		if _, ok := err.(transientError); (!ok && !timedOut) || n == setAttempts {
+			return err
+		}
+		log.Printf("failed to %s (attempt %d of %d), retrying in %s: %v", what, n, setAttempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// transientError is an error of Set that may not happen again when retried
+type transientError struct {
+	error
+}
+
+// retryable returns err as a transientError if its cause is transient
+func retryable(err, cause error) error {
+	if isTransient(cause) {
+		return transientError{err}
+	}
+	return err
+}
+
+// isTransient checks whether err is a failure of the connection, a timeout or reset of the network, of the server shutting down or starting,
+// or a conflict with a concurrent transaction. Other network errors, like an unknown host, do not go away when retried
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	for _, target := range []error{driver.ErrBadConn, io.EOF, io.ErrUnexpectedEOF, syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.EPIPE} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	// both lib/pq and pgx errors have the SQLSTATE code of the error
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		code := pgErr.SQLState()
+		return strings.HasPrefix(code, "08") || code == "40001" || code == "40P01" || code == "57P01" || code == "57P02" || code == "57P03"
+	}
+	return false
+}
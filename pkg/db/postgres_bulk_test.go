@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// timeoutError is a network error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"canceled", context.Canceled, false},
+		{"deadline exceeded", fmt.Errorf("failed to insert: %w", context.DeadlineExceeded), false},
+		{"bad connection", driver.ErrBadConn, true},
+		{"closed connection", io.EOF, true},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{"network timeout", &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, true},
+		{"unknown host", &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "db", IsNotFound: true}}, false},
+		{"serialization failure", &pq.Error{Code: "40001"}, true},
+		{"connection failure", &pq.Error{Code: "08006"}, true},
+		{"unique violation", &pq.Error{Code: "23505"}, false},
+		{"other", errors.New("syntax error"), false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isTransient(tc.err); got != tc.want {
+				t.Errorf("isTransient(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	transient := transientError{errors.New("connection reset")}
+	permanent := errors.New("unique violation")
+	tests := []struct {
+		name         string
+		timeout      time.Duration
+		cancelParent bool
+		// results are the errors of the attempts, the last one repeated
+		results      []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{"success", 0, false, []error{nil}, 1, nil},
+		{"transient then success", 0, false, []error{transient, transient, nil}, 3, nil},
+		{"transient every time", 0, false, []error{transient}, setAttempts, transient},
+		{"permanent", 0, false, []error{transient, permanent}, 2, permanent},
+		{"attempt timed out", 10 * time.Millisecond, false, []error{context.DeadlineExceeded, nil}, 2, nil},
+		{"parent canceled", 0, true, []error{transient}, 1, context.Canceled},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			attempts := 0
+			err := retry(ctx, tc.timeout, time.Millisecond, "test", func(ctx context.Context) error {
+				attempts++
+				if tc.cancelParent {
+					cancel()
+				}
+				err := tc.results[min(attempts, len(tc.results))-1]
+				if errors.Is(err, context.DeadlineExceeded) {
+					// time out like a slow query
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				return err
+			})
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("retry() = %v, want %v", err, tc.wantErr)
+			}
+			if attempts != tc.wantAttempts {
+				t.Errorf("retry() made %d attempts, want %d", attempts, tc.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryTimesOutEachAttempt(t *testing.T) {
+	var deadlines []time.Time
+	err := retry(context.Background(), time.Hour, time.Millisecond, "test", func(ctx context.Context) error {
+		d, ok := ctx.Deadline()
+		if !ok {
+			t.Fatal("attempt has no deadline")
+		}
+		deadlines = append(deadlines, d)
+		return transientError{errors.New("connection reset")}
+	})
+	if err == nil {
+		t.Fatal("retry() succeeded, want the error of the last attempt")
+	}
+	for i := 1; i < len(deadlines); i++ {
+		if !deadlines[i].After(deadlines[i-1]) {
+			t.Errorf("attempt %d has deadline %v, want after the one of attempt %d, %v", i+1, deadlines[i], i, deadlines[i-1])
+		}
+	}
+}
+
+// BenchmarkSetTestCases compares inserting the test cases of a run row by row with copying them,
+// against the Postgres database of GOPOGH_TEST_POSTGRES_DSN, for example "host=localhost user=postgres sslmode=disable"
+func BenchmarkSetTestCases(b *testing.B) {
+	dsn := os.Getenv("GOPOGH_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		b.Skip("GOPOGH_TEST_POSTGRES_DSN is not set")
+	}
+	database, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer database.Close()
+	ctx := context.Background()
+	m := &Postgres{db: database, path: dsn}
+	if err := m.Initialize(ctx); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, n := range []int{100, 1000} {
+		rows := make([]models.DBTestCase, n)
+		for i := range rows {
+			rows[i] = models.DBTestCase{CommitID: "benchmark", EnvName: "benchmark", TestName: "Test" + strconv.Itoa(i), Result: "pass", Duration: 1, TestTime: time.Now()}
+		}
+		for _, bc := range []struct {
+			name  string
+			store func(context.Context, *Postgres, []models.DBTestCase) error
+		}{
+			{"insert", func(ctx context.Context, m *Postgres, rows []models.DBTestCase) error {
+				tx, err := m.db.BeginTx(ctx, nil)
+				if err != nil {
+					return err
+				}
+				defer func() { _ = tx.Rollback() }()
+				return insertTestCases(ctx, tx, rows)
+			}},
+			{"copy", func(ctx context.Context, m *Postgres, rows []models.DBTestCase) error {
+				conn, err := m.db.Conn(ctx)
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+				tx, err := conn.BeginTx(ctx, nil)
+				if err != nil {
+					return err
+				}
+				defer func() { _ = tx.Rollback() }()
+				return copyTestCases(ctx, conn, tx, rows)
+			}},
+		} {
+			b.Run(fmt.Sprintf("%s/%d", bc.name, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if err := bc.store(ctx, m, rows); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
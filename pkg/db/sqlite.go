@@ -1,12 +1,18 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 
+	"github.com/medyagh/gopogh/pkg/analysis"
 	"github.com/medyagh/gopogh/pkg/models"
 	_ "modernc.org/sqlite" // Blank import used for registering SQLite driver as a database driver
 )
@@ -22,6 +28,8 @@ var createEnvironmentTestsTableSQL = `
 		NumberOfSkip INTEGER,
 		TotalDuration REAL,
 		GopoghVersion TEXT,
+		Branch TEXT NOT NULL DEFAULT '',
+		CommitTime TEXT,
 		PRIMARY KEY (CommitID, EnvName)
 	);
 `
@@ -35,18 +43,117 @@ var createTestCasesTableSQL = `
 		EnvName TEXT,
 		TestOrder INTEGER,
 		TestTime TEXT,
+		Signature TEXT NOT NULL DEFAULT '',
 		PRIMARY KEY (CommitId, EnvName, TestName)
 	);
 `
+var createCommitsTableSQL = `
+	CREATE TABLE IF NOT EXISTS db_commits (
+		CommitID TEXT,
+		ParentID TEXT,
+		Branch TEXT,
+		CommitTime TEXT,
+		PRIMARY KEY (CommitID)
+	);
+`
+var createQuarantineTableSQL = `
+	CREATE TABLE IF NOT EXISTS db_quarantine (
+		EnvName TEXT NOT NULL DEFAULT '',
+		TestName TEXT,
+		Owner TEXT NOT NULL DEFAULT '',
+		Reason TEXT NOT NULL DEFAULT '',
+		IssueURL TEXT NOT NULL DEFAULT '',
+		Expiry TEXT,
+		CreatedAt TEXT,
+		PRIMARY KEY (EnvName, TestName)
+	);
+`
+
+var createSchemaVersionTableSQL = `
+	CREATE TABLE IF NOT EXISTS db_schema_version (
+		Version INTEGER NOT NULL
+	);
+`
 
 type sqlite struct {
 	db   *sqlx.DB
 	path string
 }
 
+// sqliteTestCase is a row of db_test_cases as stored by sqlite, where TestTime is the text of time.Time.String()
+type sqliteTestCase struct {
+	PR        string  `db:"pr"`
+	CommitID  string  `db:"commitid"`
+	TestName  string  `db:"testname"`
+	Result    string  `db:"result"`
+	Duration  float64 `db:"duration"`
+	EnvName   string  `db:"envname"`
+	TestOrder int     `db:"testorder"`
+	TestTime  string  `db:"testtime"`
+	Signature string  `db:"signature"`
+}
+
+// sqliteTimeLayout is the layout of time.Time.String() which Set uses to store the test times
+const sqliteTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// parseSQLiteTime parses a time stored by Set, dropping the monotonic clock reading time.Time.String() may append
+func parseSQLiteTime(s string) (time.Time, error) {
+	s, _, _ = strings.Cut(s, " m=")
+	return time.Parse(sqliteTimeLayout, s)
+}
+
+// sqliteDates is the condition of the stored test time being on the dates around a window,
+// the stored times keep their time zone so the dates are a day wider than the window and the rows must still be checked with inWindow
+const sqliteDates = "substr(TestTime, 1, 10) BETWEEN ? AND ?"
+
+// sqliteDateArgs returns the arguments of sqliteDates for the window
+func sqliteDateArgs(w models.Window) (string, string) {
+	return w.From.UTC().AddDate(0, 0, -1).Format("2006-01-02"), w.To.UTC().AddDate(0, 0, 1).Format("2006-01-02")
+}
+
+// testCases returns the non skipped rows of a test on an environment in the window, of every test or environment if empty
+func (m *sqlite) testCases(ctx context.Context, env, test string, w models.Window) ([]models.DBTestCase, error) {
+	var stored []sqliteTestCase
+	sqlQuery := `
+	SELECT PR AS pr, CommitId AS commitid, TestName AS testname, Result AS result, Duration AS duration, EnvName AS envname, TestTime AS testtime
+	FROM db_test_cases
+	WHERE Result != 'skip' AND (? = '' OR EnvName = ?) AND (? = '' OR TestName = ?) AND ` + sqliteDates
+	fromDate, toDate := sqliteDateArgs(w)
+	if err := m.db.SelectContext(ctx, &stored, sqlQuery, env, env, test, test, fromDate, toDate); err != nil {
+		return nil, err
+	}
+	return parseSQLiteTestCases(stored, w)
+}
+
+// parseSQLiteTestCases converts the stored rows in the window to test cases, parsing their test times
+func parseSQLiteTestCases(stored []sqliteTestCase, w models.Window) ([]models.DBTestCase, error) {
+	rows := make([]models.DBTestCase, 0, len(stored))
+	for _, r := range stored {
+		testTime, err := parseSQLiteTime(r.TestTime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse test time of %s on %s: %v", r.TestName, r.CommitID, err)
+		}
+		if !inWindow(testTime, w) {
+			continue
+		}
+		rows = append(rows, models.DBTestCase{
+			PR:        r.PR,
+			CommitID:  r.CommitID,
+			TestName:  r.TestName,
+			Result:    r.Result,
+			Duration:  r.Duration,
+			EnvName:   r.EnvName,
+			TestOrder: r.TestOrder,
+			TestTime:  testTime,
+			Signature: r.Signature,
+		})
+	}
+	return rows, nil
+}
+
 // Set adds/updates rows to the database
-func (m *sqlite) Set(commitRow models.DBEnvironmentTest, dbRows []models.DBTestCase) error {
-	tx, err := m.db.Begin()
+func (m *sqlite) Set(ctx context.Context, commitRow models.DBEnvironmentTest, dbRows []models.DBTestCase) error {
+	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create SQL transaction: %v", err)
 	}
@@ -58,8 +165,8 @@ func (m *sqlite) Set(commitRow models.DBEnvironmentTest, dbRows []models.DBTestC
 		}
 	}()
 
-	sqlInsert := `INSERT OR REPLACE INTO db_test_cases (PR, CommitId, TestName, Result, Duration, EnvName, TestOrder, TestTime) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
-	stmt, err := tx.Prepare(sqlInsert)
+	sqlInsert := `INSERT OR REPLACE INTO db_test_cases (PR, CommitId, TestName, Result, Duration, EnvName, TestOrder, TestTime, Signature) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	stmt, err := tx.PrepareContext(ctx, sqlInsert)
 	if err != nil {
 		return fmt.Errorf("failed to prepare SQL insert statement: %v", err)
 	}
@@ -68,14 +175,19 @@ func (m *sqlite) Set(commitRow models.DBEnvironmentTest, dbRows []models.DBTestC
 	}()
 
 	for _, r := range dbRows {
-		_, err := stmt.Exec(r.PR, r.CommitID, r.TestName, r.Result, r.Duration, r.EnvName, r.TestOrder, r.TestTime.String())
+		_, err := stmt.ExecContext(ctx, r.PR, r.CommitID, r.TestName, r.Result, r.Duration, r.EnvName, r.TestOrder, r.TestTime.String(), r.Signature)
 		if err != nil {
 			return fmt.Errorf("failed to execute SQL insert: %v", err)
 		}
 	}
 
-	sqlInsert = `INSERT OR REPLACE INTO db_environment_tests (CommitID, EnvName, GopoghTime, TestTime, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration, GopoghVersion) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	_, err = tx.Exec(sqlInsert, commitRow.CommitID, commitRow.EnvName, commitRow.GopoghTime, commitRow.TestTime.String(), commitRow.NumberOfFail, commitRow.NumberOfPass, commitRow.NumberOfSkip, commitRow.TotalDuration, commitRow.GopoghVersion)
+	var commitTime *string
+	if commitRow.CommitTime != nil {
+		t := commitRow.CommitTime.String()
+		commitTime = &t
+	}
+	sqlInsert = `INSERT OR REPLACE INTO db_environment_tests (CommitID, EnvName, GopoghTime, TestTime, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration, GopoghVersion, Branch, CommitTime) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.ExecContext(ctx, sqlInsert, commitRow.CommitID, commitRow.EnvName, commitRow.GopoghTime, commitRow.TestTime.String(), commitRow.NumberOfFail, commitRow.NumberOfPass, commitRow.NumberOfSkip, commitRow.TotalDuration, commitRow.GopoghVersion, commitRow.Branch, commitTime)
 	if err != nil {
 		return fmt.Errorf("failed to execute SQL insert: %v", err)
 	}
@@ -104,37 +216,689 @@ func newSQLite(cfg config) (*sqlite, error) {
 }
 
 // Initialize creates the tables within the SQLite database
-func (m *sqlite) Initialize() error {
+func (m *sqlite) Initialize(ctx context.Context) error {
 
-	if _, err := m.db.Exec(createEnvironmentTestsTableSQL); err != nil {
+	if _, err := m.db.ExecContext(ctx, createEnvironmentTestsTableSQL); err != nil {
 		return fmt.Errorf("failed to initialize environment tests table: %v", err)
 	}
-	if _, err := m.db.Exec(createTestCasesTableSQL); err != nil {
+	if err := m.addColumnIfMissing(ctx, "db_environment_tests", "Branch", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("failed to migrate environment tests table: %v", err)
+	}
+	if err := m.addColumnIfMissing(ctx, "db_environment_tests", "CommitTime", "TEXT"); err != nil {
+		return fmt.Errorf("failed to migrate environment tests table: %v", err)
+	}
+	if _, err := m.db.ExecContext(ctx, createTestCasesTableSQL); err != nil {
 		return fmt.Errorf("failed to initialize test cases table: %v", err)
 	}
+	if err := m.addColumnIfMissing(ctx, "db_test_cases", "Signature", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("failed to migrate test cases table: %v", err)
+	}
+	if _, err := m.db.ExecContext(ctx, createCommitsTableSQL); err != nil {
+		return fmt.Errorf("failed to initialize commits table: %v", err)
+	}
+	if _, err := m.db.ExecContext(ctx, createQuarantineTableSQL); err != nil {
+		return fmt.Errorf("failed to initialize quarantine table: %v", err)
+	}
+	if _, err := m.db.ExecContext(ctx, createSchemaVersionTableSQL); err != nil {
+		return fmt.Errorf("failed to initialize schema version table: %v", err)
+	}
+	// the version is never lowered, so that a previous version of gopogh does not hide the changes of a newer one
+	if _, err := m.db.ExecContext(ctx, `INSERT INTO db_schema_version (Version) SELECT ? WHERE NOT EXISTS (SELECT 1 FROM db_schema_version)`, SchemaVersion); err != nil {
+		return fmt.Errorf("failed to record schema version: %v", err)
+	}
+	if _, err := m.db.ExecContext(ctx, `UPDATE db_schema_version SET Version = ? WHERE Version < ?`, SchemaVersion, SchemaVersion); err != nil {
+		return fmt.Errorf("failed to record schema version: %v", err)
+	}
+	return nil
+}
+
+// Ping checks that the database file can be opened
+func (m *sqlite) Ping(ctx context.Context) error {
+	return m.db.PingContext(ctx)
+}
+
+// GetSchemaVersion returns the version of the tables recorded by Initialize, 0 if none is recorded
+func (m *sqlite) GetSchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	if err := m.db.GetContext(ctx, &version, `SELECT COALESCE(MAX(Version), 0) FROM db_schema_version`); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	return version, nil
+}
+
+// GetLastViewRefresh returns nil, sqlite has no materialized views
+func (m *sqlite) GetLastViewRefresh(ctx context.Context) (*time.Time, error) {
+	return nil, ctx.Err()
+}
+
+// addColumnIfMissing adds a column to a table created before the column existed, sqlite has no ADD COLUMN IF NOT EXISTS
+func (m *sqlite) addColumnIfMissing(ctx context.Context, table, column, definition string) error {
+	var count int
+	if err := m.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err := m.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// SetCommits adds/updates the imported commits
+func (m *sqlite) SetCommits(ctx context.Context, commits []models.DBCommit) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create SQL transaction: %v", err)
+	}
+
+	var rollbackError error
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil {
+			rollbackError = fmt.Errorf("error occurred during rollback: %v", rErr)
+		}
+	}()
+
+	sqlInsert := `INSERT OR REPLACE INTO db_commits (CommitID, ParentID, Branch, CommitTime) VALUES (?, ?, ?, ?)`
+	stmt, err := tx.PrepareContext(ctx, sqlInsert)
+	if err != nil {
+		return fmt.Errorf("failed to prepare SQL insert statement: %v", err)
+	}
+	defer func() {
+		_ = stmt.Close()
+	}()
+
+	for _, c := range commits {
+		if _, err := stmt.ExecContext(ctx, c.CommitID, c.ParentID, c.Branch, c.CommitTime.String()); err != nil {
+			return fmt.Errorf("failed to execute SQL insert: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit SQL insert transaction: %v", err)
+	}
+	return rollbackError
+}
+
+// sqliteRun is a row of db_environment_tests joined with its imported commit, with the times as stored by sqlite
+type sqliteRun struct {
+	CommitID      string  `db:"commitid"`
+	EnvName       string  `db:"envname"`
+	TestTime      string  `db:"testtime"`
+	NumberOfFail  int     `db:"numberoffail"`
+	NumberOfPass  int     `db:"numberofpass"`
+	NumberOfSkip  int     `db:"numberofskip"`
+	TotalDuration float64 `db:"totalduration"`
+	GopoghTime    string  `db:"gopoghtime"`
+	GopoghVersion string  `db:"gopoghversion"`
+	Branch        string  `db:"branch"`
+	CommitTime    *string `db:"committime"`
+}
+
+// runs returns the runs of an environment (or all environments if empty) on a branch (or all branches if empty) in commit order, most recent first
+func (m *sqlite) runs(ctx context.Context, env string, branch string) ([]models.DBEnvironmentTest, error) {
+	var stored []sqliteRun
+	sqlQuery := `
+	SELECT e.CommitID AS commitid, e.EnvName AS envname, e.TestTime AS testtime,
+	e.NumberOfFail AS numberoffail, e.NumberOfPass AS numberofpass, e.NumberOfSkip AS numberofskip,
+	e.TotalDuration AS totalduration, COALESCE(e.GopoghVersion, '') AS gopoghversion,
+	COALESCE(NULLIF(e.Branch, ''), c.Branch, '') AS branch, COALESCE(c.CommitTime, e.CommitTime) AS committime
+	FROM db_environment_tests e
+	LEFT JOIN db_commits c ON c.CommitID = e.CommitID
+	WHERE (? = '' OR e.EnvName = ?) AND (? = '' OR COALESCE(NULLIF(e.Branch, ''), c.Branch, '') = ?)
+	`
+	if err := m.db.SelectContext(ctx, &stored, sqlQuery, env, env, branch, branch); err != nil {
+		return nil, err
+	}
+	runs := make([]models.DBEnvironmentTest, 0, len(stored))
+	for _, r := range stored {
+		run, err := parseSQLiteRun(r)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return commitOrder(runs[i]).After(commitOrder(runs[j])) })
+	return runs, nil
+}
+
+// parseSQLiteRun converts the stored row to an environment test, parsing its times
+func parseSQLiteRun(r sqliteRun) (models.DBEnvironmentTest, error) {
+	testTime, err := parseSQLiteTime(r.TestTime)
+	if err != nil {
+		return models.DBEnvironmentTest{}, fmt.Errorf("failed to parse test time of %s: %v", r.CommitID, err)
+	}
+	run := models.DBEnvironmentTest{
+		CommitID:      r.CommitID,
+		EnvName:       r.EnvName,
+		TestTime:      testTime,
+		NumberOfFail:  r.NumberOfFail,
+		NumberOfPass:  r.NumberOfPass,
+		NumberOfSkip:  r.NumberOfSkip,
+		TotalDuration: r.TotalDuration,
+		GopoghVersion: r.GopoghVersion,
+		Branch:        r.Branch,
+	}
+	if r.GopoghTime != "" {
+		if run.GopoghTime, err = parseSQLiteTime(r.GopoghTime); err != nil {
+			return run, fmt.Errorf("failed to parse gopogh time of %s: %v", r.CommitID, err)
+		}
+	}
+	if r.CommitTime != nil {
+		commitTime, err := parseSQLiteTime(*r.CommitTime)
+		if err != nil {
+			return run, fmt.Errorf("failed to parse commit time of %s: %v", r.CommitID, err)
+		}
+		run.CommitTime = &commitTime
+	}
+	return run, nil
+}
+
+// GetCommitHistory returns the last runs of an environment in the window in commit order, optionally only the commits of a branch
+func (m *sqlite) GetCommitHistory(ctx context.Context, env string, branch string, limit int, w models.Window) (*models.CommitHistory, error) {
+	all, err := m.runs(ctx, env, branch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for commit history: %v", err)
+	}
+	runs := []models.DBEnvironmentTest{}
+	for _, r := range all {
+		if inWindow(r.TestTime, w) {
+			runs = append(runs, r)
+		}
+	}
+	if len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return &models.CommitHistory{EnvName: env, Branch: branch, Commits: runs}, nil
+}
+
+// EachEnvironmentTest calls fn on the environment tests matching the filter, most recent first, stopping at the first error
+func (m *sqlite) EachEnvironmentTest(ctx context.Context, f models.RowFilter, fn func(models.DBEnvironmentTest) error) error {
+	conditions, args := rowConditions(f, false)
+	fromDate, toDate := sqliteDateArgs(f.Window)
+	sqlQuery := `
+	SELECT CommitID AS commitid, EnvName AS envname, COALESCE(GopoghTime, '') AS gopoghtime, TestTime AS testtime,
+	NumberOfFail AS numberoffail, NumberOfPass AS numberofpass, NumberOfSkip AS numberofskip,
+	TotalDuration AS totalduration, COALESCE(GopoghVersion, '') AS gopoghversion, Branch AS branch, CommitTime AS committime
+	FROM db_environment_tests
+	WHERE ` + strings.Join(append(conditions, sqliteDates), " AND ")
+	var stored []sqliteRun
+	if err := m.db.SelectContext(ctx, &stored, sqlQuery, append(args, fromDate, toDate)...); err != nil {
+		return fmt.Errorf("failed to execute SQL query for environment tests: %v", err)
+	}
+	var rows []models.DBEnvironmentTest
+	for _, r := range stored {
+		row, err := parseSQLiteRun(r)
+		if err != nil {
+			return err
+		}
+		if inWindow(row.TestTime, f.Window) {
+			rows = append(rows, row)
+		}
+	}
+	cursor := func(r models.DBEnvironmentTest) models.RowCursor {
+		return models.RowCursor{TestTime: r.TestTime, EnvName: r.EnvName, CommitID: r.CommitID}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rowBefore(cursor(rows[i]), cursor(rows[j])) })
+	sent := 0
+	for _, r := range rows {
+		if f.After != nil && !rowBefore(*f.After, cursor(r)) {
+			continue
+		}
+		if f.Limit > 0 && sent == f.Limit {
+			break
+		}
+		// the rows are read at once, so the context is checked while they are sent
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+		sent++
+	}
+	return nil
+}
+
+// EachTestCase calls fn on the test cases matching the filter, most recent first, stopping at the first error
+func (m *sqlite) EachTestCase(ctx context.Context, f models.RowFilter, fn func(models.DBTestCase) error) error {
+	conditions, args := rowConditions(f, true)
+	fromDate, toDate := sqliteDateArgs(f.Window)
+	sqlQuery := `
+	SELECT PR AS pr, CommitId AS commitid, TestName AS testname, Result AS result, Duration AS duration, EnvName AS envname,
+	COALESCE(TestOrder, 0) AS testorder, TestTime AS testtime, Signature AS signature
+	FROM db_test_cases
+	WHERE ` + strings.Join(append(conditions, sqliteDates), " AND ")
+	var stored []sqliteTestCase
+	if err := m.db.SelectContext(ctx, &stored, sqlQuery, append(args, fromDate, toDate)...); err != nil {
+		return fmt.Errorf("failed to execute SQL query for test cases: %v", err)
+	}
+	rows, err := parseSQLiteTestCases(stored, f.Window)
+	if err != nil {
+		return err
+	}
+	cursor := func(r models.DBTestCase) models.RowCursor {
+		return models.RowCursor{TestTime: r.TestTime, EnvName: r.EnvName, CommitID: r.CommitID, TestName: r.TestName}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rowBefore(cursor(rows[i]), cursor(rows[j])) })
+	sent := 0
+	for _, r := range rows {
+		if f.After != nil && !rowBefore(*f.After, cursor(r)) {
+			continue
+		}
+		if f.Limit > 0 && sent == f.Limit {
+			break
+		}
+		// the rows are read at once, so the context is checked while they are sent
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+		sent++
+	}
 	return nil
 }
 
-// GetEnvironmentTestsAndTestCases writes the database tables to a map with the keys environmentTests and testCases
-// This is not yet supported for sqlite
-func (m *sqlite) GetEnvironmentTestsAndTestCases() (map[string]interface{}, error) {
-	return nil, nil
+// GetPRResults returns the runs of a pull request in the window on every environment,
+// with the flake rate of their failed tests in the recent window of the runs of the environment that are not of a pull request
+func (m *sqlite) GetPRResults(ctx context.Context, pr string, w models.Window) (*models.PRResults, error) {
+	var runs []models.DBEnvironmentTest
+	err := m.EachEnvironmentTest(ctx, models.RowFilter{PR: pr, Window: w}, func(row models.DBEnvironmentTest) error {
+		runs = append(runs, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var failed []models.DBPRFailedTest
+	err = m.EachTestCase(ctx, models.RowFilter{PR: pr, Result: "fail", Window: w}, func(row models.DBTestCase) error {
+		failed = append(failed, models.DBPRFailedTest{EnvName: row.EnvName, CommitID: row.CommitID, TestName: row.TestName})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range failed {
+		fails := 0
+		err := m.EachTestCase(ctx, models.RowFilter{Env: f.EnvName, Test: f.TestName, Window: recentWindow(w)}, func(row models.DBTestCase) error {
+			if row.PR != "" || row.Result == "skip" {
+				return nil
+			}
+			failed[i].BaseRuns++
+			if row.Result == "fail" {
+				fails++
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if failed[i].BaseRuns > 0 {
+			failed[i].FlakePercentage = float32(roundTo(float64(fails)*100/float64(failed[i].BaseRuns), 2))
+		}
+	}
+	return prResults(pr, runs, failed), nil
+}
+
+// GetEnvCharts returns the overall environment charts
+func (m *sqlite) GetEnvCharts(ctx context.Context, env string, testsInTop int, w models.Window) (*models.EnvCharts, error) {
+	// the runs of the commits on the other environments are compared too, see flakiness.go
+	all, err := m.testCases(ctx, "", "", w)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for test cases: %v", err)
+	}
+	var rows []models.DBTestCase
+	for _, r := range all {
+		if r.EnvName == env {
+			rows = append(rows, r)
+		}
+	}
+	runs, err := m.runs(ctx, env, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for environment tests: %v", err)
+	}
+	data := envCharts(rows, newCommitOutcomes(all), runs, testsInTop, w)
+	recentSince, baselineSince := analysis.DurationWindows(w.To)
+	durationStats, err := m.GetDurationStats(ctx, env, recentSince, baselineSince, w.To)
+	if err != nil {
+		return nil, err
+	}
+	data.SlowestGrowing = analysis.SlowestGrowing(durationStats, testsInTop)
+	return data, nil
+}
+
+// GetTestHistory returns the results of a test in commit order, oldest first, optionally only on the commits of a branch
+func (m *sqlite) GetTestHistory(ctx context.Context, env string, test string, branch string, w models.Window) (models.CommitResults, error) {
+	rows, err := m.testCases(ctx, env, test, w)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for test cases: %v", err)
+	}
+	runs, err := m.runs(ctx, env, branch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for commit order: %v", err)
+	}
+	return resultsByCommit(rows, runs), nil
+}
+
+// GetTestAcrossEnvs returns the results of a test in the recent window on every environment it ran on
+func (m *sqlite) GetTestAcrossEnvs(ctx context.Context, test string, w models.Window) (*models.TestAcrossEnvs, error) {
+	w = recentWindow(w)
+	var stored []sqliteTestCase
+	sqlQuery := `
+	SELECT PR AS pr, CommitId AS commitid, TestName AS testname, Result AS result, Duration AS duration, EnvName AS envname, TestTime AS testtime
+	FROM db_test_cases
+	WHERE Result != 'skip' AND TestName = ? AND ` + sqliteDates
+	fromDate, toDate := sqliteDateArgs(w)
+	if err := m.db.SelectContext(ctx, &stored, sqlQuery, test, fromDate, toDate); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for test across environments: %v", err)
+	}
+	rows, err := parseSQLiteTestCases(stored, w)
+	if err != nil {
+		return nil, err
+	}
+	runs, err := m.runs(ctx, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for commit order: %v", err)
+	}
+	return &models.TestAcrossEnvs{TestName: test, Envs: testEnvSummaries(rows, runs)}, nil
 }
 
-// GetEnvCharts writes the overall environment charts to a map with the keys recentFlakePercentTable, flakeRateByWeek, flakeRateByDay, and countsAndDurations
-// This is not yet supported for sqlite
-func (m *sqlite) GetEnvCharts(_ string, _ int) (map[string]interface{}, error) {
-	return nil, nil
+// GetTestCharts returns the individual test charts by day, week, month and commit, optionally only the commits of a branch are charted by commit
+func (m *sqlite) GetTestCharts(ctx context.Context, env string, test string, branch string, w models.Window) (*models.TestCharts, error) {
+	rows, err := m.testCases(ctx, env, test, w)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for test cases: %v", err)
+	}
+	runs, err := m.runs(ctx, env, branch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for commit order: %v", err)
+	}
+	return &models.TestCharts{
+		FlakeByDay:   testRateAndDurations(rows, truncateDay),
+		FlakeByWeek:  testRateAndDurations(rows, truncateWeek),
+		FlakeByMonth: testRateAndDurations(rows, truncateMonth),
+		ByCommit:     resultsByCommit(rows, runs),
+	}, nil
 }
 
-// GetTestCharts writes the individual test chart data to a map with the keys flakeByDay and flakeByWeek
-// This is not yet supported for sqlite
-func (m *sqlite) GetTestCharts(_ string, _ string) (map[string]interface{}, error) {
-	return nil, nil
+// GetDurationStats returns the duration statistics of the passing runs of each test of an environment (or of all the environments if empty)
+// in the baseline window from baselineSince to recentSince and the recent window from recentSince to until
+func (m *sqlite) GetDurationStats(ctx context.Context, env string, recentSince time.Time, baselineSince time.Time, until time.Time) ([]models.DurationStats, error) {
+	var stored []sqliteTestCase
+	sqlQuery := `
+	SELECT PR AS pr, CommitId AS commitid, TestName AS testname, Result AS result, Duration AS duration, EnvName AS envname, TestTime AS testtime
+	FROM db_test_cases
+	WHERE Result = 'pass' AND (? = '' OR EnvName = ?) AND ` + sqliteDates
+	fromDate, toDate := sqliteDateArgs(models.Window{From: baselineSince, To: until})
+	if err := m.db.SelectContext(ctx, &stored, sqlQuery, env, env, fromDate, toDate); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for duration statistics: %v", err)
+	}
+
+	type key struct{ env, test string }
+	type durations struct{ baseline, recent []float64 }
+	byTest := map[key]*durations{}
+	var keys []key
+	for _, r := range stored {
+		testTime, err := parseSQLiteTime(r.TestTime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse test time of %s on %s: %v", r.TestName, r.CommitID, err)
+		}
+		if !inWindow(testTime, models.Window{From: baselineSince, To: until}) {
+			continue
+		}
+		k := key{r.EnvName, r.TestName}
+		d, ok := byTest[k]
+		if !ok {
+			d = &durations{}
+			byTest[k] = d
+			keys = append(keys, k)
+		}
+		if testTime.Before(recentSince) {
+			d.baseline = append(d.baseline, r.Duration)
+		} else {
+			d.recent = append(d.recent, r.Duration)
+		}
+	}
+
+	var stats []models.DurationStats
+	for _, k := range keys {
+		d := byTest[k]
+		// like the postgres query, tests without baseline runs have no statistics
+		if len(d.baseline) == 0 {
+			continue
+		}
+		stats = append(stats, analysis.DurationStatsOf(k.env, k.test, d.baseline, d.recent))
+	}
+	return stats, nil
 }
 
-// GetOverview writes the overview charts to a map with the keys summaryAvgFail and summaryTable
-// This is not yet supported for sqlite
-func (m *sqlite) GetOverview(int) (map[string]interface{}, error) {
-	return nil, nil
+// GetEnvs returns every environment with its most recent run
+func (m *sqlite) GetEnvs(ctx context.Context) (*models.EnvList, error) {
+	var stored []sqliteRun
+	sqlQuery := `
+	SELECT CommitID AS commitid, EnvName AS envname, TestTime AS testtime,
+	NumberOfFail AS numberoffail, NumberOfPass AS numberofpass, NumberOfSkip AS numberofskip
+	FROM db_environment_tests
+	`
+	if err := m.db.SelectContext(ctx, &stored, sqlQuery); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for environments: %v", err)
+	}
+	byEnv := map[string]*models.DBEnvInfo{}
+	for _, r := range stored {
+		testTime, err := parseSQLiteTime(r.TestTime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse test time of %s: %v", r.CommitID, err)
+		}
+		e, ok := byEnv[r.EnvName]
+		if !ok {
+			e = &models.DBEnvInfo{EnvName: r.EnvName, FirstTestTime: testTime}
+			byEnv[r.EnvName] = e
+		}
+		e.Runs++
+		if testTime.Before(e.FirstTestTime) {
+			e.FirstTestTime = testTime
+		}
+		if !testTime.Before(e.LastTestTime) {
+			e.LastTestTime = testTime
+			e.LastCommitID = r.CommitID
+			e.LastNumberOfTests = r.NumberOfPass + r.NumberOfFail + r.NumberOfSkip
+			e.LastNumberOfFail = r.NumberOfFail
+		}
+	}
+	envs := make([]models.DBEnvInfo, 0, len(byEnv))
+	for _, e := range byEnv {
+		envs = append(envs, *e)
+	}
+	sort.Slice(envs, func(i, j int) bool { return envs[i].EnvName < envs[j].EnvName })
+	return &models.EnvList{Envs: envs}, nil
+}
+
+// GetEnvHealth lists the environments with their most recent run regardless of the window,
+// and the number of their tests whose flake percentage in the recent window of w is at least flakeRate
+func (m *sqlite) GetEnvHealth(ctx context.Context, flakeRate float32, w models.Window) ([]models.DBEnvHealth, error) {
+	envs, err := m.GetEnvs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := m.testCases(ctx, "", "", recentWindow(w))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for environment health: %v", err)
+	}
+	type envTest struct{ env, test string }
+	runs, fails := map[envTest]int{}, map[envTest]int{}
+	for _, r := range rows {
+		k := envTest{r.EnvName, r.TestName}
+		runs[k]++
+		if r.Result == "fail" {
+			fails[k]++
+		}
+	}
+	flaky := map[string]int{}
+	for k, n := range runs {
+		if percentage(fails[k], n) >= flakeRate {
+			flaky[k.env]++
+		}
+	}
+	health := make([]models.DBEnvHealth, 0, len(envs.Envs))
+	for _, e := range envs.Envs {
+		health = append(health, models.DBEnvHealth{
+			EnvName:           e.EnvName,
+			LastTestTime:      e.LastTestTime,
+			LastNumberOfTests: e.LastNumberOfTests,
+			LastNumberOfFail:  e.LastNumberOfFail,
+			FlakyTests:        flaky[e.EnvName],
+		})
+	}
+	return health, nil
+}
+
+// SearchTests returns a page of the names of the tests in the window containing the query, or matching it as a regular expression, ignoring case
+func (m *sqlite) SearchTests(ctx context.Context, query string, regex bool, page int, perPage int, w models.Window) (*models.TestList, error) {
+	pattern := regexp.QuoteMeta(query)
+	if regex {
+		pattern = query
+	}
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %v", err)
+	}
+
+	var stored []sqliteTestCase
+	sqlQuery := `
+	SELECT TestName AS testname, EnvName AS envname, TestTime AS testtime
+	FROM db_test_cases
+	WHERE ` + sqliteDates
+	fromDate, toDate := sqliteDateArgs(w)
+	if err := m.db.SelectContext(ctx, &stored, sqlQuery, fromDate, toDate); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for test search: %v", err)
+	}
+	type testEnvs struct {
+		info models.DBTestInfo
+		envs map[string]bool
+	}
+	byTest := map[string]*testEnvs{}
+	for _, r := range stored {
+		if !re.MatchString(r.TestName) {
+			continue
+		}
+		testTime, err := parseSQLiteTime(r.TestTime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse test time of %s: %v", r.TestName, err)
+		}
+		if !inWindow(testTime, w) {
+			continue
+		}
+		t, ok := byTest[r.TestName]
+		if !ok {
+			t = &testEnvs{info: models.DBTestInfo{TestName: r.TestName}, envs: map[string]bool{}}
+			byTest[r.TestName] = t
+		}
+		t.envs[r.EnvName] = true
+		if testTime.After(t.info.LastTestTime) {
+			t.info.LastTestTime = testTime
+		}
+	}
+	tests := make([]models.DBTestInfo, 0, len(byTest))
+	for _, t := range byTest {
+		t.info.EnvCount = len(t.envs)
+		tests = append(tests, t.info)
+	}
+	sort.Slice(tests, func(i, j int) bool { return tests[i].TestName < tests[j].TestName })
+
+	data := &models.TestList{Tests: []models.DBTestInfo{}, Total: len(tests), Page: page, PerPage: perPage}
+	if from := (page - 1) * perPage; from < len(tests) {
+		data.Tests = tests[from:min(from+perPage, len(tests))]
+	}
+	return data, nil
+}
+
+// GetOverview returns the overview charts of all the environments in the window
+func (m *sqlite) GetOverview(ctx context.Context, w models.Window) (*models.Overview, error) {
+	rows, err := m.testCases(ctx, "", "", w)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for test cases: %v", err)
+	}
+	runs, err := m.runs(ctx, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for environment tests: %v", err)
+	}
+	data := overview(rows, runs, w)
+	recentSince, baselineSince := analysis.DurationWindows(w.To)
+	durationStats, err := m.GetDurationStats(ctx, "", recentSince, baselineSince, w.To)
+	if err != nil {
+		return nil, err
+	}
+	data.SlowestGrowing = analysis.SlowestGrowing(durationStats, slowestGrowingInOverview)
+	return data, nil
+}
+
+// SetQuarantine adds/updates a quarantined test
+func (m *sqlite) SetQuarantine(ctx context.Context, q models.DBQuarantine) error {
+	var expiry *string
+	if q.Expiry != nil {
+		t := q.Expiry.String()
+		expiry = &t
+	}
+	sqlInsert := `INSERT OR REPLACE INTO db_quarantine (EnvName, TestName, Owner, Reason, IssueURL, Expiry, CreatedAt) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	if _, err := m.db.ExecContext(ctx, sqlInsert, q.EnvName, q.TestName, q.Owner, q.Reason, q.IssueURL, expiry, q.CreatedAt.String()); err != nil {
+		return fmt.Errorf("failed to execute SQL insert: %v", err)
+	}
+	return nil
+}
+
+// sqliteQuarantine is a row of db_quarantine with the times as stored by sqlite
+type sqliteQuarantine struct {
+	EnvName   string  `db:"envname"`
+	TestName  string  `db:"testname"`
+	Owner     string  `db:"owner"`
+	Reason    string  `db:"reason"`
+	IssueURL  string  `db:"issueurl"`
+	Expiry    *string `db:"expiry"`
+	CreatedAt string  `db:"createdat"`
+}
+
+// GetQuarantine lists the quarantined tests of an environment including the tests quarantined on every environment, or every quarantined test if env is empty
+func (m *sqlite) GetQuarantine(ctx context.Context, env string) ([]models.DBQuarantine, error) {
+	var stored []sqliteQuarantine
+	sqlQuery := `
+	SELECT EnvName AS envname, TestName AS testname, Owner AS owner, Reason AS reason, IssueURL AS issueurl, Expiry AS expiry, CreatedAt AS createdat
+	FROM db_quarantine
+	WHERE ? = '' OR EnvName IN ('', ?)
+	ORDER BY EnvName, TestName
+	`
+	if err := m.db.SelectContext(ctx, &stored, sqlQuery, env, env); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for quarantine: %v", err)
+	}
+	entries := make([]models.DBQuarantine, 0, len(stored))
+	for _, r := range stored {
+		createdAt, err := parseSQLiteTime(r.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse creation time of the quarantine of %s: %v", r.TestName, err)
+		}
+		q := models.DBQuarantine{EnvName: r.EnvName, TestName: r.TestName, Owner: r.Owner, Reason: r.Reason, IssueURL: r.IssueURL, CreatedAt: createdAt}
+		if r.Expiry != nil {
+			expiry, err := parseSQLiteTime(*r.Expiry)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse expiry of the quarantine of %s: %v", r.TestName, err)
+			}
+			q.Expiry = &expiry
+		}
+		entries = append(entries, q)
+	}
+	return entries, nil
+}
+
+// DeleteQuarantine removes a test from the quarantine of an environment, reporting whether it was quarantined
+func (m *sqlite) DeleteQuarantine(ctx context.Context, env string, test string) (bool, error) {
+	res, err := m.db.ExecContext(ctx, `DELETE FROM db_quarantine WHERE EnvName = ? AND TestName = ?`, env, test)
+	if err != nil {
+		return false, fmt.Errorf("failed to execute SQL delete: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count deleted rows: %v", err)
+	}
+	return n > 0, nil
 }
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// newTestSQLite returns an initialized SQLite database in a temporary directory, storing a run of env on commits c0 to c3 a day apart
+// and a run of pull request 7 on commit p0 with TestA failing, the runs ending at now
+func newTestSQLite(t *testing.T, now time.Time) *sqlite {
+	t.Helper()
+	m, err := newSQLite(config{dbType: "sqlite", path: filepath.Join(t.TempDir(), "gopogh.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = m.db.Close() })
+	ctx := context.Background()
+	if err := m.Initialize(ctx); err != nil {
+		t.Fatal(err)
+	}
+	store := func(commit string, pr string, daysAgo int, results map[string]string) {
+		tt := now.AddDate(0, 0, -daysAgo)
+		run := models.DBEnvironmentTest{CommitID: commit, EnvName: "env", GopoghTime: tt, TestTime: tt, TotalDuration: 10}
+		var rows []models.DBTestCase
+		for _, test := range []string{"TestA", "TestB"} {
+			if results[test] == "fail" {
+				run.NumberOfFail++
+			} else {
+				run.NumberOfPass++
+			}
+			rows = append(rows, models.DBTestCase{PR: pr, CommitID: commit, EnvName: "env", TestName: test, Result: results[test], Duration: 1, TestTime: tt})
+		}
+		if err := m.Set(ctx, run, rows); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		result := "pass"
+		if i%2 == 0 {
+			result = "fail"
+		}
+		store(fmt.Sprintf("c%d", i), "", 4-i, map[string]string{"TestA": result, "TestB": "pass"})
+	}
+	store("p0", "7", 0, map[string]string{"TestA": "fail", "TestB": "pass"})
+	return m
+}
+
+func TestEachRowFilter(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	m := newTestSQLite(t, now)
+	w := models.Window{From: now.AddDate(0, 0, -30), To: now.Add(time.Hour), Days: 15}
+	tests := []struct {
+		name      string
+		filter    models.RowFilter
+		wantCases []string
+		wantRuns  []string
+	}{
+		{
+			name:      "outside of pull requests",
+			filter:    models.RowFilter{NoPR: true, Test: "TestA"},
+			wantCases: []string{"c0/TestA", "c1/TestA", "c2/TestA", "c3/TestA"},
+			wantRuns:  []string{"c0", "c1", "c2", "c3"},
+		},
+		{
+			name:      "tests",
+			filter:    models.RowFilter{Tests: []string{"TestA", "TestB"}, Commit: "c1"},
+			wantCases: []string{"c1/TestA", "c1/TestB"},
+			wantRuns:  []string{"c1"},
+		},
+		{
+			name:      "commits",
+			filter:    models.RowFilter{Commits: []string{"c0", "p0"}, Result: "fail"},
+			wantCases: []string{"c0/TestA", "p0/TestA"},
+			wantRuns:  []string{"c0", "p0"},
+		},
+		{
+			name:      "no matching test",
+			filter:    models.RowFilter{Tests: []string{"TestC"}},
+			wantCases: nil,
+			wantRuns:  nil,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := tc.filter
+			f.Window = w
+			var cases, runs []string
+			err := m.EachTestCase(context.Background(), f, func(row models.DBTestCase) error {
+				cases = append(cases, row.CommitID+"/"+row.TestName)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = m.EachEnvironmentTest(context.Background(), f, func(row models.DBEnvironmentTest) error {
+				runs = append(runs, row.CommitID)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(cases)
+			sort.Strings(runs)
+			if !reflect.DeepEqual(cases, tc.wantCases) {
+				t.Errorf("EachTestCase got %v, want %v", cases, tc.wantCases)
+			}
+			if !reflect.DeepEqual(runs, tc.wantRuns) {
+				t.Errorf("EachEnvironmentTest got %v, want %v", runs, tc.wantRuns)
+			}
+		})
+	}
+}
+
+func TestGetEnvHealth(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	m := newTestSQLite(t, now)
+	tests := []struct {
+		flakeRate float32
+		wantFlaky int
+	}{
+		// TestA failed 3 of its 5 runs, TestB passed every run
+		{flakeRate: 0, wantFlaky: 2},
+		{flakeRate: 60, wantFlaky: 1},
+		{flakeRate: 60.01, wantFlaky: 0},
+	}
+	for _, tc := range tests {
+		envs, err := m.GetEnvHealth(context.Background(), tc.flakeRate, DefaultWindow(now.Add(time.Hour)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []models.DBEnvHealth{{EnvName: "env", LastTestTime: now, LastNumberOfTests: 2, LastNumberOfFail: 1, FlakyTests: tc.wantFlaky}}
+		if len(envs) != 1 || !envs[0].LastTestTime.Equal(now) {
+			t.Fatalf("GetEnvHealth(%v) = %+v, want %+v", tc.flakeRate, envs, want)
+		}
+		envs[0].LastTestTime = now
+		if !reflect.DeepEqual(envs, want) {
+			t.Errorf("GetEnvHealth(%v) = %+v, want %+v", tc.flakeRate, envs, want)
+		}
+	}
+}
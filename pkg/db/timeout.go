@@ -0,0 +1,244 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// timeout bounds the calls to a database, on top of the deadline and cancelation of their context
+type timeout struct {
+	d       Datab
+	timeout time.Duration
+}
+
+// WithQueryTimeout returns the database canceling each call to its methods after the timeout, 0 for no limit.
+// The calls to the Each methods are canceled once their queries took the timeout, not counting the callbacks writing the rows,
+// and the attempts of a retrying Set are canceled one by one.
+// The errors of the calls canceled or timed out match context.Canceled or context.DeadlineExceeded with errors.Is
+func WithQueryTimeout(d Datab, t time.Duration) Datab {
+	return timeout{d, t}
+}
+
+// contextError is the error of a call whose context is done, matching the reason it is done with errors.Is
+type contextError struct {
+	error
+	cause error
+}
+
+func (e contextError) Unwrap() error {
+	return e.cause
+}
+
+// withTimeout returns ctx canceled after the timeout
+func (m timeout) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if m.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, m.timeout)
+}
+
+// done returns err matching the reason ctx is done, if it is
+func done(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil || errors.Is(err, ctx.Err()) {
+		return err
+	}
+	return contextError{err, context.Cause(ctx)}
+}
+
+// queryBudget cancels the context of a call to an Each method once its queries took the timeout, not counting the callbacks
+type queryBudget struct {
+	timer *time.Timer
+	left  time.Duration
+	since time.Time
+}
+
+// withBudget returns ctx canceled once the queries of the call took the timeout, and the budget to pause while calling back
+func (m timeout) withBudget(ctx context.Context) (context.Context, *queryBudget, context.CancelFunc) {
+	if m.timeout <= 0 {
+		return ctx, nil, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	b := &queryBudget{left: m.timeout, since: time.Now()}
+	b.timer = time.AfterFunc(m.timeout, func() { cancel(context.DeadlineExceeded) })
+	return ctx, b, func() {
+		b.timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// pause stops the timeout while f runs
+func (b *queryBudget) pause(f func() error) error {
+	if b == nil {
+		return f()
+	}
+	b.timer.Stop()
+	b.left -= time.Since(b.since)
+	err := f()
+	b.since = time.Now()
+	b.timer.Reset(max(b.left, 0))
+	return err
+}
+
+// attemptSetter is a database retrying Set, which times out each of its attempts rather than all of them
+type attemptSetter interface {
+	setAttempts(ctx context.Context, attemptTimeout time.Duration, env models.DBEnvironmentTest, tests []models.DBTestCase) error
+}
+
+func (m timeout) Set(ctx context.Context, env models.DBEnvironmentTest, tests []models.DBTestCase) error {
+	if s, ok := m.d.(attemptSetter); ok {
+		return done(ctx, s.setAttempts(ctx, m.timeout, env, tests))
+	}
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	return done(ctx, m.d.Set(ctx, env, tests))
+}
+
+func (m timeout) Initialize(ctx context.Context) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	return done(ctx, m.d.Initialize(ctx))
+}
+
+func (m timeout) EachEnvironmentTest(ctx context.Context, f models.RowFilter, fn func(models.DBEnvironmentTest) error) error {
+	ctx, budget, cancel := m.withBudget(ctx)
+	defer cancel()
+	err := m.d.EachEnvironmentTest(ctx, f, func(r models.DBEnvironmentTest) error {
+		return budget.pause(func() error { return fn(r) })
+	})
+	return done(ctx, err)
+}
+
+func (m timeout) EachTestCase(ctx context.Context, f models.RowFilter, fn func(models.DBTestCase) error) error {
+	ctx, budget, cancel := m.withBudget(ctx)
+	defer cancel()
+	err := m.d.EachTestCase(ctx, f, func(r models.DBTestCase) error {
+		return budget.pause(func() error { return fn(r) })
+	})
+	return done(ctx, err)
+}
+
+func (m timeout) GetEnvCharts(ctx context.Context, env string, testsInTop int, w models.Window) (*models.EnvCharts, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	r, err := m.d.GetEnvCharts(ctx, env, testsInTop, w)
+	return r, done(ctx, err)
+}
+
+func (m timeout) GetOverview(ctx context.Context, w models.Window) (*models.Overview, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	r, err := m.d.GetOverview(ctx, w)
+	return r, done(ctx, err)
+}
+
+func (m timeout) GetTestCharts(ctx context.Context, env string, test string, branch string, w models.Window) (*models.TestCharts, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	r, err := m.d.GetTestCharts(ctx, env, test, branch, w)
+	return r, done(ctx, err)
+}
+
+func (m timeout) GetTestAcrossEnvs(ctx context.Context, test string, w models.Window) (*models.TestAcrossEnvs, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	r, err := m.d.GetTestAcrossEnvs(ctx, test, w)
+	return r, done(ctx, err)
+}
+
+func (m timeout) SetCommits(ctx context.Context, commits []models.DBCommit) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	return done(ctx, m.d.SetCommits(ctx, commits))
+}
+
+func (m timeout) GetCommitHistory(ctx context.Context, env string, branch string, limit int, w models.Window) (*models.CommitHistory, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	r, err := m.d.GetCommitHistory(ctx, env, branch, limit, w)
+	return r, done(ctx, err)
+}
+
+func (m timeout) GetTestHistory(ctx context.Context, env string, test string, branch string, w models.Window) (models.CommitResults, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	r, err := m.d.GetTestHistory(ctx, env, test, branch, w)
+	return r, done(ctx, err)
+}
+
+func (m timeout) GetDurationStats(ctx context.Context, env string, recentSince time.Time, baselineSince time.Time, until time.Time) ([]models.DurationStats, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	r, err := m.d.GetDurationStats(ctx, env, recentSince, baselineSince, until)
+	return r, done(ctx, err)
+}
+
+func (m timeout) GetPRResults(ctx context.Context, pr string, w models.Window) (*models.PRResults, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	r, err := m.d.GetPRResults(ctx, pr, w)
+	return r, done(ctx, err)
+}
+
+func (m timeout) GetEnvs(ctx context.Context) (*models.EnvList, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	r, err := m.d.GetEnvs(ctx)
+	return r, done(ctx, err)
+}
+
+func (m timeout) GetEnvHealth(ctx context.Context, flakeRate float32, w models.Window) ([]models.DBEnvHealth, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	r, err := m.d.GetEnvHealth(ctx, flakeRate, w)
+	return r, done(ctx, err)
+}
+
+func (m timeout) SearchTests(ctx context.Context, query string, regex bool, page int, perPage int, w models.Window) (*models.TestList, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	r, err := m.d.SearchTests(ctx, query, regex, page, perPage, w)
+	return r, done(ctx, err)
+}
+
+func (m timeout) SetQuarantine(ctx context.Context, q models.DBQuarantine) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	return done(ctx, m.d.SetQuarantine(ctx, q))
+}
+
+func (m timeout) GetQuarantine(ctx context.Context, env string) ([]models.DBQuarantine, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	r, err := m.d.GetQuarantine(ctx, env)
+	return r, done(ctx, err)
+}
+
+func (m timeout) DeleteQuarantine(ctx context.Context, env string, test string) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	r, err := m.d.DeleteQuarantine(ctx, env, test)
+	return r, done(ctx, err)
+}
+
+func (m timeout) Ping(ctx context.Context) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	return done(ctx, m.d.Ping(ctx))
+}
+
+func (m timeout) GetSchemaVersion(ctx context.Context) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	r, err := m.d.GetSchemaVersion(ctx)
+	return r, done(ctx, err)
+}
+
+func (m timeout) GetLastViewRefresh(ctx context.Context) (*time.Time, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	r, err := m.d.GetLastViewRefresh(ctx)
+	return r, done(ctx, err)
+}
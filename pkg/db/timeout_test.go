@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+func TestQueryTimeoutContextErrors(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	d := WithQueryTimeout(newTestSQLite(t, now), time.Minute)
+	w := models.Window{From: now.AddDate(0, 0, -30), To: now.Add(time.Hour), Days: 15}
+	run := models.DBEnvironmentTest{CommitID: "c4", EnvName: "env", GopoghTime: now, TestTime: now}
+	cases := []models.DBTestCase{{CommitID: "c4", EnvName: "env", TestName: "TestA", Result: "pass", TestTime: now}}
+	calls := []struct {
+		method string
+		call   func(ctx context.Context) error
+	}{
+		{"Set", func(ctx context.Context) error { return d.Set(ctx, run, cases) }},
+		{"Initialize", d.Initialize},
+		{"EachEnvironmentTest", func(ctx context.Context) error {
+			return d.EachEnvironmentTest(ctx, models.RowFilter{Window: w}, func(models.DBEnvironmentTest) error { return nil })
+		}},
+		{"EachTestCase", func(ctx context.Context) error {
+			return d.EachTestCase(ctx, models.RowFilter{Window: w}, func(models.DBTestCase) error { return nil })
+		}},
+		{"GetEnvCharts", func(ctx context.Context) error { _, err := d.GetEnvCharts(ctx, "env", 10, w); return err }},
+		{"GetOverview", func(ctx context.Context) error { _, err := d.GetOverview(ctx, w); return err }},
+		{"GetTestCharts", func(ctx context.Context) error { _, err := d.GetTestCharts(ctx, "env", "TestA", "", w); return err }},
+		{"GetTestAcrossEnvs", func(ctx context.Context) error { _, err := d.GetTestAcrossEnvs(ctx, "TestA", w); return err }},
+		{"SetCommits", func(ctx context.Context) error {
+			return d.SetCommits(ctx, []models.DBCommit{{CommitID: "c0", Branch: "master", CommitTime: now}})
+		}},
+		{"GetCommitHistory", func(ctx context.Context) error { _, err := d.GetCommitHistory(ctx, "env", "", 10, w); return err }},
+		{"GetTestHistory", func(ctx context.Context) error { _, err := d.GetTestHistory(ctx, "env", "TestA", "", w); return err }},
+		{"GetDurationStats", func(ctx context.Context) error {
+			_, err := d.GetDurationStats(ctx, "env", now.AddDate(0, 0, -2), now.AddDate(0, 0, -10), now)
+			return err
+		}},
+		{"GetPRResults", func(ctx context.Context) error { _, err := d.GetPRResults(ctx, "7", w); return err }},
+		{"GetEnvs", func(ctx context.Context) error { _, err := d.GetEnvs(ctx); return err }},
+		{"GetEnvHealth", func(ctx context.Context) error { _, err := d.GetEnvHealth(ctx, 10, w); return err }},
+		{"SearchTests", func(ctx context.Context) error { _, err := d.SearchTests(ctx, "Test", false, 1, 10, w); return err }},
+		{"SetQuarantine", func(ctx context.Context) error {
+			return d.SetQuarantine(ctx, models.DBQuarantine{EnvName: "env", TestName: "TestA", Reason: "flaky", CreatedAt: now})
+		}},
+		{"GetQuarantine", func(ctx context.Context) error { _, err := d.GetQuarantine(ctx, "env"); return err }},
+		{"DeleteQuarantine", func(ctx context.Context) error { _, err := d.DeleteQuarantine(ctx, "env", "TestA"); return err }},
+		{"Ping", d.Ping},
+		{"GetSchemaVersion", func(ctx context.Context) error { _, err := d.GetSchemaVersion(ctx); return err }},
+		{"GetLastViewRefresh", func(ctx context.Context) error { _, err := d.GetLastViewRefresh(ctx); return err }},
+	}
+	contexts := []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+		want error
+	}{
+		{"canceled", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx, cancel
+		}, context.Canceled},
+		{"expired", func() (context.Context, context.CancelFunc) {
+			return context.WithDeadline(context.Background(), now.Add(-time.Second))
+		}, context.DeadlineExceeded},
+	}
+	for _, c := range calls {
+		for _, tc := range contexts {
+			t.Run(fmt.Sprintf("%s/%s", c.method, tc.name), func(t *testing.T) {
+				ctx, cancel := tc.ctx()
+				defer cancel()
+				if err := c.call(ctx); !errors.Is(err, tc.want) {
+					t.Errorf("%s() = %v, want %v", c.method, err, tc.want)
+				}
+			})
+		}
+	}
+}
+
+// slowDatab is a database whose EachTestCase calls fn once and then waits for its context to be done, like a slow query
+type slowDatab struct {
+	Datab
+}
+
+func (slowDatab) EachTestCase(ctx context.Context, _ models.RowFilter, fn func(models.DBTestCase) error) error {
+	if err := fn(models.DBTestCase{}); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return fmt.Errorf("failed to read test cases: %v", ctx.Err())
+}
+
+func TestQueryTimeoutEach(t *testing.T) {
+	start := time.Now()
+	err := WithQueryTimeout(slowDatab{}, 20*time.Millisecond).EachTestCase(context.Background(), models.RowFilter{}, func(models.DBTestCase) error {
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("EachTestCase() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// the callback does not count in the timeout
+	if took := time.Since(start); took < 70*time.Millisecond {
+		t.Errorf("EachTestCase() took %s, want at least the callback and the timeout, 70ms", took)
+	}
+}
+
+func TestQueryTimeoutEachCallback(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	d := WithQueryTimeout(newTestSQLite(t, now), 20*time.Millisecond)
+	w := models.Window{From: now.AddDate(0, 0, -30), To: now.Add(time.Hour), Days: 15}
+	n := 0
+	err := d.EachEnvironmentTest(context.Background(), models.RowFilter{Window: w}, func(models.DBEnvironmentTest) error {
+		n++
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachEnvironmentTest() = %v, want the callbacks taking longer than the timeout to not cancel it", err)
+	}
+	if n != 5 {
+		t.Errorf("EachEnvironmentTest() called back %d times, want 5", n)
+	}
+}
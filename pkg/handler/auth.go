@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Scope is a permission granted to a bearer token
+type Scope string
+
+const (
+	// ScopeRead allows reading the dashboard data when read authentication is required
+	ScopeRead Scope = "read"
+	// ScopeIngest allows uploading test runs
+	ScopeIngest Scope = "ingest"
+	// ScopeAdmin allows everything including the admin operations
+	ScopeAdmin Scope = "admin"
+)
+
+// Auth holds the static bearer tokens allowed to access the protected endpoints
+type Auth struct {
+	path string
+
+	mu sync.RWMutex
+	// tokens maps the sha256 of a token to its scopes, so that looking up a token does not compare secrets directly
+	tokens map[[sha256.Size]byte]map[Scope]bool
+}
+
+// NewAuth loads the tokens from the file at path.
+// Each non-empty line of the file is a token followed by a comma separated list of scopes, lines starting with # are ignored:
+//
+//	s3cr3t-token ingest
+//	an0ther-token read,admin
+//
+// An empty path configures no tokens, which denies every protected request.
+func NewAuth(path string) (*Auth, error) {
+	a := &Auth{path: path}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload re-reads the tokens file, replacing the previous tokens only if the whole file is valid
+func (a *Auth) Reload() error {
+	tokens := map[[sha256.Size]byte]map[Scope]bool{}
+	if a.path != "" {
+		f, err := os.Open(a.path)
+		if err != nil {
+			return fmt.Errorf("failed to open tokens file: %v", err)
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		scanner := bufio.NewScanner(f)
+		lineNumber := 0
+		for scanner.Scan() {
+			lineNumber++
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				return fmt.Errorf("tokens file line %d: expected a token followed by its scopes", lineNumber)
+			}
+			scopes := map[Scope]bool{}
+			for _, s := range strings.Split(fields[1], ",") {
+				switch scope := Scope(s); scope {
+				case ScopeRead, ScopeIngest, ScopeAdmin:
+					scopes[scope] = true
+				default:
+					return fmt.Errorf("tokens file line %d: unknown scope %q", lineNumber, s)
+				}
+			}
+			tokens[sha256.Sum256([]byte(fields[0]))] = scopes
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read tokens file: %v", err)
+		}
+	}
+
+	a.mu.Lock()
+	a.tokens = tokens
+	a.mu.Unlock()
+	return nil
+}
+
+// Len returns the number of configured tokens
+func (a *Auth) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.tokens)
+}
+
+// allowed checks whether the token grants the scope, the admin scope grants every scope
+func (a *Auth) allowed(token string, scope Scope) (known bool, ok bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	scopes, known := a.tokens[sha256.Sum256([]byte(token))]
+	return known, scopes[scope] || scopes[ScopeAdmin]
+}
+
+// Require wraps next so that it is only served to requests with a bearer token granting scope
+func (a *Auth) Require(scope Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gopogh"`)
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		known, ok := a.allowed(token, scope)
+		if !known {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gopogh", error="invalid_token"`)
+			http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		if !ok {
+			http.Error(w, fmt.Sprintf("token does not have the %q scope", scope), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Optional returns next unchanged if the scope is not required, otherwise it behaves like Require
+func (a *Auth) Optional(required bool, scope Scope, next http.HandlerFunc) http.HandlerFunc {
+	if !required {
+		return next
+	}
+	return a.Require(scope, next)
+}
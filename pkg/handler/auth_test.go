@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTokens writes a tokens file in a temporary directory and returns its path
+func writeTokens(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// serve returns the status of the request with the Authorization header to the handler, which answers 200 if it is reached
+func serve(h func(http.HandlerFunc) http.HandlerFunc, authorization string) int {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	h(func(w http.ResponseWriter, _ *http.Request) {})(w, r)
+	return w.Code
+}
+
+func TestAuthRequire(t *testing.T) {
+	a, err := NewAuth(writeTokens(t, `
+# comment
+read-token read
+ingest-token ingest
+admin-token admin
+both-token read,ingest
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Len() != 4 {
+		t.Errorf("Len() = %d, want 4", a.Len())
+	}
+	tests := []struct {
+		name          string
+		scope         Scope
+		authorization string
+		want          int
+	}{
+		{"missing header", ScopeRead, "", http.StatusUnauthorized},
+		{"not a bearer token", ScopeRead, "Basic cmVhZC10b2tlbg==", http.StatusUnauthorized},
+		{"empty token", ScopeRead, "Bearer ", http.StatusUnauthorized},
+		{"lowercase scheme", ScopeRead, "bearer read-token", http.StatusUnauthorized},
+		{"unknown token", ScopeRead, "Bearer other-token", http.StatusUnauthorized},
+		{"scope", ScopeRead, "Bearer read-token", http.StatusOK},
+		{"wrong scope", ScopeIngest, "Bearer read-token", http.StatusForbidden},
+		{"read is not admin", ScopeAdmin, "Bearer read-token", http.StatusForbidden},
+		{"ingest is not admin", ScopeAdmin, "Bearer ingest-token", http.StatusForbidden},
+		{"one of the scopes", ScopeIngest, "Bearer both-token", http.StatusOK},
+		{"admin implies read", ScopeRead, "Bearer admin-token", http.StatusOK},
+		{"admin implies ingest", ScopeIngest, "Bearer admin-token", http.StatusOK},
+		{"admin", ScopeAdmin, "Bearer admin-token", http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require := func(next http.HandlerFunc) http.HandlerFunc { return a.Require(tc.scope, next) }
+			if got := serve(require, tc.authorization); got != tc.want {
+				t.Errorf("Require(%s) with %q = %d, want %d", tc.scope, tc.authorization, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAuthRequireChallenge(t *testing.T) {
+	a, err := NewAuth(writeTokens(t, "read-token read\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		authorization string
+		want          string
+	}{
+		{"", `Bearer realm="gopogh"`},
+		{"Bearer other-token", `Bearer realm="gopogh", error="invalid_token"`},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.authorization != "" {
+			r.Header.Set("Authorization", tc.authorization)
+		}
+		w := httptest.NewRecorder()
+		a.Require(ScopeRead, func(http.ResponseWriter, *http.Request) {})(w, r)
+		if got := w.Header().Get("WWW-Authenticate"); got != tc.want {
+			t.Errorf("WWW-Authenticate with %q = %q, want %q", tc.authorization, got, tc.want)
+		}
+	}
+}
+
+func TestAuthOptional(t *testing.T) {
+	a, err := NewAuth(writeTokens(t, "read-token read\ningest-token ingest\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		required      bool
+		authorization string
+		want          int
+	}{
+		{"read auth off without a token", false, "", http.StatusOK},
+		{"read auth off with an unknown token", false, "Bearer other-token", http.StatusOK},
+		{"read auth on without a token", true, "", http.StatusUnauthorized},
+		{"read auth on with the scope", true, "Bearer read-token", http.StatusOK},
+		{"read auth on without the scope", true, "Bearer ingest-token", http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			optional := func(next http.HandlerFunc) http.HandlerFunc { return a.Optional(tc.required, ScopeRead, next) }
+			if got := serve(optional, tc.authorization); got != tc.want {
+				t.Errorf("Optional(%v) with %q = %d, want %d", tc.required, tc.authorization, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewAuthInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"token without scopes", "read-token\n", "tokens file line 1: expected a token followed by its scopes"},
+		{"scopes separated by spaces", "# tokens\nread-token read ingest\n", "tokens file line 2: expected a token followed by its scopes"},
+		{"unknown scope", "read-token read,write\n", `tokens file line 1: unknown scope "write"`},
+		{"empty scope", "read-token read,\n", `tokens file line 1: unknown scope ""`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewAuth(writeTokens(t, tc.content))
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("NewAuth() error = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+	if _, err := NewAuth(filepath.Join(t.TempDir(), "missing")); err == nil || !strings.HasPrefix(err.Error(), "failed to open tokens file") {
+		t.Errorf("NewAuth() of a missing file error = %v, want a failure to open it", err)
+	}
+}
+
+func TestAuthNoTokens(t *testing.T) {
+	a, err := NewAuth("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	require := func(next http.HandlerFunc) http.HandlerFunc { return a.Require(ScopeIngest, next) }
+	if got := serve(require, "Bearer any-token"); got != http.StatusUnauthorized {
+		t.Errorf("Require() without tokens = %d, want %d", got, http.StatusUnauthorized)
+	}
+}
+
+func TestAuthReload(t *testing.T) {
+	path := writeTokens(t, "old-token ingest\n")
+	a, err := NewAuth(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require := func(next http.HandlerFunc) http.HandlerFunc { return a.Require(ScopeIngest, next) }
+
+	if err := os.WriteFile(path, []byte("new-token ingest\nbad-token write\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Reload(); err == nil {
+		t.Fatal("Reload() of an invalid file succeeded")
+	}
+	if got := serve(require, "Bearer old-token"); got != http.StatusOK {
+		t.Errorf("the previous token after an invalid reload = %d, want %d", got, http.StatusOK)
+	}
+	if got := serve(require, "Bearer new-token"); got != http.StatusUnauthorized {
+		t.Errorf("a token of the invalid file = %d, want %d", got, http.StatusUnauthorized)
+	}
+
+	if err := os.WriteFile(path, []byte("new-token ingest\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := serve(require, "Bearer old-token"); got != http.StatusUnauthorized {
+		t.Errorf("the replaced token = %d, want %d", got, http.StatusUnauthorized)
+	}
+	if got := serve(require, "Bearer new-token"); got != http.StatusOK {
+		t.Errorf("the new token = %d, want %d", got, http.StatusOK)
+	}
+}
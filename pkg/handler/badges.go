@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// The colors of the badges
+const (
+	badgeGreen  = "#4c1"
+	badgeYellow = "#dfb317"
+	badgeRed    = "#e05d44"
+	badgeGrey   = "#9f9f9f"
+)
+
+// badgeMaxAge is the number of seconds clients and proxies may cache a badge for
+const badgeMaxAge = 300
+
+// ServeEnvBadge writes an SVG badge of the pass and fail counts of the latest run of the env query parameter in the window,
+// yellow from warn (1 by default) and red from error (10 by default) failures
+func (m *DB) ServeEnvBadge(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	env := queryValues.Get("env")
+	if env == "" {
+		http.Error(w, "missing environment name", http.StatusUnprocessableEntity)
+		return
+	}
+	warn, errorAt, err := badgeThresholds(queryValues, 1, 10)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	window, err := windowParams(queryValues)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	var latest *models.DBEnvironmentTest
+	err = m.Database.EachEnvironmentTest(r.Context(), models.RowFilter{Env: env, Window: window, Limit: 1}, func(row models.DBEnvironmentTest) error {
+		latest = &row
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	label := badgeLabel(queryValues, env)
+	if latest == nil {
+		writeBadge(w, r, label, "no runs", badgeGrey)
+		return
+	}
+	writeBadge(w, r, label, fmt.Sprintf("%d passed, %d failed", latest.NumberOfPass, latest.NumberOfFail),
+		badgeColor(float64(latest.NumberOfFail), warn, errorAt))
+}
+
+// ServeTestBadge writes an SVG badge of the flake rate of the test query parameter on the env query parameter in the recent window,
+// the days before the end of the window, yellow from warn (5 by default) and red from error (20 by default) percent,
+// and grey with no runs when the test has no runs there or the environment or test is unknown
+func (m *DB) ServeTestBadge(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	env := queryValues.Get("env")
+	if env == "" {
+		http.Error(w, "missing environment name", http.StatusUnprocessableEntity)
+		return
+	}
+	test := queryValues.Get("test")
+	if test == "" {
+		http.Error(w, "missing test name", http.StatusUnprocessableEntity)
+		return
+	}
+	warn, errorAt, err := badgeThresholds(queryValues, 5, 20)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	window, err := windowParams(queryValues)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	label := badgeLabel(queryValues, test)
+	// an unknown environment or test fails to load, and an error body would show as a broken image where the badge is embedded
+	data, err := m.Database.GetTestCharts(r.Context(), env, test, queryValues.Get("branch"), window)
+	if err != nil {
+		log.Printf("failed to get the badge of %s on %s: %v", test, env, err)
+		writeBadge(w, r, label, "no runs", badgeGrey)
+		return
+	}
+	if data == nil {
+		writeBadge(w, r, label, "no runs", badgeGrey)
+		return
+	}
+	recentSince := window.To.AddDate(0, 0, -window.Days)
+	runs, fails := 0, 0
+	for _, day := range data.FlakeByDay {
+		for _, c := range day.CommitResultsAndDurations {
+			if c.Time.Before(recentSince) || c.Result == "skip" {
+				continue
+			}
+			runs++
+			if c.Result == "fail" {
+				fails++
+			}
+		}
+	}
+	if runs == 0 {
+		writeBadge(w, r, label, "no runs", badgeGrey)
+		return
+	}
+	flakeRate := float64(fails) * 100 / float64(runs)
+	writeBadge(w, r, label, fmt.Sprintf("%.0f%% flaky", flakeRate), badgeColor(flakeRate, warn, errorAt))
+}
+
+// badgeThresholds parses the warn and error query parameters, the values from which a badge is yellow and red
+func badgeThresholds(queryValues url.Values, defaultWarn, defaultError float64) (float64, float64, error) {
+	parse := func(name string, defaultValue float64) (float64, error) {
+		s := queryValues.Get(name)
+		if s == "" {
+			return defaultValue, nil
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("%s must be a non-negative number", name)
+		}
+		return v, nil
+	}
+	warn, err := parse("warn", defaultWarn)
+	if err != nil {
+		return 0, 0, err
+	}
+	errorAt, err := parse("error", defaultError)
+	if err != nil {
+		return 0, 0, err
+	}
+	if warn > errorAt {
+		return 0, 0, fmt.Errorf("warn must not be greater than error")
+	}
+	return warn, errorAt, nil
+}
+
+// badgeLabel returns the label query parameter, or the default label if it is empty
+func badgeLabel(queryValues url.Values, defaultLabel string) string {
+	if label := queryValues.Get("label"); label != "" {
+		return label
+	}
+	return defaultLabel
+}
+
+// badgeColor returns the color of the value: green below warn, yellow below error and red from error
+func badgeColor(value, warn, errorAt float64) string {
+	switch {
+	case value >= errorAt:
+		return badgeRed
+	case value >= warn:
+		return badgeYellow
+	default:
+		return badgeGreen
+	}
+}
+
+// badgeTextWidth estimates the width in pixels of the text in the 11px Verdana of the badges
+func badgeTextWidth(text string) int {
+	return utf8.RuneCountInString(text)*7 + 10
+}
+
+// badgeSVG renders a flat badge of the label and message, with the message on the color
+func badgeSVG(label, message, color string) []byte {
+	lw, mw := badgeTextWidth(label), badgeTextWidth(message)
+	title := html.EscapeString(label + ": " + message)
+	label, message = html.EscapeString(label), html.EscapeString(message)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s">`, lw+mw, title)
+	fmt.Fprintf(&b, `<title>%s</title>`, title)
+	b.WriteString(`<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`)
+	fmt.Fprintf(&b, `<clipPath id="r"><rect width="%d" height="20" rx="3" fill="#fff"/></clipPath>`, lw+mw)
+	fmt.Fprintf(&b, `<g clip-path="url(#r)"><rect width="%d" height="20" fill="#555"/><rect x="%d" width="%d" height="20" fill="%s"/><rect width="%d" height="20" fill="url(#s)"/></g>`, lw, lw, mw, color, lw+mw)
+	b.WriteString(`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`)
+	for _, t := range []struct {
+		x    int
+		text string
+	}{{lw / 2, label}, {lw + mw/2, message}} {
+		fmt.Fprintf(&b, `<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text>`, t.x, t.text, t.x, t.text)
+	}
+	b.WriteString(`</g></svg>`)
+	return b.Bytes()
+}
+
+// writeBadge writes the badge to an SVG HTTP response that can be cached for badgeMaxAge seconds,
+// answering the requests of an unchanged badge with a 304 Not Modified from its ETag
+func writeBadge(w http.ResponseWriter, r *http.Request, label, message, color string) {
+	svg := badgeSVG(label, message, color)
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", badgeMaxAge))
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(svg)))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(svg))
+}
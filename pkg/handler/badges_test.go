@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/medyagh/gopogh/pkg/db"
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// unknownEnvDB fails to chart every test like the postgres database for an unknown environment
+type unknownEnvDB struct {
+	db.Datab
+}
+
+func (unknownEnvDB) GetTestCharts(_ context.Context, env string, _ string, _ string, _ models.Window) (*models.TestCharts, error) {
+	return nil, errors.New("invalid environment. Not found in database: " + env)
+}
+
+func TestServeTestBadgeNoRuns(t *testing.T) {
+	database, err := db.FromEnv(db.FlagValues{Backend: "sqlite", Host: "localhost", Path: filepath.Join(t.TempDir(), "gopogh.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Initialize(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		database db.Datab
+	}{
+		{"unknown test", database},
+		{"unknown environment", unknownEnvDB{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &DB{Database: tc.database}
+			w := httptest.NewRecorder()
+			m.ServeTestBadge(w, httptest.NewRequest(http.MethodGet, "/badge/test?env=env&test=TestA", nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("ServeTestBadge() = %d %s, want %d", w.Code, w.Body.String(), http.StatusOK)
+			}
+			if got := w.Header().Get("Content-Type"); got != "image/svg+xml" {
+				t.Errorf("Content-Type = %q, want image/svg+xml", got)
+			}
+			if body := w.Body.String(); !strings.Contains(body, "TestA: no runs") || !strings.Contains(body, badgeGrey) {
+				t.Errorf("ServeTestBadge() = %s, want a grey no runs badge", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gopogh_cache_requests_total",
+	Help: "Number of requests to the cached endpoints by whether their response was cached.",
+}, []string{"result"})
+
+// ResponseCache keeps the successful responses of the dashboard endpoints by url and query parameters, as some link to the url they were requested from,
+// until they are older than its ttl or the data changes
+type ResponseCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	// generation counts the invalidations, the responses computed before the latest one are not kept
+	generation uint64
+}
+
+// cacheEntry is a response being computed or computed
+type cacheEntry struct {
+	ready   chan struct{}   // closed once the response is computed
+	resp    *cachedResponse // nil if the response could not be kept
+	expires time.Time
+	used    time.Time // when the entry was last requested, the least recently used entry is evicted first
+}
+
+// cachedResponse is a successful response with its validators and compressed body
+type cachedResponse struct {
+	header   http.Header
+	body     []byte
+	gzipped  []byte
+	etag     string
+	modified time.Time
+}
+
+// NewResponseCache returns a cache keeping at most maxEntries responses for at most ttl
+func NewResponseCache(ttl time.Duration, maxEntries int) *ResponseCache {
+	return &ResponseCache{ttl: ttl, maxEntries: maxEntries, entries: map[string]*cacheEntry{}}
+}
+
+// Invalidate drops every response, to be called when the data changes. Invalidating a nil cache does nothing
+func (c *ResponseCache) Invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.entries = map[string]*cacheEntry{}
+	c.generation++
+	c.mu.Unlock()
+}
+
+// Wrap serves the responses of h from the cache, computing the response of concurrent requests with the same parameters once.
+// The responses have an ETag and Last-Modified to answer conditional requests with 304 Not Modified, and are gzipped if the client accepts it.
+// A nil cache serves every request from h
+func (c *ResponseCache) Wrap(h http.HandlerFunc) http.HandlerFunc {
+	if c == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := baseURL(r) + r.URL.Path + "?" + r.URL.Query().Encode()
+		now := time.Now()
+
+		c.mu.Lock()
+		e, ok := c.entries[key]
+		if ok && isClosed(e.ready) && (e.resp == nil || now.After(e.expires)) {
+			delete(c.entries, key)
+			ok = false
+		}
+		if ok {
+			e.used = now
+			c.mu.Unlock()
+			select {
+			case <-e.ready:
+			case <-r.Context().Done():
+				return
+			}
+			if e.resp == nil {
+				cacheRequests.WithLabelValues("miss").Inc()
+				h(w, r)
+				return
+			}
+			cacheRequests.WithLabelValues("hit").Inc()
+			e.resp.serve(w, r)
+			return
+		}
+		e = &cacheEntry{ready: make(chan struct{}), used: now}
+		c.evict(now)
+		c.entries[key] = e
+		generation := c.generation
+		c.mu.Unlock()
+
+		cacheRequests.WithLabelValues("miss").Inc()
+		buf, resp := c.fill(key, e, generation, h, r, now)
+		if resp == nil {
+			buf.writeTo(w)
+			return
+		}
+		resp.serve(w, r)
+	}
+}
+
+// fill computes the response of the entry with h, keeping it if the response can be cached and was computed since the latest invalidation.
+// The entry is completed even if h panics, so that the requests waiting for it do not block and the next requests compute their response again
+func (c *ResponseCache) fill(key string, e *cacheEntry, generation uint64, h http.HandlerFunc, r *http.Request, now time.Time) (buf *bufferedResponse, resp *cachedResponse) {
+	defer func() {
+		c.mu.Lock()
+		if resp != nil && generation == c.generation {
+			e.resp = resp
+			e.expires = now.Add(c.ttl)
+		} else if c.entries[key] == e {
+			delete(c.entries, key)
+		}
+		close(e.ready)
+		c.mu.Unlock()
+	}()
+	buf = &bufferedResponse{header: http.Header{}}
+	h(buf, r)
+	if buf.code == http.StatusOK {
+		resp = newCachedResponse(buf, now)
+	}
+	return buf, resp
+}
+
+// evict makes room for a new entry by dropping the expired entries, or the least recently used computed entry if none expired.
+// It is called with mu locked
+func (c *ResponseCache) evict(now time.Time) {
+	if len(c.entries) < c.maxEntries {
+		return
+	}
+	lruKey := ""
+	var lru time.Time
+	for key, e := range c.entries {
+		if !isClosed(e.ready) {
+			continue
+		}
+		if now.After(e.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if lruKey == "" || e.used.Before(lru) {
+			lruKey, lru = key, e.used
+		}
+	}
+	if len(c.entries) >= c.maxEntries && lruKey != "" {
+		delete(c.entries, lruKey)
+	}
+}
+
+// isClosed checks whether the channel is closed without blocking
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// ServeRefreshCache drops the cached responses, for example after the materialized views are refreshed
+func (m *DB) ServeRefreshCache(w http.ResponseWriter, _ *http.Request) {
+	m.Cache.Invalidate()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// bufferedResponse keeps a response in memory until it is known whether it can be cached
+type bufferedResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.code == 0 {
+		b.code = code
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.code == 0 {
+		b.code = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// writeTo writes the response as it was written to b
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	if b.code != 0 {
+		w.WriteHeader(b.code)
+	}
+	_, _ = w.Write(b.body.Bytes())
+}
+
+func newCachedResponse(b *bufferedResponse, now time.Time) *cachedResponse {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write(b.body.Bytes())
+	_ = zw.Close()
+	// the validators are weak as the gzipped and plain bodies are the same response
+	return &cachedResponse{
+		header:   b.header,
+		body:     b.body.Bytes(),
+		gzipped:  gz.Bytes(),
+		etag:     fmt.Sprintf(`W/"%x"`, sha256.Sum256(b.body.Bytes())),
+		modified: now.UTC().Truncate(time.Second),
+	}
+}
+
+// serve writes the response, or 304 Not Modified if the client has it already
+func (c *cachedResponse) serve(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	for k, v := range c.header {
+		h[k] = v
+	}
+	h.Set("ETag", c.etag)
+	h.Set("Last-Modified", c.modified.Format(http.TimeFormat))
+	// clients revalidate every time so that they see the ingested runs, which costs a 304 while the data is unchanged
+	h.Set("Cache-Control", "no-cache")
+	h.Add("Vary", "Accept-Encoding")
+	if c.notModified(r) {
+		h.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	body := c.body
+	if acceptsGzip(r) {
+		h.Set("Content-Encoding", "gzip")
+		body = c.gzipped
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	_, _ = w.Write(body)
+}
+
+// notModified checks the conditional headers of the request, If-None-Match taking precedence over If-Modified-Since
+func (c *cachedResponse) notModified(r *http.Request) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(c.etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		return err == nil && !c.modified.After(t)
+	}
+	return false
+}
+
+// acceptsGzip checks whether the Accept-Encoding of the request allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.TrimSpace(coding)
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResponseCacheHandlerPanic(t *testing.T) {
+	c := NewResponseCache(time.Minute, 10)
+	calls := 0
+	h := c.Wrap(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("handler failed")
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic of the handler was not propagated")
+			}
+		}()
+		h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/env", nil))
+	}()
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/env", nil))
+		done <- rec
+	}()
+	select {
+	case rec := <-done:
+		if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+			t.Errorf("got %d %q after the handler panicked, want 200 \"ok\"", rec.Code, rec.Body.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request after the handler panicked blocked on its cache entry")
+	}
+	if calls != 2 {
+		t.Errorf("the handler was called %d times, want 2", calls)
+	}
+}
+
+// countingHandler answers the path of the request with its body, counting the calls by path
+type countingHandler struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (h *countingHandler) serve(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	h.calls[r.URL.Path]++
+	h.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(strings.Repeat("response of "+r.URL.Path+" ", 10)))
+}
+
+func (h *countingHandler) count(path string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.calls[path]
+}
+
+// get requests the path from the handler with the headers
+func get(h http.HandlerFunc, path string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func TestResponseCacheConditional(t *testing.T) {
+	counting := &countingHandler{calls: map[string]int{}}
+	h := NewResponseCache(time.Minute, 10).Wrap(counting.serve)
+	first := get(h, "/env", nil)
+	etag, modified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+	if first.Code != http.StatusOK || etag == "" || modified == "" {
+		t.Fatalf("got %d with ETag %q and Last-Modified %q, want 200 with both", first.Code, etag, modified)
+	}
+	lastModified, err := http.ParseTime(modified)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{"unconditional", nil, http.StatusOK},
+		{"matching etag", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"strong form of the etag", map[string]string{"If-None-Match": strings.TrimPrefix(etag, "W/")}, http.StatusNotModified},
+		{"one of the etags", map[string]string{"If-None-Match": `"other", ` + etag}, http.StatusNotModified},
+		{"any etag", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+		{"other etag", map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{"etag takes precedence", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": modified}, http.StatusOK},
+		{"modified since", map[string]string{"If-Modified-Since": lastModified.Add(-time.Second).Format(http.TimeFormat)}, http.StatusOK},
+		{"not modified since", map[string]string{"If-Modified-Since": modified}, http.StatusNotModified},
+		{"not modified since later", map[string]string{"If-Modified-Since": lastModified.Add(time.Hour).Format(http.TimeFormat)}, http.StatusNotModified},
+		{"invalid date", map[string]string{"If-Modified-Since": "yesterday"}, http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := get(h, "/env", tc.header)
+			if w.Code != tc.want {
+				t.Errorf("got %d, want %d", w.Code, tc.want)
+			}
+			if tc.want == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("got a body of %d bytes with 304, want none", w.Body.Len())
+			}
+		})
+	}
+	if n := counting.count("/env"); n != 1 {
+		t.Errorf("the handler was called %d times, want once", n)
+	}
+}
+
+func TestResponseCacheGzip(t *testing.T) {
+	counting := &countingHandler{calls: map[string]int{}}
+	h := NewResponseCache(time.Minute, 10).Wrap(counting.serve)
+	want := strings.Repeat("response of /env ", 10)
+	tests := []struct {
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{"identity", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.8", true},
+		{"*", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0", false},
+		{"deflate, br", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.acceptEncoding, func(t *testing.T) {
+			w := get(h, "/env", map[string]string{"Accept-Encoding": tc.acceptEncoding})
+			if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			body := w.Body.String()
+			if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(body)) {
+				t.Errorf("Content-Length = %s, want %d", got, len(body))
+			}
+			if gzipped := w.Header().Get("Content-Encoding") == "gzip"; gzipped != tc.wantGzip {
+				t.Fatalf("gzipped = %v, want %v", gzipped, tc.wantGzip)
+			}
+			if tc.wantGzip {
+				zr, err := gzip.NewReader(strings.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := io.ReadAll(zr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = string(b)
+			}
+			if body != want {
+				t.Errorf("body = %q, want %q", body, want)
+			}
+		})
+	}
+}
+
+func TestResponseCacheInvalidateDuringFill(t *testing.T) {
+	c := NewResponseCache(time.Minute, 10)
+	started, release := make(chan struct{}), make(chan struct{})
+	version := 1
+	var mu sync.Mutex
+	h := c.Wrap(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		v := version
+		mu.Unlock()
+		if v == 1 {
+			close(started)
+			<-release
+		}
+		_, _ = w.Write([]byte(strconv.Itoa(v)))
+	})
+
+	done := make(chan string)
+	go func() {
+		done <- get(h, "/env", nil).Body.String()
+	}()
+	<-started
+	// a run is ingested while the response of the previous data is computed
+	mu.Lock()
+	version = 2
+	mu.Unlock()
+	c.Invalidate()
+	close(release)
+	if got := <-done; got != "1" {
+		t.Errorf("the in-flight request got %q, want the response it computed, 1", got)
+	}
+	if got := get(h, "/env", nil).Body.String(); got != "2" {
+		t.Errorf("got %q after the invalidation, want the response of the new data, 2", got)
+	}
+}
+
+func TestResponseCacheExpiryAndEviction(t *testing.T) {
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		maxEntries int
+		// paths are requested in order, sleep waits for the ttl to pass
+		paths     []string
+		wantCalls map[string]int
+	}{
+		{
+			name: "cached", ttl: time.Minute, maxEntries: 10,
+			paths:     []string{"/a", "/a", "/b", "/a"},
+			wantCalls: map[string]int{"/a": 1, "/b": 1},
+		},
+		{
+			name: "expired", ttl: 20 * time.Millisecond, maxEntries: 10,
+			paths:     []string{"/a", "/a", "sleep", "/a"},
+			wantCalls: map[string]int{"/a": 2},
+		},
+		{
+			name: "least recently used evicted", ttl: time.Minute, maxEntries: 2,
+			paths:     []string{"/a", "/b", "/a", "/c", "/a", "/b"},
+			wantCalls: map[string]int{"/a": 1, "/b": 2, "/c": 1},
+		},
+		{
+			name: "expired evicted before the least recently used", ttl: 20 * time.Millisecond, maxEntries: 2,
+			paths:     []string{"/a", "sleep", "/b", "/c", "/b"},
+			wantCalls: map[string]int{"/a": 1, "/b": 1, "/c": 1},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			counting := &countingHandler{calls: map[string]int{}}
+			h := NewResponseCache(tc.ttl, tc.maxEntries).Wrap(counting.serve)
+			for _, path := range tc.paths {
+				if path == "sleep" {
+					time.Sleep(2 * tc.ttl)
+					continue
+				}
+				// distinct times order the uses of the entries
+				time.Sleep(time.Millisecond)
+				if w := get(h, path, nil); w.Code != http.StatusOK {
+					t.Fatalf("got %d for %s, want 200", w.Code, path)
+				}
+			}
+			for path, want := range tc.wantCalls {
+				if got := counting.count(path); got != want {
+					t.Errorf("the handler was called %d times for %s, want %d", got, path, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/analysis"
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// atomFeed is an Atom feed, see RFC 4287
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Content atomContent `xml:"content"`
+}
+
+// ServeFeed writes the most recent runs of the env query parameter, or of every environment if it is empty, to an Atom feed,
+// with the failures of each run and the tests that started failing or were fixed since the previous run of its environment
+func (m *DB) ServeFeed(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	env := queryValues.Get("env")
+	limit, err := positiveIntParam(queryValues, "limit", 50)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if limit > 500 {
+		http.Error(w, "limit must be at most 500", http.StatusUnprocessableEntity)
+		return
+	}
+	window, err := windowParams(queryValues)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	changes, err := analysis.RunChanges(r.Context(), m.Database, env, window, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	base := baseURL(r)
+	self := base + "/feed.atom"
+	title := "gopogh runs"
+	dashboard := base + "/"
+	if env != "" {
+		self += "?env=" + url.QueryEscape(env)
+		title += " of " + env
+		dashboard += "?env=" + url.QueryEscape(env)
+	}
+	feed := atomFeed{
+		ID:      self,
+		Title:   title,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: "gopogh"},
+		Links:   []atomLink{{Rel: "self", Type: "application/atom+xml", Href: self}, {Rel: "alternate", Type: "text/html", Href: dashboard}},
+	}
+	if len(changes) > 0 {
+		feed.Updated = changes[0].Run.TestTime.UTC().Format(time.RFC3339)
+	}
+	for _, c := range changes {
+		report := fmt.Sprintf("%s/report?env=%s&commit=%s", base, url.QueryEscape(c.Run.EnvName), url.QueryEscape(c.Run.CommitID))
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      report,
+			Title:   feedEntryTitle(c),
+			Updated: c.Run.TestTime.UTC().Format(time.RFC3339),
+			Link:    atomLink{Rel: "alternate", Type: "text/html", Href: report},
+			Content: atomContent{Type: "html", Body: feedEntryHTML(c, base)},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	_, _ = w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	_ = enc.Encode(feed)
+}
+
+// feedEntryTitle summarizes the run in a feed entry title, e.g. "Docker_Linux 1a2b3c4: 3 failed, 2 newly failing"
+func feedEntryTitle(c models.RunChange) string {
+	title := fmt.Sprintf("%s %s: %d failed", c.Run.EnvName, analysis.ShortCommit(c.Run.CommitID), c.Run.NumberOfFail)
+	if c.PR != "" {
+		title = fmt.Sprintf("%s PR #%s %s: %d failed", c.Run.EnvName, c.PR, analysis.ShortCommit(c.Run.CommitID), c.Run.NumberOfFail)
+	}
+	if len(c.NewFailures) > 0 {
+		title += fmt.Sprintf(", %d newly failing", len(c.NewFailures))
+	}
+	if len(c.Fixed) > 0 {
+		title += fmt.Sprintf(", %d fixed", len(c.Fixed))
+	}
+	return title
+}
+
+// feedEntryHTML describes the run in the html content of a feed entry, linking the tests to the dashboard at base
+func feedEntryHTML(c models.RunChange, base string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<p>%d passed, %d failed and %d skipped in %s",
+		c.Run.NumberOfPass, c.Run.NumberOfFail, c.Run.NumberOfSkip, time.Duration(c.Run.TotalDuration*float64(time.Second)).Round(time.Second))
+	if c.Previous != nil {
+		fmt.Fprintf(&b, ", %d failed in the previous run (%s)", c.Previous.NumberOfFail, html.EscapeString(analysis.ShortCommit(c.Previous.CommitID)))
+	}
+	b.WriteString(".</p>")
+	tests := func(heading string, names []string) {
+		if len(names) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "<p>%s:</p><ul>", heading)
+		for _, name := range names {
+			link := fmt.Sprintf("%s/?env=%s&test=%s", base, url.QueryEscape(c.Run.EnvName), url.QueryEscape(name))
+			fmt.Fprintf(&b, `<li><a href="%s">%s</a></li>`, html.EscapeString(link), html.EscapeString(name))
+		}
+		b.WriteString("</ul>")
+	}
+	tests("Newly failing", c.NewFailures)
+	tests("Fixed", c.Fixed)
+	return b.String()
+}
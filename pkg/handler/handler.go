@@ -6,23 +6,65 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strconv"
+	"sync"
+	"time"
 
+	"github.com/medyagh/gopogh/pkg/analysis"
 	"github.com/medyagh/gopogh/pkg/db"
+	"github.com/medyagh/gopogh/pkg/models"
+	"github.com/medyagh/gopogh/pkg/notify"
 	"github.com/medyagh/gopogh/pkg/report"
+	"github.com/medyagh/gopogh/pkg/store"
 )
 
 // DB is a handler that holds a database instance
 type DB struct {
 	Database db.Datab
+	// Reports stores the HTML reports of the ingested runs, nil disables storing them
+	Reports store.Blob
+	// ReportFallbackURL is where reports missing from Reports are redirected to, {env} and {commit} are replaced by the request values
+	ReportFallbackURL string
+	// Notifier sends the events of the ingested runs to webhooks, nil disables the webhooks
+	Notifier *notify.Notifier
+	// Cache keeps the responses of the dashboard endpoints until a run is ingested, nil disables caching
+	Cache *ResponseCache
+	// HealthTimeout is how long the health checks wait for the database, 2 seconds if zero
+	HealthTimeout time.Duration
+	// MaxUploadSize is the largest decompressed run in bytes accepted by the ingestion, 64 MiB if zero
+	MaxUploadSize int64
+
+	// suggestions is the latest quarantine suggestion report, nil until one is generated
+	suggestionsMu sync.Mutex
+	suggestions   *models.SuggestionReport
 }
 
 //go:embed flake_chart.html
 var flakeChartHTML string
 
-// ServeEnvironmentTestsAndTestCases writes the environment tests and test cases to a JSON HTTP response
-func (m *DB) ServeEnvironmentTestsAndTestCases(w http.ResponseWriter, _ *http.Request) {
-	data, err := m.Database.GetEnvironmentTestsAndTestCases()
+// ServeTestCharts writes the individual test charts to a JSON HTTP response
+// without an environment name it writes the comparison of the test across the environments instead
+func (m *DB) ServeTestCharts(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	test := queryValues.Get("test")
+	if test == "" {
+		http.Error(w, "missing test name", http.StatusUnprocessableEntity)
+		return
+	}
+	window, err := windowParams(queryValues)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	env := queryValues.Get("env")
+	if env == "" {
+		m.serveTestAcrossEnvs(w, r, test, window)
+		return
+	}
+
+	data, err := m.Database.GetTestCharts(r.Context(), env, test, queryValues.Get("branch"), window)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -31,22 +73,56 @@ func (m *DB) ServeEnvironmentTestsAndTestCases(w http.ResponseWriter, _ *http.Re
 		http.Error(w, "data not found", http.StatusNotImplemented)
 		return
 	}
-	jsonData, err := json.Marshal(data)
+	writeJSON(w, data)
+}
+
+// serveTestAcrossEnvs writes the results of a test in the recent window on every environment to a JSON HTTP response
+func (m *DB) serveTestAcrossEnvs(w http.ResponseWriter, r *http.Request, test string, window models.Window) {
+	data, err := m.Database.GetTestAcrossEnvs(r.Context(), test, window)
 	if err != nil {
-		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	_, err = w.Write(jsonData)
+	if data == nil {
+		http.Error(w, "data not found", http.StatusNotImplemented)
+		return
+	}
+	writeJSON(w, data)
+}
+
+// ServeCommitHistory writes the last runs of an environment in commit order to a JSON HTTP response
+func (m *DB) ServeCommitHistory(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	env := queryValues.Get("env")
+	if env == "" {
+		http.Error(w, "missing environment name", http.StatusUnprocessableEntity)
+		return
+	}
+	limit, err := positiveIntParam(queryValues, "limit", 100)
 	if err != nil {
-		http.Error(w, "Failed to write JSON data", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		return
 	}
+	window, err := windowParams(queryValues)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	data, err := m.Database.GetCommitHistory(r.Context(), env, queryValues.Get("branch"), limit, window)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data == nil {
+		http.Error(w, "data not found", http.StatusNotImplemented)
+		return
+	}
+	writeJSON(w, data)
 }
 
-// ServeTestCharts writes the individual test charts to a JSON HTTP response
-func (m *DB) ServeTestCharts(w http.ResponseWriter, r *http.Request) {
+// ServeFirstFailure writes the range of commits a test started failing in to a JSON HTTP response
+func (m *DB) ServeFirstFailure(w http.ResponseWriter, r *http.Request) {
 	queryValues := r.URL.Query()
 	env := queryValues.Get("env")
 	if env == "" {
@@ -58,8 +134,13 @@ func (m *DB) ServeTestCharts(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing test name", http.StatusUnprocessableEntity)
 		return
 	}
+	window, err := windowParams(queryValues)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 
-	data, err := m.Database.GetTestCharts(env, test)
+	data, err := analysis.FindFirstFailure(r.Context(), m.Database, env, test, queryValues.Get("branch"), window)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -68,18 +149,123 @@ func (m *DB) ServeTestCharts(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "data not found", http.StatusNotImplemented)
 		return
 	}
-	jsonData, err := json.Marshal(data)
+	writeJSON(w, data)
+}
+
+// ServeEnvs writes every environment with its most recent run to a JSON HTTP response
+func (m *DB) ServeEnvs(w http.ResponseWriter, r *http.Request) {
+	data, err := m.Database.GetEnvs(r.Context())
 	if err != nil {
-		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	_, err = w.Write(jsonData)
+	if data == nil {
+		http.Error(w, "data not found", http.StatusNotImplemented)
+		return
+	}
+	writeJSON(w, data)
+}
+
+// maxTestsPerPage is the maximum number of tests on a page of the test search
+const maxTestsPerPage = 500
+
+// ServeTests writes a page of the test names matching the q query parameter to a JSON HTTP response
+// q is a substring, or a regular expression with regex=true, matched ignoring case
+func (m *DB) ServeTests(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	query := queryValues.Get("q")
+	regex := queryValues.Get("regex") == "true"
+	if regex {
+		if _, err := regexp.Compile(query); err != nil {
+			http.Error(w, fmt.Sprintf("invalid regular expression: %v", err), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	page, err := positiveIntParam(queryValues, "page", 1)
 	if err != nil {
-		http.Error(w, "Failed to write JSON data", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	perPage, err := positiveIntParam(queryValues, "per_page", 50)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	perPage = min(perPage, maxTestsPerPage)
+	window, err := windowParams(queryValues)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	data, err := m.Database.SearchTests(r.Context(), query, regex, page, perPage, window)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data == nil {
+		http.Error(w, "data not found", http.StatusNotImplemented)
 		return
 	}
+	writeJSON(w, data)
+}
+
+// positiveIntParam parses the query parameter as a positive integer, returning the default value if it is empty
+func positiveIntParam(queryValues url.Values, name string, defaultValue int) (int, error) {
+	value := queryValues.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+	return n, nil
+}
+
+// windowParams parses the analysis window from the from, to and days query parameters, defaulting to db.DefaultWindow
+// from and to are RFC3339 times or YYYY-MM-DD dates, a to date includes the whole day, and from defaults to 90 days before to
+func windowParams(queryValues url.Values) (models.Window, error) {
+	window := db.DefaultWindow(time.Now())
+	days, err := positiveIntParam(queryValues, "days", window.Days)
+	if err != nil {
+		return window, err
+	}
+	window.Days = days
+	if to := queryValues.Get("to"); to != "" {
+		t, isDate, err := parseTimeParam(to)
+		if err != nil {
+			return window, fmt.Errorf("invalid to: %v", err)
+		}
+		if isDate {
+			t = t.AddDate(0, 0, 1)
+		}
+		window.From = window.From.Add(t.Sub(window.To))
+		window.To = t
+	}
+	if from := queryValues.Get("from"); from != "" {
+		t, _, err := parseTimeParam(from)
+		if err != nil {
+			return window, fmt.Errorf("invalid from: %v", err)
+		}
+		window.From = t
+	}
+	if !window.From.Before(window.To) {
+		return window, fmt.Errorf("from must be before to")
+	}
+	return window, nil
+}
+
+// parseTimeParam parses an RFC3339 time or a YYYY-MM-DD date, returning whether it is a date
+func parseTimeParam(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%q is neither an RFC3339 time nor a YYYY-MM-DD date", value)
+	}
+	return t, false, nil
 }
 
 // ServeEnvCharts writes the overall environment charts to a JSON HTTP response
@@ -99,37 +285,39 @@ func (m *DB) ServeEnvCharts(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("invalid number of top tests to use: %v", err), http.StatusUnprocessableEntity)
 		return
 	}
-	data, err := m.Database.GetEnvCharts(env, testsInTop)
+	window, err := windowParams(queryValues)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		return
 	}
-	if data == nil {
-		http.Error(w, "data not found", http.StatusNotImplemented)
-		return
-	}
-	jsonData, err := json.Marshal(data)
+	data, err := m.Database.GetEnvCharts(r.Context(), env, testsInTop, window)
 	if err != nil {
-		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	_, err = w.Write(jsonData)
-	if err != nil {
-		http.Error(w, "Failed to write JSON data", http.StatusInternalServerError)
+	if data == nil {
+		http.Error(w, "data not found", http.StatusNotImplemented)
 		return
 	}
+	writeJSON(w, data)
 }
 
 // ServeOverview writes the overview chart for all of the environments to a JSON HTTP response
+// date_range is the former name of days, kept for existing links
 func (m *DB) ServeOverview(w http.ResponseWriter, r *http.Request) {
-	dateRange, err := strconv.Atoi(r.URL.Query().Get("date_range"))
+	queryValues := r.URL.Query()
+	if queryValues.Get("days") == "" {
+		if dateRange, err := strconv.Atoi(queryValues.Get("date_range")); err == nil && dateRange > 0 {
+			queryValues.Set("days", queryValues.Get("date_range"))
+		}
+	}
+	window, err := windowParams(queryValues)
 	if err != nil {
-		dateRange = 15
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
 	}
 
-	data, err := m.Database.GetOverview(dateRange)
+	data, err := m.Database.GetOverview(r.Context(), window)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -138,25 +326,19 @@ func (m *DB) ServeOverview(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "data not found", http.StatusNotImplemented)
 		return
 	}
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	_, err = w.Write(jsonData)
-	if err != nil {
-		http.Error(w, "Failed to write JSON data", http.StatusInternalServerError)
-		return
-	}
+	writeJSON(w, data)
 }
 
 // ServeGopoghVersion writes the gopogh version to a json response
 func ServeGopoghVersion(w http.ResponseWriter, _ *http.Request) {
-	data := map[string]interface{}{
-		"version": report.Version(),
+	data := models.GopoghVersion{
+		Version: report.Version(),
 	}
+	writeJSON(w, data)
+}
+
+// writeJSON marshals data and writes it to a JSON HTTP response
+func writeJSON(w http.ResponseWriter, data interface{}) {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestWindowParams(t *testing.T) {
+	date := func(s string) time.Time {
+		d, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	tests := []struct {
+		name     string
+		query    string
+		wantFrom time.Time
+		// fromSlack is how far from may be from wantFrom
+		fromSlack time.Duration
+		wantTo    time.Time
+		wantDays  int
+		wantErr   string
+	}{
+		{
+			name:     "from and to times",
+			query:    "from=2026-01-01T00:00:00Z&to=2026-02-01T12:00:00Z&days=7",
+			wantFrom: date("2026-01-01T00:00:00Z"),
+			wantTo:   date("2026-02-01T12:00:00Z"),
+			wantDays: 7,
+		},
+		{
+			name:     "to date includes the whole day",
+			query:    "from=2026-01-01&to=2026-01-31",
+			wantFrom: date("2026-01-01T00:00:00Z"),
+			wantTo:   date("2026-02-01T00:00:00Z"),
+			wantDays: 15,
+		},
+		{
+			name:     "time zone",
+			query:    "from=2026-01-01T00:00:00%2B02:00&to=2026-01-02T00:00:00Z",
+			wantFrom: date("2025-12-31T22:00:00Z"),
+			wantTo:   date("2026-01-02T00:00:00Z"),
+			wantDays: 15,
+		},
+		{
+			name:     "from defaults to 90 days before to",
+			query:    "to=2026-04-30",
+			wantFrom: date("2026-01-31T00:00:00Z"),
+			// 90 days in the local time zone, an hour more or less across a daylight saving change
+			fromSlack: time.Hour,
+			wantTo:    date("2026-05-01T00:00:00Z"),
+			wantDays:  15,
+		},
+		{name: "days not a number", query: "days=a", wantErr: "days must be a positive integer"},
+		{name: "days not positive", query: "days=0", wantErr: "days must be a positive integer"},
+		{name: "invalid from", query: "from=yesterday", wantErr: `invalid from: "yesterday" is neither an RFC3339 time nor a YYYY-MM-DD date`},
+		{name: "invalid to", query: "to=2026-13-01", wantErr: `invalid to: "2026-13-01" is neither an RFC3339 time nor a YYYY-MM-DD date`},
+		{name: "from after to", query: "from=2026-02-01&to=2026-01-01", wantErr: "from must be before to"},
+		{name: "from at to", query: "from=2026-01-01T00:00:00Z&to=2026-01-01T00:00:00Z", wantErr: "from must be before to"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, err := windowParams(values)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Errorf("windowParams(%q) error = %v, want %q", tc.query, err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("windowParams(%q) error = %v", tc.query, err)
+			}
+			if d := w.From.Sub(tc.wantFrom); d < -tc.fromSlack || d > tc.fromSlack {
+				t.Errorf("windowParams(%q) from = %v, want %v", tc.query, w.From, tc.wantFrom)
+			}
+			if !w.To.Equal(tc.wantTo) {
+				t.Errorf("windowParams(%q) to = %v, want %v", tc.query, w.To, tc.wantTo)
+			}
+			if w.Days != tc.wantDays {
+				t.Errorf("windowParams(%q) days = %d, want %d", tc.query, w.Days, tc.wantDays)
+			}
+		})
+	}
+}
+
+func TestWindowParamsDefault(t *testing.T) {
+	before := time.Now()
+	w, err := windowParams(url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.To.Before(before) || w.To.After(time.Now()) {
+		t.Errorf("windowParams() to = %v, want now", w.To)
+	}
+	if want := w.To.AddDate(0, 0, -90); !w.From.Equal(want) {
+		t.Errorf("windowParams() from = %v, want %v", w.From, want)
+	}
+	if w.Days != 15 {
+		t.Errorf("windowParams() days = %d, want 15", w.Days)
+	}
+}
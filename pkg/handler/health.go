@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/db"
+	"github.com/medyagh/gopogh/pkg/models"
+	"github.com/medyagh/gopogh/pkg/report"
+)
+
+// defaultHealthTimeout is how long the health checks wait for the database by default
+const defaultHealthTimeout = 2 * time.Second
+
+// healthContext returns the context of the database calls of a health check, ending after HealthTimeout
+func (m *DB) healthContext(r *http.Request) (context.Context, context.CancelFunc) {
+	timeout := m.HealthTimeout
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// ServeHealthz writes whether the server is alive, which it is not when it cannot reach the database,
+// so that it is restarted with a new connection
+func (m *DB) ServeHealthz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := m.healthContext(r)
+	defer cancel()
+
+	health := models.Health{Status: "ok", Version: report.Version()}
+	if err := m.Database.Ping(ctx); err != nil {
+		health.Errors = append(health.Errors, fmt.Sprintf("failed to ping the database: %v", err))
+	}
+	writeHealth(w, health)
+}
+
+// ServeReadyz writes whether the server is ready to serve requests: the database can be reached and its tables are the version the server works with.
+// It also reports when the materialized views were last refreshed
+func (m *DB) ServeReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := m.healthContext(r)
+	defer cancel()
+
+	health := models.Health{Status: "ok", SupportedSchemaVersion: db.SchemaVersion, Version: report.Version()}
+	if err := m.Database.Ping(ctx); err != nil {
+		health.Errors = append(health.Errors, fmt.Sprintf("failed to ping the database: %v", err))
+		writeHealth(w, health)
+		return
+	}
+	version, err := m.Database.GetSchemaVersion(ctx)
+	switch {
+	case err != nil:
+		health.Errors = append(health.Errors, err.Error())
+	case version < db.SchemaVersion:
+		health.Errors = append(health.Errors, fmt.Sprintf("schema version %d of the database is older than %d, the tables are not initialized", version, db.SchemaVersion))
+	case version > db.SchemaVersion:
+		health.Errors = append(health.Errors, fmt.Sprintf("schema version %d of the database is newer than %d, the server is out of date", version, db.SchemaVersion))
+	}
+	health.SchemaVersion = version
+	health.LastViewRefresh, err = m.Database.GetLastViewRefresh(ctx)
+	if err != nil {
+		health.Warnings = append(health.Warnings, err.Error())
+	}
+	writeHealth(w, health)
+}
+
+// writeHealth writes the health to a JSON HTTP response, with 503 Service Unavailable if a check failed
+func writeHealth(w http.ResponseWriter, health models.Health) {
+	code := http.StatusOK
+	if len(health.Errors) > 0 {
+		health.Status = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(health)
+}
@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/analysis"
+	"github.com/medyagh/gopogh/pkg/models"
+	"github.com/medyagh/gopogh/pkg/parser"
+	"github.com/medyagh/gopogh/pkg/report"
+	"github.com/medyagh/gopogh/pkg/store"
+)
+
+// defaultMaxUploadSize is the largest decompressed request body accepted when ingesting a run if DB.MaxUploadSize is zero
+const defaultMaxUploadSize = 64 << 20
+
+// errUploadTooLarge is returned by the reads of an uploaded run past the maximum size
+var errUploadTooLarge = errors.New("request body too large")
+
+// ServeIngestRun parses an uploaded run and adds/updates it in the database.
+// The body is either raw go test2json output (optionally gzipped) or, with format=summary, a json summary produced by gopogh.
+// The report details are read from the name, pr, details, repo, branch and commit_time query parameters.
+func (m *DB) ServeIngestRun(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	detail := models.ReportDetail{
+		Name:     queryValues.Get("name"),
+		Details:  queryValues.Get("details"),
+		PR:       queryValues.Get("pr"),
+		RepoName: queryValues.Get("repo"),
+		Branch:   queryValues.Get("branch"),
+	}
+	if t := queryValues.Get("commit_time"); t != "" {
+		commitTime, err := time.Parse(time.RFC3339, t)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid commit_time: %v", err), http.StatusUnprocessableEntity)
+			return
+		}
+		detail.CommitTime = commitTime
+	}
+
+	maxSize := m.MaxUploadSize
+	if maxSize == 0 {
+		maxSize = defaultMaxUploadSize
+	}
+	body, err := requestBody(r, maxSize)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+
+	var c report.DisplayContent
+	hasLogs := false
+	switch format := queryValues.Get("format"); format {
+	case "", "events":
+		events, err := parser.Parse(body)
+		if body.exceeded {
+			http.Error(w, fmt.Sprintf("the decompressed run is larger than %d bytes", maxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to parse test2json events: %v", err), http.StatusBadRequest)
+			return
+		}
+		c, err = report.Generate(detail, parser.ProcessEvents(events))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to generate report: %v", err), http.StatusInternalServerError)
+			return
+		}
+		hasLogs = true
+	case "summary":
+		var ss report.Summary
+		if err := json.NewDecoder(body).Decode(&ss); err != nil {
+			if body.exceeded {
+				http.Error(w, fmt.Sprintf("the decompressed summary is larger than %d bytes", maxSize), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, fmt.Sprintf("failed to parse summary: %v", err), http.StatusBadRequest)
+			return
+		}
+		ss.Detail = mergeDetail(ss.Detail, detail)
+		testTime := time.Now()
+		if t := queryValues.Get("test_time"); t != "" {
+			testTime, err = time.Parse(time.RFC3339, t)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid test_time: %v", err), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		c = report.FromSummary(ss, testTime)
+	default:
+		http.Error(w, fmt.Sprintf("unknown format: %q", format), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if c.Detail.Name == "" {
+		http.Error(w, "missing environment name", http.StatusUnprocessableEntity)
+		return
+	}
+	if c.Detail.Details == "" {
+		http.Error(w, "missing commit id (details)", http.StatusUnprocessableEntity)
+		return
+	}
+	// the report of the run must not overwrite another file of the report store, such as the quarantine suggestions
+	if _, err := store.ReportKey(c.Detail.Name, c.Detail.Details); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// the tables are created and migrated once at startup, migrating them again would lock them for every upload
+	run, tests := c.DBRows()
+	if err := m.Database.Set(r.Context(), run, tests); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	countIngested(c)
+	m.Cache.Invalidate()
+	// the quarantine only annotates the stored run, so failing to read it does not fail the request
+	c.Quarantined, err = analysis.FindQuarantined(r.Context(), m.Database, c.Detail.Name, c.FailedTests(), time.Now())
+	if err != nil {
+		log.Printf("failed to read the quarantine of %s: %v", c.Detail.Name, err)
+	}
+	// summaries have no logs, so there is no report worth keeping for them.
+	// The run is already stored, so failing to keep its report does not fail the request, which the client would retry
+	if hasLogs && m.Reports != nil {
+		if err := m.storeReport(c); err != nil {
+			log.Printf("failed to store the report of %s on %s: %v", c.Detail.Details, c.Detail.Name, err)
+		}
+	}
+
+	// the runs of pull requests test unmerged changes, so their failures are not news
+	if m.Notifier != nil && m.Notifier.Len() > 0 && c.Detail.PR == "" {
+		go m.notifyRun(context.WithoutCancel(r.Context()), c)
+	}
+
+	jsonData, err := c.ShortSummary()
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(jsonData)
+}
+
+// notifyRun finds the events of a stored run and sends them to the webhooks
+func (m *DB) notifyRun(ctx context.Context, c report.DisplayContent) {
+	run, _ := c.DBRows()
+	events, err := analysis.RunEvents(ctx, m.Database, run, c.FailedTests(), m.Notifier.Params(), time.Now())
+	if err != nil {
+		log.Printf("failed to find the events of %s on %s: %v", run.CommitID, run.EnvName, err)
+		return
+	}
+	m.Notifier.Notify(events)
+}
+
+// uploadBody is a request body failing the reads past its maximum decompressed size
+type uploadBody struct {
+	r         io.Reader
+	closer    io.Closer
+	remaining int64
+	exceeded  bool
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, errUploadTooLarge
+	}
+	// reading one byte past the maximum tells a body of the maximum size from a larger one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		b.exceeded = true
+		return n + int(b.remaining), errUploadTooLarge
+	}
+	return n, err
+}
+
+func (b *uploadBody) Close() error {
+	if b.closer == nil {
+		return nil
+	}
+	return b.closer.Close()
+}
+
+// requestBody returns the request body of at most maxSize bytes, transparently decompressing it if it is gzipped
+func requestBody(r *http.Request, maxSize int64) (*uploadBody, error) {
+	br := bufio.NewReader(r.Body)
+	magic, _ := br.Peek(2)
+	if r.Header.Get("Content-Encoding") != "gzip" && !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return &uploadBody{r: br, remaining: maxSize}, nil
+	}
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	return &uploadBody{r: gz, closer: gz, remaining: maxSize}, nil
+}
+
+// mergeDetail overrides the details of a summary with the non-empty query parameter values
+func mergeDetail(base, override models.ReportDetail) models.ReportDetail {
+	if override.Name != "" {
+		base.Name = override.Name
+	}
+	if override.Details != "" {
+		base.Details = override.Details
+	}
+	if override.PR != "" {
+		base.PR = override.PR
+	}
+	if override.RepoName != "" {
+		base.RepoName = override.RepoName
+	}
+	if override.Branch != "" {
+		base.Branch = override.Branch
+	}
+	if !override.CommitTime.IsZero() {
+		base.CommitTime = override.CommitTime
+	}
+	return base
+}
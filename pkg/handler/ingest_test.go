@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/medyagh/gopogh/pkg/db"
+)
+
+// failingBlob is a report store failing every write
+type failingBlob struct{}
+
+func (failingBlob) Put(string, []byte) error { return errors.New("bucket unavailable") }
+
+func (failingBlob) Get(string) ([]byte, error) { return nil, errors.New("bucket unavailable") }
+
+func TestServeIngestRunReportFailure(t *testing.T) {
+	database, err := db.FromEnv(db.FlagValues{Backend: "sqlite", Host: "localhost", Path: filepath.Join(t.TempDir(), "gopogh.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Initialize(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	m := &DB{Database: database, Reports: failingBlob{}}
+
+	events := `{"Time":"2024-01-01T00:00:00Z","Action":"run","Test":"TestA"}
+{"Time":"2024-01-01T00:00:01Z","Action":"output","Test":"TestA","Output":"--- PASS: TestA (1.00s)\n"}
+{"Time":"2024-01-01T00:00:01Z","Action":"pass","Test":"TestA","Elapsed":1}
+`
+	w := httptest.NewRecorder()
+	m.ServeIngestRun(w, httptest.NewRequest(http.MethodPost, "/api/v1/runs?name=env&details=c1", strings.NewReader(events)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("ServeIngestRun() with a failing report store = %d %s, want %d as the run is stored", w.Code, w.Body.String(), http.StatusCreated)
+	}
+	envs, err := database.GetEnvs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envs == nil || len(envs.Envs) != 1 {
+		t.Errorf("GetEnvs() = %+v, want the ingested run", envs)
+	}
+}
+
+func TestServeIngestRunReservedEnv(t *testing.T) {
+	m := &DB{}
+	summary := `{"NumberOfPass":1,"PassedTests":["TestA"]}`
+	w := httptest.NewRecorder()
+	m.ServeIngestRun(w, httptest.NewRequest(http.MethodPost, "/api/v1/runs?format=summary&name=suggestions&details=latest", strings.NewReader(summary)))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("ServeIngestRun() of the suggestions environment = %d %s, want %d", w.Code, w.Body.String(), http.StatusUnprocessableEntity)
+	}
+}
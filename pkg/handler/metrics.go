@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/db"
+	"github.com/medyagh/gopogh/pkg/report"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ingestedRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gopogh_ingested_runs_total",
+		Help: "Number of runs ingested by environment.",
+	}, []string{"env"})
+	ingestedTests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gopogh_ingested_tests_total",
+		Help: "Number of test results ingested by environment and result.",
+	}, []string{"env", "result"})
+
+	envLatestFails = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gopogh_env_latest_fail_count",
+		Help: "Number of failed tests in the most recent run of the environment.",
+	}, []string{"env"})
+	envLatestTests = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gopogh_env_latest_test_count",
+		Help: "Number of tests in the most recent run of the environment.",
+	}, []string{"env"})
+	envLastRun = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gopogh_env_last_run_timestamp_seconds",
+		Help: "Unix time of the most recent run of the environment.",
+	}, []string{"env"})
+	envFlakyTests = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gopogh_env_flaky_tests",
+		Help: "Number of tests of the environment whose recent flake rate is at least the -metrics_flake_rate threshold.",
+	}, []string{"env"})
+)
+
+// countIngested adds an ingested run to the metrics
+func countIngested(c report.DisplayContent) {
+	run, tests := c.DBRows()
+	ingestedRuns.WithLabelValues(run.EnvName).Inc()
+	for _, t := range tests {
+		ingestedTests.WithLabelValues(run.EnvName, t.Result).Inc()
+	}
+}
+
+// RefreshMetrics updates the gauges of the environments from their most recent run,
+// and from the flake rates of their tests in the recent window of the default window ending at now
+func (m *DB) RefreshMetrics(ctx context.Context, flakeRate float32, now time.Time) error {
+	start := time.Now()
+
+	envs, err := m.Database.GetEnvHealth(ctx, flakeRate, db.DefaultWindow(now))
+	if err != nil {
+		return fmt.Errorf("failed to read the health of the environments: %v", err)
+	}
+
+	// environments that are gone are reset rather than reported with their last values
+	for _, g := range []*prometheus.GaugeVec{envLatestFails, envLatestTests, envLastRun, envFlakyTests} {
+		g.Reset()
+	}
+	for _, env := range envs {
+		envLatestFails.WithLabelValues(env.EnvName).Set(float64(env.LastNumberOfFail))
+		envLatestTests.WithLabelValues(env.EnvName).Set(float64(env.LastNumberOfTests))
+		envLastRun.WithLabelValues(env.EnvName).Set(float64(env.LastTestTime.Unix()))
+		envFlakyTests.WithLabelValues(env.EnvName).Set(float64(env.FlakyTests))
+	}
+	log.Printf("\nduration metric: took %f seconds to refresh the metrics of %d environments\n\n", time.Since(start).Seconds(), len(envs))
+	return nil
+}
@@ -0,0 +1,372 @@
+package handler
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+	"github.com/medyagh/gopogh/pkg/report"
+)
+
+// apiParam is a query parameter of an API endpoint
+type apiParam struct {
+	Name        string
+	Description string
+	// Type is the OpenAPI type of the parameter, string if empty
+	Type     string
+	Required bool
+	// InPath is whether the parameter is a {segment} of the path rather than a query parameter
+	InPath bool
+}
+
+// apiEndpoint describes an endpoint of the versioned JSON API
+type apiEndpoint struct {
+	Method  string
+	Path    string
+	Summary string
+	Params  []apiParam
+	// RequestBody is the content type of the request body, empty if the endpoint takes no body
+	RequestBody string
+	// Status is the status code of a successful response, 200 if zero
+	Status int
+	// Response is a value of the type of a successful json response, a oneOf of values if the type depends on the parameters, nil for non-json responses
+	Response interface{}
+	// Scope is required to call the endpoint, empty for endpoints that are public by default
+	Scope Scope
+}
+
+// oneOf is the Response of an endpoint responding with one of the types of the values
+type oneOf []interface{}
+
+var envParam = apiParam{Name: "env", Description: "environment name", Required: true}
+var testParam = apiParam{Name: "test", Description: "test name", Required: true}
+var branchParam = apiParam{Name: "branch", Description: "only the commits of this branch, all branches if empty"}
+
+// windowAPIParams are the parameters of the analysis window, see windowParams
+var windowAPIParams = []apiParam{
+	{Name: "from", Description: "RFC3339 time or YYYY-MM-DD date the window starts at, defaults to 90 days before to"},
+	{Name: "to", Description: "RFC3339 time or YYYY-MM-DD date (included) the window ends at, defaults to now"},
+	{Name: "days", Description: "number of days of the recent window, compared with the same number of days before it, defaults to 15", Type: "integer"},
+}
+
+// apiEndpoints are the /api/v1 endpoints documented in the OpenAPI document, new endpoints must be added here
+var apiEndpoints = []apiEndpoint{
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/v1/db",
+		Summary: "most recent rows in the window (the last 90 days by default) of the environment tests and test cases tables, or a page or export of one of them",
+		Params: append([]apiParam{
+			{Name: "table", Description: "environment_tests or test_cases to page through or export only that table, both tables if empty"},
+			{Name: "cursor", Description: "nextCursor of the previous page of the table"},
+			{Name: "limit", Description: "number of rows of each table, defaults to 100 and at most 1000 for json, every row for exports", Type: "integer"},
+			{Name: "format", Description: "json (default), or csv or ndjson to stream every matching row of the table in the window"},
+			{Name: "env", Description: "only the rows of this environment"},
+			{Name: "commit", Description: "only the rows of this commit id"},
+			{Name: "pr", Description: "only the rows of this pull request number"},
+			{Name: "result", Description: "only the test cases with this result, or the environment tests with such a test case"},
+			{Name: "test", Description: "only the test cases of this test, or the environment tests that ran it"},
+		}, windowAPIParams...),
+		Response: models.EnvironmentTestsAndTestCases{},
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/v1/env",
+		Summary: "overall charts of an environment",
+		Params: append([]apiParam{
+			envParam,
+			{Name: "tests_in_top", Description: "number of flakiest tests to chart", Type: "integer"},
+		}, windowAPIParams...),
+		Response: models.EnvCharts{},
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/v1/test",
+		Summary: "charts of an individual test on an environment, or the comparison of the test across the environments without env",
+		Params: append([]apiParam{
+			{Name: "env", Description: "environment name, compares every environment the test ran on in the recent window if empty"},
+			testParam,
+			branchParam,
+		}, windowAPIParams...),
+		Response: oneOf{models.TestCharts{}, models.TestAcrossEnvs{}},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/api/v1/envs",
+		Summary:  "every environment with its most recent run",
+		Response: models.EnvList{},
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/v1/tests",
+		Summary: "page of the names of the tests in the window matching a search, ignoring case",
+		Params: append([]apiParam{
+			{Name: "q", Description: "substring of the test names, all tests if empty"},
+			{Name: "regex", Description: "true to match q as a regular expression", Type: "boolean"},
+			{Name: "page", Description: "page number, defaults to 1", Type: "integer"},
+			{Name: "per_page", Description: "number of tests per page, defaults to 50, at most 500", Type: "integer"},
+		}, windowAPIParams...),
+		Response: models.TestList{},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/api/v1/tests/first_failure",
+		Summary:  "range of commits a test started failing in on an environment, with the confidence of the range",
+		Params:   append([]apiParam{envParam, testParam, branchParam}, windowAPIParams...),
+		Response: models.FirstFailure{},
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/v1/commits",
+		Summary: "last runs of an environment in the window in commit order, most recent first",
+		Params: append([]apiParam{
+			envParam,
+			branchParam,
+			{Name: "limit", Description: "number of runs, defaults to 100", Type: "integer"},
+		}, windowAPIParams...),
+		Response: models.CommitHistory{},
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/v1/prs/{pr}",
+		Summary: "runs of a pull request on every environment, with the flake rate outside of pull requests of their failed tests",
+		Params: append([]apiParam{
+			{Name: "pr", Description: "pull request number", Required: true, InPath: true},
+			{Name: "format", Description: "json (default), or markdown to post on the pull request"},
+		}, windowAPIParams...),
+		Response: models.PRResults{},
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/v1/quarantine",
+		Summary: "quarantined tests, whose failures do not fail a run, or the go test -run/-skip pattern of the quarantined tests",
+		Params: []apiParam{
+			{Name: "env", Description: "environment name, includes the tests quarantined on every environment, every quarantined test if empty"},
+			{Name: "expired", Description: "true to include the expired entries of the json list", Type: "boolean"},
+			{Name: "format", Description: "json (default), or regex for the pattern of the unexpired entries as text"},
+		},
+		Response: models.QuarantineList{},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/quarantine",
+		Summary:     "quarantine a test on an environment, or on every environment with an empty envName, replacing its previous entry",
+		RequestBody: "application/json",
+		Status:      http.StatusCreated,
+		Response:    models.DBQuarantine{},
+		Scope:       ScopeAdmin,
+	},
+	{
+		Method:  http.MethodDelete,
+		Path:    "/api/v1/quarantine",
+		Summary: "remove a test from the quarantine",
+		Params: []apiParam{
+			{Name: "env", Description: "environment name, empty for the quarantine on every environment"},
+			testParam,
+		},
+		Status: http.StatusNoContent,
+		Scope:  ScopeAdmin,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/v1/suggestions",
+		Summary: "latest periodic report of the flaky and failing tests suggested for quarantine or a fix, with the evidence from their runs outside of pull requests",
+		Params: []apiParam{
+			{Name: "date", Description: "YYYY-MM-DD date of a previous report, the latest report if empty"},
+			{Name: "format", Description: "json (default), or html"},
+		},
+		Response: models.SuggestionReport{},
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/v1/summary",
+		Summary: "overview charts of all the environments",
+		Params: append([]apiParam{
+			{Name: "date_range", Description: "former name of days, used if days is empty", Type: "integer"},
+		}, windowAPIParams...),
+		Response: models.Overview{},
+	},
+	{
+		Method:  http.MethodPost,
+		Path:    "/api/v1/runs",
+		Summary: "ingest a run from go test2json output (optionally gzipped) or a gopogh json summary",
+		Params: []apiParam{
+			{Name: "name", Description: "environment name, required unless given by the summary"},
+			{Name: "details", Description: "commit id, required unless given by the summary"},
+			{Name: "pr", Description: "pull request number"},
+			{Name: "repo", Description: "source repo"},
+			{Name: "format", Description: "events (default) or summary"},
+			{Name: "test_time", Description: "RFC3339 start time of the tests of a summary, defaults to now"},
+			{Name: "branch", Description: "branch the commit was tested on"},
+			{Name: "commit_time", Description: "RFC3339 time of the commit, orders the run in the commit history"},
+		},
+		RequestBody: "application/json",
+		Status:      http.StatusCreated,
+		Response:    report.Summary{},
+		Scope:       ScopeIngest,
+	},
+	{
+		Method:  http.MethodPost,
+		Path:    "/api/v1/cache/refresh",
+		Summary: "drop the cached responses of the dashboard endpoints, for example after the materialized views are refreshed",
+		Status:  http.StatusNoContent,
+		Scope:   ScopeAdmin,
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/api/v1/version",
+		Summary:  "version of gopogh-server",
+		Response: models.GopoghVersion{},
+	},
+}
+
+// ServeOpenAPI writes the OpenAPI document of the versioned JSON API
+func ServeOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, openAPIDocument(apiEndpoints))
+}
+
+// openAPIDocument generates the OpenAPI document of the endpoints, deriving the schemas from the response types
+func openAPIDocument(endpoints []apiEndpoint) map[string]interface{} {
+	g := schemaGenerator{schemas: map[string]interface{}{}}
+	paths := map[string]map[string]interface{}{}
+	for _, e := range endpoints {
+		params := []interface{}{}
+		for _, p := range e.Params {
+			typ := p.Type
+			if typ == "" {
+				typ = "string"
+			}
+			in := "query"
+			if p.InPath {
+				in = "path"
+			}
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          in,
+				"description": p.Description,
+				"required":    p.Required,
+				"schema":      map[string]interface{}{"type": typ},
+			})
+		}
+		status := e.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		response := map[string]interface{}{"description": http.StatusText(status)}
+		if e.Response != nil {
+			response["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": g.responseSchema(e.Response)},
+			}
+		}
+		op := map[string]interface{}{
+			"summary":    e.Summary,
+			"parameters": params,
+			"responses":  map[string]interface{}{strconv.Itoa(status): response},
+		}
+		if e.RequestBody != "" {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{e.RequestBody: map[string]interface{}{}},
+			}
+		}
+		if e.Scope != "" {
+			op["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{string(e.Scope)}}}
+		}
+		if paths[e.Path] == nil {
+			paths[e.Path] = map[string]interface{}{}
+		}
+		paths[e.Path][strings.ToLower(e.Method)] = op
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "gopogh-server",
+			"version": report.Version(),
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// schemaGenerator converts go types into OpenAPI schemas, collecting the structs as named components
+type schemaGenerator struct {
+	schemas map[string]interface{}
+}
+
+// responseSchema returns the OpenAPI schema of the Response of an endpoint
+func (g *schemaGenerator) responseSchema(response interface{}) map[string]interface{} {
+	alternatives, ok := response.(oneOf)
+	if !ok {
+		return g.schema(reflect.TypeOf(response))
+	}
+	schemas := []interface{}{}
+	for _, a := range alternatives {
+		schemas = append(schemas, g.schema(reflect.TypeOf(a)))
+	}
+	return map[string]interface{}{"oneOf": schemas}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema returns the OpenAPI schema of t following the encoding/json rules
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := g.schemas[name]; !ok {
+			// register the name before recursing so self referencing types terminate
+			g.schemas[name] = nil
+			properties := map[string]interface{}{}
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				if !f.IsExported() {
+					continue
+				}
+				key := f.Name
+				if tag, ok := f.Tag.Lookup("json"); ok {
+					tagName, _, _ := strings.Cut(tag, ",")
+					if tagName == "-" {
+						continue
+					}
+					if tagName != "" {
+						key = tagName
+					}
+				}
+				properties[key] = g.schema(f.Type)
+			}
+			g.schemas[name] = map[string]interface{}{"type": "object", "properties": properties}
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]interface{}{}
+	}
+}
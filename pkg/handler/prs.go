@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/analysis"
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// ServePRResults writes the runs of the pull request of the path on every environment to a JSON HTTP response,
+// or to a Markdown response with format=markdown to post on the pull request
+func (m *DB) ServePRResults(w http.ResponseWriter, r *http.Request) {
+	pr := r.PathValue("pr")
+	if pr == "" {
+		http.Error(w, "missing pull request number", http.StatusUnprocessableEntity)
+		return
+	}
+	queryValues := r.URL.Query()
+	format := queryValues.Get("format")
+	if format != "" && format != "json" && format != "markdown" {
+		http.Error(w, "format must be json or markdown", http.StatusUnprocessableEntity)
+		return
+	}
+	window, err := windowParams(queryValues)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	data, err := m.Database.GetPRResults(r.Context(), pr, window)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data == nil {
+		http.Error(w, "data not found", http.StatusNotImplemented)
+		return
+	}
+	if format != "markdown" {
+		writeJSON(w, data)
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	_, _ = fmt.Fprint(w, prMarkdown(data, baseURL(r)))
+}
+
+// baseURL returns the scheme and host the request was sent to, behind a proxy setting X-Forwarded-Proto
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// prFailureNote tells whether a failed test of a pull request is likely broken by it or already flaky outside of pull requests
+func prFailureNote(f models.DBPRFailedTest) string {
+	switch {
+	case f.BaseRuns == 0:
+		return "no recent runs outside of pull requests"
+	case f.FlakePercentage == 0:
+		return "passes outside of pull requests, likely broken by this PR"
+	case f.FlakePercentage == 100:
+		return "already failing outside of pull requests"
+	default:
+		return "already flaky outside of pull requests"
+	}
+}
+
+// markdownCell escapes the pipes of a Markdown table cell
+var markdownCell = strings.NewReplacer("|", `\|`, "\n", " ")
+
+// prMarkdown renders the results of a pull request as Markdown, linking to the dashboard and reports at base
+func prMarkdown(data *models.PRResults, base string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## gopogh results of PR #%s\n\n", markdownCell.Replace(data.PR))
+	if len(data.Runs) == 0 {
+		b.WriteString("No runs found.\n")
+		return b.String()
+	}
+	b.WriteString("| Environment | Commit | Passed | Failed | Skipped | Duration | Report |\n")
+	b.WriteString("|---|---|---:|---:|---:|---:|---|\n")
+	for _, run := range data.Runs {
+		report := fmt.Sprintf("%s/report?env=%s&commit=%s", base, url.QueryEscape(run.EnvName), url.QueryEscape(run.CommitID))
+		fmt.Fprintf(&b, "| [%s](%s/?env=%s) | `%s` | %d | %d | %d | %s | [report](%s) |\n",
+			markdownCell.Replace(run.EnvName), base, url.QueryEscape(run.EnvName), markdownCell.Replace(analysis.ShortCommit(run.CommitID)),
+			run.NumberOfPass, run.NumberOfFail, run.NumberOfSkip, time.Duration(run.TotalDuration*float64(time.Second)).Round(time.Second), report)
+	}
+	for _, run := range data.Runs {
+		if len(run.FailedTests) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### Failed tests on %s (`%s`)\n\n", markdownCell.Replace(run.EnvName), markdownCell.Replace(analysis.ShortCommit(run.CommitID)))
+		b.WriteString("| Test | Flake rate outside of PRs | |\n")
+		b.WriteString("|---|---:|---|\n")
+		for _, f := range run.FailedTests {
+			fmt.Fprintf(&b, "| [%s](%s/?env=%s&test=%s) | %.2f%% of %d runs | %s |\n",
+				markdownCell.Replace(f.TestName), base, url.QueryEscape(f.EnvName), url.QueryEscape(f.TestName),
+				f.FlakePercentage, f.BaseRuns, prFailureNote(f))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/analysis"
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// ServeQuarantine writes the quarantined tests of the env query parameter, or of every environment without env, to a JSON HTTP response
+// expired entries are left out unless expired=true, and format=regex writes the go test -run/-skip pattern of the entries instead
+func (m *DB) ServeQuarantine(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	env := queryValues.Get("env")
+	format := queryValues.Get("format")
+	if format != "" && format != "json" && format != "regex" {
+		http.Error(w, "format must be json or regex", http.StatusUnprocessableEntity)
+		return
+	}
+
+	entries, err := m.Database.GetQuarantine(r.Context(), env)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if queryValues.Get("expired") != "true" || format == "regex" {
+		entries = analysis.ActiveQuarantine(entries, time.Now())
+	}
+	if format == "regex" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		_, _ = fmt.Fprint(w, analysis.QuarantineRegex(entries))
+		return
+	}
+	if entries == nil {
+		entries = []models.DBQuarantine{}
+	}
+	writeJSON(w, models.QuarantineList{Env: env, Entries: entries})
+}
+
+// ServeAddQuarantine adds the test of the json body to the quarantine, replacing the entry of the test on its environment if there is one
+func (m *DB) ServeAddQuarantine(w http.ResponseWriter, r *http.Request) {
+	var q models.DBQuarantine
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse quarantine entry: %v", err), http.StatusBadRequest)
+		return
+	}
+	if q.TestName == "" {
+		http.Error(w, "missing test name", http.StatusUnprocessableEntity)
+		return
+	}
+	now := time.Now().UTC()
+	if q.Expiry != nil {
+		if !q.Expiry.After(now) {
+			http.Error(w, "expiry must be in the future", http.StatusUnprocessableEntity)
+			return
+		}
+		expiry := q.Expiry.UTC()
+		q.Expiry = &expiry
+	}
+	q.CreatedAt = now
+
+	if err := m.Database.SetQuarantine(r.Context(), q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonData, err := json.Marshal(q)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(jsonData)
+}
+
+// ServeDeleteQuarantine removes the test query parameter from the quarantine of the env query parameter, or from the quarantine on every environment without env
+func (m *DB) ServeDeleteQuarantine(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	test := queryValues.Get("test")
+	if test == "" {
+		http.Error(w, "missing test name", http.StatusUnprocessableEntity)
+		return
+	}
+	found, err := m.Database.DeleteQuarantine(r.Context(), queryValues.Get("env"), test)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "test is not quarantined", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/medyagh/gopogh/pkg/report"
+	"github.com/medyagh/gopogh/pkg/store"
+)
+
+// storeReport renders the HTML report and adds it to the report store
+func (m *DB) storeReport(c report.DisplayContent) error {
+	key, err := store.ReportKey(c.Detail.Name, c.Detail.Details)
+	if err != nil {
+		return err
+	}
+	html, err := c.HTML()
+	if err != nil {
+		return fmt.Errorf("failed to convert report to html: %v", err)
+	}
+	if err := m.Reports.Put(key, html); err != nil {
+		return fmt.Errorf("failed to store report: %v", err)
+	}
+	return nil
+}
+
+// ServeReport writes the stored HTML report of a commit on an environment to a HTTP response
+func (m *DB) ServeReport(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	env := queryValues.Get("env")
+	if env == "" {
+		http.Error(w, "missing environment name", http.StatusUnprocessableEntity)
+		return
+	}
+	commit := queryValues.Get("commit")
+	if commit == "" {
+		http.Error(w, "missing commit id", http.StatusUnprocessableEntity)
+		return
+	}
+	key, err := store.ReportKey(env, commit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	var html []byte
+	err = store.ErrNotFound
+	if m.Reports != nil {
+		html, err = m.Reports.Get(key)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		if m.ReportFallbackURL == "" {
+			http.Error(w, "report not found", http.StatusNotFound)
+			return
+		}
+		fallback := strings.NewReplacer("{env}", url.PathEscape(env), "{commit}", url.PathEscape(commit)).Replace(m.ReportFallbackURL)
+		http.Redirect(w, r, fallback, http.StatusFound)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to read report %s: %v", key, err)
+		http.Error(w, "failed to read report", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	_, _ = w.Write(html)
+}
@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+const (
+	// environmentTestsTable and testCasesTable are the values of the table parameter of the db endpoint
+	environmentTestsTable = "environment_tests"
+	testCasesTable        = "test_cases"
+	// maxRowsPerPage is the maximum number of rows of each table in a json response of the db endpoint
+	maxRowsPerPage = 1000
+	// flushRows is the number of rows streamed between flushes of a csv or ndjson export
+	flushRows = 500
+)
+
+// ServeEnvironmentTestsAndTestCases writes the most recent rows of both db tables matching the filters to a JSON HTTP response
+// with a table parameter it writes a page of that table continued by the cursor parameter,
+// or with format=csv or format=ndjson it streams every row of the table matching the filters.
+// Only the rows in the window are read, the last 90 days without the from and to parameters
+func (m *DB) ServeEnvironmentTestsAndTestCases(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	filter, err := rowFilterParams(queryValues)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	table := queryValues.Get("table")
+	if table != "" && table != environmentTestsTable && table != testCasesTable {
+		http.Error(w, fmt.Sprintf("table must be %s or %s", environmentTestsTable, testCasesTable), http.StatusUnprocessableEntity)
+		return
+	}
+	switch format := queryValues.Get("format"); format {
+	case "", "json":
+	case "csv", "ndjson":
+		if table == "" {
+			http.Error(w, fmt.Sprintf("format %s needs a table", format), http.StatusUnprocessableEntity)
+			return
+		}
+		m.streamRows(w, r, table, format, filter)
+		return
+	default:
+		http.Error(w, "format must be json, csv or ndjson", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if filter.Limit == 0 {
+		filter.Limit = 100
+	}
+	filter.Limit = min(filter.Limit, maxRowsPerPage)
+	if table == "" && filter.After != nil {
+		http.Error(w, "cursor needs a table", http.StatusUnprocessableEntity)
+		return
+	}
+	// one more row than the page tells whether there is a next page
+	if table != "" {
+		filter.Limit++
+	}
+	data := &models.EnvironmentTestsAndTestCases{EnvironmentTests: []models.DBEnvironmentTest{}, TestCases: []models.DBTestCase{}}
+	if table != testCasesTable {
+		err = m.Database.EachEnvironmentTest(r.Context(), filter, func(row models.DBEnvironmentTest) error {
+			data.EnvironmentTests = append(data.EnvironmentTests, row)
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if table != environmentTestsTable {
+		err = m.Database.EachTestCase(r.Context(), filter, func(row models.DBTestCase) error {
+			data.TestCases = append(data.TestCases, row)
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if table != "" {
+		pageSize := filter.Limit - 1
+		var last models.RowCursor
+		switch {
+		case len(data.EnvironmentTests) > pageSize:
+			data.EnvironmentTests = data.EnvironmentTests[:pageSize]
+			row := data.EnvironmentTests[pageSize-1]
+			last = models.RowCursor{TestTime: row.TestTime, EnvName: row.EnvName, CommitID: row.CommitID}
+		case len(data.TestCases) > pageSize:
+			data.TestCases = data.TestCases[:pageSize]
+			row := data.TestCases[pageSize-1]
+			last = models.RowCursor{TestTime: row.TestTime, EnvName: row.EnvName, CommitID: row.CommitID, TestName: row.TestName}
+		}
+		if !last.TestTime.IsZero() {
+			if data.NextCursor, err = encodeCursor(last); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	writeJSON(w, data)
+}
+
+// rowFilterParams parses the filters, limit and cursor query parameters of the db endpoint, the limit is 0 if not given
+func rowFilterParams(queryValues url.Values) (models.RowFilter, error) {
+	window, err := windowParams(queryValues)
+	if err != nil {
+		return models.RowFilter{}, err
+	}
+	limit, err := positiveIntParam(queryValues, "limit", 0)
+	if err != nil {
+		return models.RowFilter{}, err
+	}
+	filter := models.RowFilter{
+		Env:    queryValues.Get("env"),
+		Commit: queryValues.Get("commit"),
+		PR:     queryValues.Get("pr"),
+		Result: queryValues.Get("result"),
+		Test:   queryValues.Get("test"),
+		Window: window,
+		Limit:  limit,
+	}
+	if cursor := queryValues.Get("cursor"); cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			return filter, err
+		}
+		filter.After = &after
+	}
+	return filter, nil
+}
+
+// encodeCursor encodes the position of the last row of a page as the cursor of the next page
+func encodeCursor(c models.RowCursor) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeCursor decodes a cursor made by encodeCursor
+func decodeCursor(cursor string) (models.RowCursor, error) {
+	var c models.RowCursor
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor: %v", err)
+	}
+	return c, nil
+}
+
+// rowStream writes the rows of an export as csv records or json lines, flushing them to the client as they are read
+type rowStream struct {
+	w    http.ResponseWriter
+	buf  *bufio.Writer
+	csv  *csv.Writer // nil for ndjson
+	json *json.Encoder
+	rows int
+	// flushed is whether rows were sent to the client, after which errors can no longer be the response
+	flushed bool
+}
+
+// write writes a row, as the csv record or as json
+func (s *rowStream) write(record []string, row interface{}) error {
+	var err error
+	if s.csv != nil {
+		err = s.csv.Write(record)
+	} else {
+		err = s.json.Encode(row)
+	}
+	if err != nil {
+		return err
+	}
+	s.rows++
+	if s.rows%flushRows == 0 {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush sends the buffered rows to the client
+func (s *rowStream) flush() error {
+	s.flushed = true
+	if s.csv != nil {
+		s.csv.Flush()
+		if err := s.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// streamRows streams the rows of the table matching the filter as csv or ndjson
+// errors before the first flush are written as the response, later errors can only end the stream early
+func (m *DB) streamRows(w http.ResponseWriter, r *http.Request, table string, format string, filter models.RowFilter) {
+	s := &rowStream{w: w, buf: bufio.NewWriter(w)}
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", table+".csv"))
+		s.csv = csv.NewWriter(s.buf)
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		s.json = json.NewEncoder(s.buf)
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var err error
+	if table == environmentTestsTable {
+		if s.csv != nil {
+			err = s.csv.Write([]string{"CommitID", "EnvName", "GopoghTime", "TestTime", "NumberOfFail", "NumberOfPass", "NumberOfSkip", "TotalDuration", "GopoghVersion", "Branch", "CommitTime"})
+		}
+		if err == nil {
+			err = m.Database.EachEnvironmentTest(r.Context(), filter, func(row models.DBEnvironmentTest) error {
+				commitTime := ""
+				if row.CommitTime != nil {
+					commitTime = row.CommitTime.Format(time.RFC3339Nano)
+				}
+				return s.write([]string{
+					row.CommitID, row.EnvName, row.GopoghTime.Format(time.RFC3339Nano), row.TestTime.Format(time.RFC3339Nano),
+					strconv.Itoa(row.NumberOfFail), strconv.Itoa(row.NumberOfPass), strconv.Itoa(row.NumberOfSkip),
+					strconv.FormatFloat(row.TotalDuration, 'f', -1, 64), row.GopoghVersion, row.Branch, commitTime,
+				}, row)
+			})
+		}
+	} else {
+		if s.csv != nil {
+			err = s.csv.Write([]string{"PR", "CommitID", "EnvName", "TestName", "Result", "TestTime", "Duration", "TestOrder", "Signature"})
+		}
+		if err == nil {
+			err = m.Database.EachTestCase(r.Context(), filter, func(row models.DBTestCase) error {
+				return s.write([]string{
+					row.PR, row.CommitID, row.EnvName, row.TestName, row.Result, row.TestTime.Format(time.RFC3339Nano),
+					strconv.FormatFloat(row.Duration, 'f', -1, 64), strconv.Itoa(row.TestOrder), row.Signature,
+				}, row)
+			})
+		}
+	}
+	if err != nil {
+		if !s.flushed {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("failed to stream %s after %d rows: %v", table, s.rows, err)
+		return
+	}
+	if err := s.flush(); err != nil {
+		log.Printf("failed to stream %s: %v", table, err)
+	}
+}
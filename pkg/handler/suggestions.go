@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/analysis"
+	"github.com/medyagh/gopogh/pkg/models"
+	"github.com/medyagh/gopogh/pkg/store"
+)
+
+//go:embed suggestions.html
+var suggestionsHTML string
+
+var suggestionsTemplate = template.Must(template.New("suggestions").Parse(suggestionsHTML))
+
+// suggestionsKey returns the key of the suggestion report of the date (YYYY-MM-DD), or of the latest report if the date is empty, in the report store
+func suggestionsKey(date string, ext string) string {
+	if date == "" {
+		date = "latest"
+	}
+	return store.SuggestionsDir + "/" + date + "." + ext
+}
+
+// GenerateSuggestions finds the quarantine suggestions of the last days before now and keeps them for ServeSuggestions,
+// the report is also added to the report store as json and html, as the latest report and as the report of the day
+func (m *DB) GenerateSuggestions(ctx context.Context, days int, p analysis.SuggestionParams, now time.Time) error {
+	start := time.Now()
+
+	w := models.Window{From: now.AddDate(0, 0, -days), To: now, Days: days}
+	data, err := analysis.FindSuggestions(ctx, m.Database, w, p, now)
+	if err != nil {
+		return fmt.Errorf("failed to find quarantine suggestions: %v", err)
+	}
+	m.suggestionsMu.Lock()
+	m.suggestions = data
+	m.suggestionsMu.Unlock()
+	if m.Reports != nil {
+		jsonData, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("failed to marshal quarantine suggestions: %v", err)
+		}
+		var html bytes.Buffer
+		if err := suggestionsTemplate.Execute(&html, data); err != nil {
+			return fmt.Errorf("failed to convert quarantine suggestions to html: %v", err)
+		}
+		for _, date := range []string{"", now.UTC().Format("2006-01-02")} {
+			if err := m.Reports.Put(suggestionsKey(date, "json"), jsonData); err != nil {
+				return fmt.Errorf("failed to store quarantine suggestions: %v", err)
+			}
+			if err := m.Reports.Put(suggestionsKey(date, "html"), html.Bytes()); err != nil {
+				return fmt.Errorf("failed to store quarantine suggestions: %v", err)
+			}
+		}
+	}
+	log.Printf("\nduration metric: took %f seconds to generate %d quarantine suggestions\n\n", time.Since(start).Seconds(), len(data.Suggestions))
+	return nil
+}
+
+// SuggestionsDue returns how long until the next suggestion report is due, zero if there is no report younger than the interval
+func (m *DB) SuggestionsDue(interval time.Duration, now time.Time) time.Duration {
+	data, err := m.storedSuggestions("")
+	if err != nil {
+		return 0
+	}
+	return max(data.GeneratedAt.Add(interval).Sub(now), 0)
+}
+
+// storedSuggestions returns the suggestion report of the date, or the latest report if the date is empty
+func (m *DB) storedSuggestions(date string) (*models.SuggestionReport, error) {
+	if date == "" {
+		m.suggestionsMu.Lock()
+		data := m.suggestions
+		m.suggestionsMu.Unlock()
+		if data != nil {
+			return data, nil
+		}
+	}
+	if m.Reports == nil {
+		return nil, store.ErrNotFound
+	}
+	jsonData, err := m.Reports.Get(suggestionsKey(date, "json"))
+	if err != nil {
+		return nil, err
+	}
+	var data models.SuggestionReport
+	if err := json.Unmarshal(jsonData, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse quarantine suggestions: %v", err)
+	}
+	return &data, nil
+}
+
+// ServeSuggestions writes the latest quarantine suggestions, or the ones of the date query parameter (YYYY-MM-DD), to a JSON HTTP response,
+// or to an HTML page with format=html
+func (m *DB) ServeSuggestions(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	format := queryValues.Get("format")
+	if format != "" && format != "json" && format != "html" {
+		http.Error(w, "format must be json or html", http.StatusUnprocessableEntity)
+		return
+	}
+	date := queryValues.Get("date")
+	if date != "" {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			http.Error(w, "date must be YYYY-MM-DD", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	data, err := m.storedSuggestions(date)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "no quarantine suggestions generated", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if format != "html" {
+		writeJSON(w, data)
+		return
+	}
+	var html bytes.Buffer
+	if err := suggestionsTemplate.Execute(&html, data); err != nil {
+		http.Error(w, fmt.Sprintf("failed to convert quarantine suggestions to html: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	_, _ = w.Write(html.Bytes())
+}
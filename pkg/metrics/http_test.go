@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrument(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/env", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "invalid environment", http.StatusUnprocessableEntity)
+	})
+	mux.HandleFunc("/metrics", Handler)
+	h := Instrument(mux)
+
+	tests := []struct {
+		path    string
+		handler string
+		code    string
+	}{
+		{path: "/env?env=x", handler: "/env", code: "422"},
+		{path: "/missing", handler: "unmatched", code: "404"},
+	}
+	for _, tc := range tests {
+		before := testutil.ToFloat64(httpRequests.WithLabelValues(tc.handler, http.MethodGet, tc.code))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if got := testutil.ToFloat64(httpRequests.WithLabelValues(tc.handler, http.MethodGet, tc.code)) - before; got != 1 {
+			t.Errorf("GET %s counted %v times as %s %s, want once", tc.path, got, tc.handler, tc.code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`gopogh_http_requests_total{code="422",handler="/env",method="GET"} 1`,
+		`gopogh_http_request_duration_seconds_bucket{handler="/env",le="30"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics does not have %q", want)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+// Package metrics serves the Prometheus metrics of gopogh-server, the packages register their metrics with the default registry of client_golang
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefBuckets are the upper bounds in seconds of the latency histograms, the default buckets of Prometheus up to 30s for the slowest queries
+var DefBuckets = append(append([]float64(nil), prometheus.DefBuckets...), 30)
+
+// promHandler serves the metrics of the default registry, with the Go runtime and process metrics
+var promHandler = promhttp.Handler()
+
+// Handler writes every registered metric in the Prometheus exposition format
+func Handler(w http.ResponseWriter, r *http.Request) {
+	promHandler.ServeHTTP(w, r)
+}
@@ -1,14 +1,20 @@
 // Package models defines the data models used by the gopogh project
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 // ReportDetail holds the report details such as test name, PR number...
 type ReportDetail struct {
-	Name     string
-	Details  string
-	PR       string // pull request number
-	RepoName string // for example github repo
+	Name       string
+	Details    string
+	PR         string    // pull request number
+	RepoName   string    // for example github repo
+	Branch     string    `json:",omitempty"` // branch the commit was tested on
+	CommitTime time.Time `json:",omitzero"`  // time of the commit, used to order the history by commit
 }
 
 // TestEvent represents a single event in a test execution
@@ -35,6 +41,38 @@ type TestGroup struct {
 	Events    []TestEvent
 }
 
+// Window bounds the test times a query analyzes
+type Window struct {
+	From time.Time
+	To   time.Time
+	// Days is the number of days of the recent window, compared with the same number of days before it
+	Days int
+}
+
+// RowFilter selects the rows of the db tables, empty fields match every row
+// environment tests match the PR, Result and Test filters if one of their test cases does
+type RowFilter struct {
+	Env     string
+	Commit  string
+	Commits []string // matches any of the commits
+	PR      string
+	NoPR    bool // only the rows outside of pull requests
+	Result  string
+	Test    string
+	Tests   []string // matches any of the tests
+	Window  Window
+	Limit   int        // 0 for every row
+	After   *RowCursor // nil to start from the most recent row
+}
+
+// RowCursor is the position of a row in the most recent first order of the db tables
+type RowCursor struct {
+	TestTime time.Time
+	EnvName  string
+	CommitID string
+	TestName string // empty for environment tests
+}
+
 // DBTestCase represents a row in db table that holds each individual subtest
 type DBTestCase struct {
 	PR        string
@@ -45,6 +83,7 @@ type DBTestCase struct {
 	Duration  float64
 	EnvName   string
 	TestOrder int
+	Signature string // line of the log explaining the failure with its numbers masked, empty unless the test failed with logs
 }
 
 // DBEnvironmentTest represents a row in db table that has finished tests in each environment
@@ -58,6 +97,86 @@ type DBEnvironmentTest struct {
 	NumberOfSkip  int
 	TotalDuration float64
 	GopoghVersion string
+	Branch        string
+	CommitTime    *time.Time // nil if the commit time was not given
+}
+
+// DBCommit represents a row in db table that holds the commits imported from git log
+type DBCommit struct {
+	CommitID   string
+	ParentID   string // first parent of the commit
+	Branch     string
+	CommitTime time.Time
+}
+
+// DBQuarantine represents a row in db table that holds the quarantined tests, whose failures are known and do not fail a run
+type DBQuarantine struct {
+	EnvName   string     `json:"envName"` // empty to quarantine the test on every environment
+	TestName  string     `json:"testName"`
+	Owner     string     `json:"owner"`
+	Reason    string     `json:"reason"`
+	IssueURL  string     `json:"issueUrl"`
+	Expiry    *time.Time `json:"expiry"` // nil if the quarantine never expires
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// SignatureCount is the number of failures of a test with a failure signature
+type SignatureCount struct {
+	Signature string `json:"signature"`
+	Count     int    `json:"count"`
+}
+
+// QuarantineSuggestion is a test of an environment proposed for quarantine or for a fix, with the evidence from its runs outside of pull requests
+type QuarantineSuggestion struct {
+	Kind           string           `json:"kind"` // flaky when its result flips often, failing when its last runs all failed
+	EnvName        string           `json:"envName"`
+	TestName       string           `json:"testName"`
+	Runs           int              `json:"runs"`           // number of non skipped runs in the window
+	Failures       int              `json:"failures"`       // number of failed runs in the window
+	FlipRate       float32          `json:"flipRate"`       // percentage of the consecutive runs with different results
+	LastFailure    time.Time        `json:"lastFailure"`    // time of the most recent failure
+	FailingCommits []string         `json:"failingCommits"` // most recent commits the test failed on, at most 10
+	FailingEnvs    []string         `json:"failingEnvs"`    // environments the test failed on in the window
+	Signatures     []SignatureCount `json:"signatures"`     // most frequent failure signatures, at most 3
+	Quarantined    bool             `json:"quarantined"`    // whether the test is already quarantined on the environment
+}
+
+// SuggestionReport is the periodic report of the quarantine suggestions
+type SuggestionReport struct {
+	GeneratedAt   time.Time              `json:"generatedAt"`
+	From          time.Time              `json:"from"`
+	To            time.Time              `json:"to"`
+	MinFlipRate   float32                `json:"minFlipRate"`   // percentage of flips above which a test is flaky
+	MinRuns       int                    `json:"minRuns"`       // number of runs a test needs to be suggested
+	FailingStreak int                    `json:"failingStreak"` // number of last runs that must have failed for a test to be failing
+	Suggestions   []QuarantineSuggestion `json:"suggestions"`
+}
+
+// RunEvent is a notable change in the results of an environment found when a run is ingested
+type RunEvent struct {
+	Kind     string    `json:"kind"` // flake_rate, new_failure or fail_jump
+	EnvName  string    `json:"envName"`
+	TestName string    `json:"testName,omitempty"` // empty for the events of the whole run
+	CommitID string    `json:"commitId"`
+	Message  string    `json:"message"`
+	Value    float64   `json:"value"`    // flake percentage or number of failures including the run
+	Baseline float64   `json:"baseline"` // flake percentage or median number of failures before the run
+	Time     time.Time `json:"time"`
+}
+
+// RunChange is an ingested run with the changes of its results since the previous run of its environment
+type RunChange struct {
+	Run         DBEnvironmentTest
+	PR          string             // empty for the runs outside of pull requests
+	Previous    *DBEnvironmentTest // nil for the first run of the environment in the window
+	NewFailures []string           // failed tests that did not fail in the previous run
+	Fixed       []string           // tests that failed in the previous run and passed in this one
+}
+
+// QuarantineList is the quarantine of an environment, or of every environment if Env is empty
+type QuarantineList struct {
+	Env     string         `json:"env"`
+	Entries []DBQuarantine `json:"entries"`
 }
 
 // DBFlakeRow represents a row in the basic flake rate table
@@ -67,31 +186,60 @@ type DBFlakeRow struct {
 	GrowthRate            float32 `json:"growthRate"`
 	FailedTestNum         float32 `json:"failedTestNum"`
 	TotalTestNum          float32 `json:"totalTestNum"`
+	FlipRate              float32 `json:"flipRate"`
+	ConsistentFailureRate float32 `json:"consistentFailureRate"`
+}
+
+// CommitResult is the result of a test, or the test count of an environment, on a commit
+type CommitResult struct {
+	Commit    string    `json:"commit"`
+	Result    string    `json:"result,omitempty"`
+	Duration  float64   `json:"duration"`
+	PR        string    `json:"pr,omitempty"`
+	Time      time.Time `json:"time"`
+	TestCount int       `json:"testCount,omitempty"`
+}
+
+// CommitResults is a list of commit results aggregated by the database into a json array
+type CommitResults []CommitResult
+
+// Scan implements sql.Scanner for a json array column
+func (c *CommitResults) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	default:
+		return fmt.Errorf("cannot scan %T into CommitResults", src)
+	}
 }
 
 // DBFlakeBy represents a "row" in the flake rate by _ of top 10 of recent test flakiness charts
 type DBFlakeBy struct {
-	TestName        string    `json:"testName"`
-	StartOfDate     time.Time `json:"startOfDate"`
-	FlakePercentage float32   `json:"flakePercentage"`
-	CommitResults   string    `json:"commitResults"`
+	TestName        string        `json:"testName"`
+	StartOfDate     time.Time     `json:"startOfDate"`
+	FlakePercentage float32       `json:"flakePercentage"`
+	CommitResults   CommitResults `json:"commitResults"`
 }
 
 // DBEnvDuration represents a "row" in the test count and total duration by day chart
 type DBEnvDuration struct {
-	StartOfDate     time.Time `json:"startOfDate"`
-	TestCount       float32   `json:"testCount"`
-	Duration        float32   `json:"duration"`
-	CommitCounts    string    `json:"commitCounts"`
-	CommitDurations string    `json:"commitDurations"`
+	StartOfDate time.Time     `json:"startOfDate"`
+	TestCount   float32       `json:"testCount"`
+	Duration    float32       `json:"duration"`
+	Commits     CommitResults `json:"commits"`
 }
 
 // DBTestRateAndDuration represents a "row" in the flake rate and duration chart for a given test
 type DBTestRateAndDuration struct {
-	StartOfDate               time.Time `json:"startOfDate"`
-	AvgDuration               float32   `json:"avgDuration"`
-	FlakePercentage           float32   `json:"flakePercentage"`
-	CommitResultsAndDurations string    `json:"commitResultsAndDurations"`
+	StartOfDate               time.Time     `json:"startOfDate"`
+	AvgDuration               float32       `json:"avgDuration"`
+	FlakePercentage           float32       `json:"flakePercentage"`
+	CommitResultsAndDurations CommitResults `json:"commitResultsAndDurations"`
 }
 
 // DBSummaryAvgFail represents a "row" in most flakey environments summary chart
@@ -104,10 +252,216 @@ type DBSummaryAvgFail struct {
 
 // DBSummaryTable represents a row in the summary number of fail table
 type DBSummaryTable struct {
-	EnvName              string  `json:"envName"`
-	RecentNumberOfFail   float32 `json:"recentNumberOfFail"`
-	Growth               float32 `json:"growth"`
-	TestDuration         float32 `json:"testDuration"`
-	PreviousTestDuration float32 `json:"previousTestDuration"`
-	TestDurationGrowth   float32 `json:"testDurationGrowth"`
+	EnvName               string  `json:"envName"`
+	RecentNumberOfFail    float32 `json:"recentNumberOfFail"`
+	Growth                float32 `json:"growth"`
+	TestDuration          float32 `json:"testDuration"`
+	PreviousTestDuration  float32 `json:"previousTestDuration"`
+	TestDurationGrowth    float32 `json:"testDurationGrowth"`
+	FlipRate              float32 `json:"flipRate"`
+	ConsistentFailureRate float32 `json:"consistentFailureRate"`
+}
+
+// DBEnvFlakiness represents a row of the flip rate and consistent failure rate of the tests of an environment
+type DBEnvFlakiness struct {
+	EnvName               string  `json:"envName"`
+	FlipRate              float32 `json:"flipRate"`
+	ConsistentFailureRate float32 `json:"consistentFailureRate"`
+}
+
+// DurationStats represents a row of the duration statistics of the passing runs of a test, in a baseline window and the recent window after it
+type DurationStats struct {
+	EnvName        string
+	TestName       string
+	BaselineMedian float64
+	BaselineMAD    float64 // median absolute deviation from the baseline median
+	BaselineRuns   int
+	RecentMedian   float64
+	RecentRuns     int
+}
+
+// DurationRegression is a test whose recent duration grew significantly compared to its baseline
+type DurationRegression struct {
+	EnvName        string  `json:"envName"`
+	TestName       string  `json:"testName"`
+	BaselineMedian float64 `json:"baselineMedian"`
+	RecentMedian   float64 `json:"recentMedian"`
+	Growth         float64 `json:"growth"` // percentage
+	Score          float64 `json:"score"`  // number of scaled median absolute deviations the recent median is above the baseline median
+	BaselineRuns   int     `json:"baselineRuns"`
+	RecentRuns     int     `json:"recentRuns"`
+}
+
+// FlakeHistory is the recent history of a failed test on its environment, leaving out the reported commit
+type FlakeHistory struct {
+	Days             int     `json:"days"`             // number of days of the flake percentage
+	Runs             int     `json:"runs"`             // number of non skipped runs in the days
+	FlakePercentage  float32 `json:"flakePercentage"`  // percentage of the runs that failed
+	LookbackDays     int     `json:"lookbackDays"`     // number of days previous failures are looked up in
+	LookbackFailures int     `json:"lookbackFailures"` // number of failures in the lookback days
+	Note             string  `json:"note"`             // the history in words, for example "failed 23% of last 15 days (7 of 30 runs)"
+}
+
+// DBTestEnvSummary represents a row of the recent results of a test on an environment
+type DBTestEnvSummary struct {
+	EnvName               string        `json:"envName"`
+	FlakePercentage       float32       `json:"flakePercentage"`
+	FlipRate              float32       `json:"flipRate"`
+	ConsistentFailureRate float32       `json:"consistentFailureRate"`
+	FailedTestNum         int           `json:"failedTestNum"`
+	TotalTestNum          int           `json:"totalTestNum"`
+	AvgDuration           float32       `json:"avgDuration"`
+	RecentResults         CommitResults `json:"recentResults"` // oldest first
+}
+
+// DBEnvInfo represents a row of the environments with their most recent run
+type DBEnvInfo struct {
+	EnvName           string    `json:"envName"`
+	Runs              int       `json:"runs"`
+	FirstTestTime     time.Time `json:"firstTestTime"`
+	LastTestTime      time.Time `json:"lastTestTime"`
+	LastCommitID      string    `json:"lastCommitId"`
+	LastNumberOfTests int       `json:"lastNumberOfTests"`
+	LastNumberOfFail  int       `json:"lastNumberOfFail"`
+}
+
+// DBEnvHealth represents a row of the most recent run of each environment with the number of its flaky tests, see Datab.GetEnvHealth
+type DBEnvHealth struct {
+	EnvName           string
+	LastTestTime      time.Time
+	LastNumberOfTests int
+	LastNumberOfFail  int
+	FlakyTests        int
+}
+
+// DBTestInfo represents a row of the test names found by a search
+type DBTestInfo struct {
+	TestName     string    `json:"testName"`
+	EnvCount     int       `json:"envCount"` // number of environments the test ran on
+	LastTestTime time.Time `json:"lastTestTime"`
+}
+
+// DBPRFailedTest represents a row of a failed test of a pull request run,
+// with the flake rate of the test in the recent runs of the environment that are not of a pull request
+type DBPRFailedTest struct {
+	EnvName         string  `json:"envName"`
+	CommitID        string  `json:"commitId"`
+	TestName        string  `json:"testName"`
+	FlakePercentage float32 `json:"flakePercentage"`
+	BaseRuns        int     `json:"baseRuns"` // number of runs the flake percentage is computed from, 0 if the test did not recently run outside of pull requests
+}
+
+// EnvironmentTestsAndTestCases is the response with the most recent rows of both db tables, or a page of the rows of one of them
+type EnvironmentTestsAndTestCases struct {
+	EnvironmentTests []DBEnvironmentTest `json:"environmentTests"`
+	TestCases        []DBTestCase        `json:"testCases"`
+	// NextCursor continues a page of one table, empty on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// EnvCharts is the response with the overall charts of an environment
+type EnvCharts struct {
+	RecentFlakePercentTable []DBFlakeRow    `json:"recentFlakePercentTable"`
+	FlakeRateByWeek         []DBFlakeBy     `json:"flakeRateByWeek"`
+	FlakeRateByDay          []DBFlakeBy     `json:"flakeRateByDay"`
+	CountsAndDurations      []DBEnvDuration `json:"countsAndDurations"`
+	// SlowestGrowing are the tests whose duration regressed the most, see analysis.DurationRegression
+	SlowestGrowing []DurationRegression `json:"slowestGrowingTable"`
+}
+
+// TestCharts is the response with the charts of an individual test on an environment
+type TestCharts struct {
+	FlakeByDay   []DBTestRateAndDuration `json:"flakeByDay"`
+	FlakeByWeek  []DBTestRateAndDuration `json:"flakeByWeek"`
+	FlakeByMonth []DBTestRateAndDuration `json:"flakeByMonth"`
+	// ByCommit is the result of every run in commit order, where the time is the commit time (or the test time if unknown)
+	ByCommit CommitResults `json:"byCommit"`
+}
+
+// TestAcrossEnvs is the response with the recent results of a test on every environment it ran on, flakiest environment first
+type TestAcrossEnvs struct {
+	TestName string             `json:"testName"`
+	Envs     []DBTestEnvSummary `json:"envs"`
+}
+
+// Overview is the response with the summary charts of all the environments
+type Overview struct {
+	SummaryAvgFail []DBSummaryAvgFail `json:"summaryAvgFail"`
+	SummaryTable   []DBSummaryTable   `json:"summaryTable"`
+	// SlowestGrowing are the tests of all the environments whose duration regressed the most
+	SlowestGrowing []DurationRegression `json:"slowestGrowingTable"`
+}
+
+// CommitHistory is the response with the runs of an environment in commit order, most recent first
+type CommitHistory struct {
+	EnvName string              `json:"envName"`
+	Branch  string              `json:"branch"`
+	Commits []DBEnvironmentTest `json:"commits"`
+}
+
+// FirstFailure is the range of commits a test started failing in, in the commit order of an environment
+type FirstFailure struct {
+	EnvName  string `json:"envName"`
+	TestName string `json:"testName"`
+	Branch   string `json:"branch"`
+	// TestedCommits is the number of commits the test passed or failed on in the window, 0 if the environment or test is unknown
+	TestedCommits int `json:"testedCommits"`
+	// Failing is whether the test failed on the most recent commit it ran on, the fields below are empty if not
+	Failing        bool   `json:"failing"`
+	FirstBadCommit string `json:"firstBadCommit,omitempty"`
+	LastGoodCommit string `json:"lastGoodCommit,omitempty"` // empty if the test failed on every commit it ran on
+	// Candidates are the commits that may have broken the test, oldest first: the first bad commit and the commits after the last good commit the test did not run on
+	Candidates        []string `json:"candidates"`
+	UntestedCommits   int      `json:"untestedCommits"`
+	FailingCommits    int      `json:"failingCommits"`
+	StablePassCommits int      `json:"stablePassCommits"`
+	Confidence        float32  `json:"confidence"` // percentage
+}
+
+// PRRun is a run of a pull request on an environment with its failed tests
+type PRRun struct {
+	EnvName       string           `json:"envName"`
+	CommitID      string           `json:"commitId"`
+	TestTime      time.Time        `json:"testTime"`
+	NumberOfPass  int              `json:"numberOfPass"`
+	NumberOfFail  int              `json:"numberOfFail"`
+	NumberOfSkip  int              `json:"numberOfSkip"`
+	TotalDuration float64          `json:"totalDuration"`
+	FailedTests   []DBPRFailedTest `json:"failedTests"`
+}
+
+// PRResults is the response with the runs of a pull request on every environment, ordered by environment and most recent first
+type PRResults struct {
+	PR   string  `json:"pr"`
+	Runs []PRRun `json:"runs"`
+}
+
+// EnvList is the response with every environment, ordered by name
+type EnvList struct {
+	Envs []DBEnvInfo `json:"envs"`
+}
+
+// TestList is the response with a page of the test names matching a search, ordered by name
+type TestList struct {
+	Tests   []DBTestInfo `json:"tests"`
+	Total   int          `json:"total"` // number of matching tests on all the pages
+	Page    int          `json:"page"`
+	PerPage int          `json:"perPage"`
+}
+
+// Health is the response of the liveness and readiness checks of gopogh-server
+type Health struct {
+	Status string   `json:"status"`           // ok, or unavailable with the failed checks in Errors
+	Errors []string `json:"errors,omitempty"` // failed checks
+	// Warnings are the checks that could not be made, which do not make the server unavailable
+	Warnings               []string   `json:"warnings,omitempty"`
+	SchemaVersion          int        `json:"schemaVersion,omitempty"`          // version of the tables in the database
+	SupportedSchemaVersion int        `json:"supportedSchemaVersion,omitempty"` // version of the tables the server works with
+	LastViewRefresh        *time.Time `json:"lastViewRefresh,omitempty"`        // when the materialized views were last refreshed
+	Version                string     `json:"version"`
+}
+
+// GopoghVersion is the response with the version of gopogh-server
+type GopoghVersion struct {
+	Version string `json:"version"`
 }
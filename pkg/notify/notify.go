@@ -0,0 +1,234 @@
+// Package notify sends the events of the ingested runs to webhooks
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/analysis"
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// defaultDedupHours is the number of hours an event is not sent again to a webhook by default
+const defaultDedupHours = 24
+
+// Webhook is a subscription of a url to the events of some environments
+type Webhook struct {
+	URL string `json:"url"`
+	// Format is json to post the event, or slack to post a Slack message, json if empty
+	Format string `json:"format"`
+	// Envs are the environments, or path.Match patterns of them, whose events are sent, every environment if empty
+	Envs []string `json:"envs"`
+	// Events are the kinds of the events that are sent, every kind if empty
+	Events []string `json:"events"`
+}
+
+// Config is the webhooks file
+type Config struct {
+	analysis.EventParams
+	// BaseURL is the url of the gopogh-server dashboard the messages link to, no links if empty
+	BaseURL string `json:"baseUrl"`
+	// DedupHours is the number of hours the same event of a test is not sent again to a webhook, counted in memory since the server started
+	DedupHours int       `json:"dedupHours"`
+	Webhooks   []Webhook `json:"webhooks"`
+}
+
+// Notifier sends the events of the runs to the webhooks of the webhooks file
+type Notifier struct {
+	path   string
+	client *http.Client
+
+	mu     sync.Mutex
+	config Config
+	// sent is when each event was last sent, or is being sent, to each webhook, by url and event key
+	sent map[string]time.Time
+}
+
+// New loads the webhooks from the json file at path, for example:
+//
+//	{
+//	  "baseUrl": "https://gopogh.example.com",
+//	  "flakeRate": 20,
+//	  "webhooks": [
+//	    {"url": "https://hooks.slack.com/services/...", "format": "slack", "envs": ["Docker_*"], "events": ["new_failure", "fail_jump"]},
+//	    {"url": "https://example.com/gopogh", "envs": ["KVM_Linux"]}
+//	  ]
+//	}
+//
+// The thresholds not given default to analysis.DefaultEventParams.
+// An empty path configures no webhooks.
+func New(path string) (*Notifier, error) {
+	n := &Notifier{
+		path:   path,
+		client: &http.Client{Timeout: 10 * time.Second},
+		sent:   map[string]time.Time{},
+	}
+	if err := n.Reload(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Reload re-reads the webhooks file, replacing the previous webhooks only if the whole file is valid
+func (n *Notifier) Reload() error {
+	config := Config{EventParams: analysis.DefaultEventParams, DedupHours: defaultDedupHours}
+	if n.path != "" {
+		data, err := os.ReadFile(n.path)
+		if err != nil {
+			return fmt.Errorf("failed to read webhooks file: %v", err)
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("failed to parse webhooks file: %v", err)
+		}
+		for i, h := range config.Webhooks {
+			if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return fmt.Errorf("webhook %d: invalid url %q", i, h.URL)
+			}
+			if h.Format != "" && h.Format != "json" && h.Format != "slack" {
+				return fmt.Errorf("webhook %d: format must be json or slack", i)
+			}
+			for _, env := range h.Envs {
+				if _, err := path.Match(env, ""); err != nil {
+					return fmt.Errorf("webhook %d: invalid env pattern %q", i, env)
+				}
+			}
+			for _, kind := range h.Events {
+				switch kind {
+				case analysis.EventFlakeRate, analysis.EventNewFailure, analysis.EventFailJump:
+				default:
+					return fmt.Errorf("webhook %d: unknown event %q", i, kind)
+				}
+			}
+		}
+	}
+
+	n.mu.Lock()
+	n.config = config
+	n.mu.Unlock()
+	return nil
+}
+
+// Len returns the number of configured webhooks
+func (n *Notifier) Len() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.config.Webhooks)
+}
+
+// Params returns the thresholds of the events
+func (n *Notifier) Params() analysis.EventParams {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.config.EventParams
+}
+
+// subscribed checks whether the webhook wants the event
+func (h Webhook) subscribed(e models.RunEvent) bool {
+	return matchesAny(h.Events, e.Kind, func(kind, value string) bool { return kind == value }) &&
+		matchesAny(h.Envs, e.EnvName, func(pattern, env string) bool {
+			ok, _ := path.Match(pattern, env)
+			return ok
+		})
+}
+
+// matchesAny checks whether the value matches one of the filters, or there are no filters
+func matchesAny(filters []string, value string, match func(filter, value string) bool) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		if match(f, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify posts the events to the webhooks subscribed to them, leaving out the events sent to a webhook in the last DedupHours hours.
+// An event is reserved before it is posted, so the runs notified at the same time do not send it twice, and released if the webhook
+// failed to receive it, so it is sent again with the next runs. The sent events are kept in memory, a restarted server sends them again
+func (n *Notifier) Notify(events []models.RunEvent) {
+	type delivery struct {
+		hook  Webhook
+		event models.RunEvent
+		key   string
+	}
+	var deliveries []delivery
+	n.mu.Lock()
+	config := n.config
+	dedup := time.Duration(config.DedupHours) * time.Hour
+	now := time.Now()
+	for key, t := range n.sent {
+		if now.Sub(t) >= dedup {
+			delete(n.sent, key)
+		}
+	}
+	for _, e := range events {
+		for _, h := range config.Webhooks {
+			if !h.subscribed(e) {
+				continue
+			}
+			key := h.URL + "\x00" + e.Kind + "\x00" + e.EnvName + "\x00" + e.TestName
+			if _, ok := n.sent[key]; ok {
+				continue
+			}
+			n.sent[key] = now
+			deliveries = append(deliveries, delivery{h, e, key})
+		}
+	}
+	n.mu.Unlock()
+
+	for _, d := range deliveries {
+		if err := n.post(d.hook, d.event, config.BaseURL); err != nil {
+			log.Printf("failed to send %s event of %s to webhook %s: %v", d.event.Kind, d.event.EnvName, d.hook.URL, err)
+			n.mu.Lock()
+			if t, ok := n.sent[d.key]; ok && t.Equal(now) {
+				delete(n.sent, d.key)
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// post sends the event to the webhook in its format
+func (n *Notifier) post(h Webhook, e models.RunEvent, baseURL string) error {
+	var payload interface{} = e
+	if h.Format == "slack" {
+		payload = slackMessage(e, baseURL)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(h.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// slackMessage returns the Slack incoming webhook payload of the event, linking to the dashboard of the test or environment
+func slackMessage(e models.RunEvent, baseURL string) map[string]string {
+	text := e.Message
+	if baseURL != "" {
+		q := url.Values{}
+		q.Set("env", e.EnvName)
+		if e.TestName != "" {
+			q.Set("test", e.TestName)
+		}
+		text = fmt.Sprintf("%s <%s/?%s|dashboard>", text, baseURL, q.Encode())
+	}
+	return map[string]string{"text": fmt.Sprintf(":warning: *%s* %s", e.Kind, text)}
+}
@@ -0,0 +1,96 @@
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/analysis"
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+func TestNotifyDedup(t *testing.T) {
+	var mu sync.Mutex
+	posts := 0
+	statuses := []int{http.StatusInternalServerError, http.StatusOK}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		status := http.StatusOK
+		if posts < len(statuses) {
+			status = statuses[posts]
+		}
+		posts++
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(`{"webhooks": [{"url": %q}]}`, srv.URL)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := models.RunEvent{Kind: analysis.EventNewFailure, EnvName: "env", TestName: "TestA", Message: "TestA failed"}
+
+	tests := []struct {
+		name      string
+		wantPosts int
+	}{
+		{name: "the webhook fails", wantPosts: 1},
+		{name: "sent again after the failure", wantPosts: 2},
+		{name: "not sent again once accepted", wantPosts: 2},
+	}
+	for _, tc := range tests {
+		n.Notify([]models.RunEvent{event})
+		mu.Lock()
+		got := posts
+		mu.Unlock()
+		if got != tc.wantPosts {
+			t.Errorf("%s: the webhook received %d posts, want %d", tc.name, got, tc.wantPosts)
+		}
+	}
+}
+
+func TestNotifyConcurrentRuns(t *testing.T) {
+	var mu sync.Mutex
+	deliveries := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// a slow webhook keeps the first delivery in flight while the other runs are notified
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		deliveries++
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(`{"webhooks": [{"url": %q}]}`, srv.URL)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := models.RunEvent{Kind: analysis.EventFailJump, EnvName: "env", Message: "env had 12 failures"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.Notify([]models.RunEvent{event})
+		}()
+	}
+	wg.Wait()
+	if deliveries != 1 {
+		t.Errorf("the webhook received %d deliveries of the event notified by 10 runs at once, want 1", deliveries)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -21,9 +22,14 @@ func ParseJSON(path string) ([]models.TestEvent, error) {
 	defer func() {
 		_ = f.Close()
 	}()
+	return Parse(f)
+}
 
+// Parse is a very forgiving JSON parser that reads go test2json events from r.
+func Parse(r io.Reader) ([]models.TestEvent, error) {
+	var err error
 	events := []models.TestEvent{}
-	scanner := bufio.NewScanner(f)
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		// Go's -json output is line-by-line JSON events
 		b := scanner.Bytes()
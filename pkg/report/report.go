@@ -3,6 +3,7 @@ package report
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"html/template"
 	"math"
@@ -22,25 +23,34 @@ type DisplayContent struct {
 	CreatedOn     time.Time
 	Detail        models.ReportDetail
 	TestTime      time.Time
+	// FlakeHistory is the recent history of the failed tests by name, nil without a database
+	FlakeHistory map[string]models.FlakeHistory
+	// Quarantined is the quarantine entry of the failed tests that are quarantined by name, their failures do not fail the run
+	Quarantined map[string]models.DBQuarantine
+}
+
+// Summary is the short json summary of a report
+type Summary struct {
+	NumberOfTests int
+	NumberOfFail  int
+	NumberOfPass  int
+	NumberOfSkip  int
+	FailedTests   []string
+	PassedTests   []string
+	SkippedTests  []string
+	Durations     map[string]float64
+	TotalDuration float64
+	GopoghVersion string
+	GopoghBuild   string
+	Detail        models.ReportDetail
+	FlakeHistory  map[string]models.FlakeHistory `json:",omitempty"`
+	// Quarantined is the quarantine entry of the failed tests that are quarantined by name, the tests are still in FailedTests
+	Quarantined map[string]models.DBQuarantine `json:",omitempty"`
 }
 
 // ShortSummary returns only test names without logs
 func (c DisplayContent) ShortSummary() ([]byte, error) {
-	type shortSummary struct {
-		NumberOfTests int
-		NumberOfFail  int
-		NumberOfPass  int
-		NumberOfSkip  int
-		FailedTests   []string
-		PassedTests   []string
-		SkippedTests  []string
-		Durations     map[string]float64
-		TotalDuration float64
-		GopoghVersion string
-		GopoghBuild   string
-		Detail        models.ReportDetail
-	}
-	ss := shortSummary{}
+	ss := Summary{}
 	ss.Durations = make(map[string]float64)
 	for _, t := range resultTypes {
 		if t == pass {
@@ -71,11 +81,56 @@ func (c DisplayContent) ShortSummary() ([]byte, error) {
 	ss.NumberOfTests = ss.NumberOfFail + ss.NumberOfPass + ss.NumberOfSkip
 	ss.TotalDuration = c.TotalDuration
 	ss.Detail = c.Detail
+	ss.FlakeHistory = c.FlakeHistory
+	ss.Quarantined = c.Quarantined
 	ss.GopoghVersion = Version()
 	ss.GopoghBuild = Build
 	return json.MarshalIndent(ss, "", "    ")
 }
 
+// FailedTests returns the names of the failed tests, quarantined ones included
+func (c DisplayContent) FailedTests() []string {
+	var names []string
+	for _, g := range c.Results[fail] {
+		names = append(names, g.TestName)
+	}
+	return names
+}
+
+// FailingTests returns the number of failed tests that are not quarantined
+func (c DisplayContent) FailingTests() int {
+	n := 0
+	for _, g := range c.Results[fail] {
+		if _, ok := c.Quarantined[g.TestName]; !ok {
+			n++
+		}
+	}
+	return n
+}
+
+// Sections returns the tests of each section of the html report by result, with the quarantined failures in their own section
+func (c DisplayContent) Sections() map[string][]models.TestGroup {
+	if len(c.Quarantined) == 0 {
+		return c.Results
+	}
+	sections := map[string][]models.TestGroup{}
+	for resultType, groups := range c.Results {
+		if resultType != fail {
+			sections[resultType] = groups
+			continue
+		}
+		sections[fail] = []models.TestGroup{}
+		for _, g := range groups {
+			if _, ok := c.Quarantined[g.TestName]; ok {
+				sections[quarantine] = append(sections[quarantine], g)
+			} else {
+				sections[fail] = append(sections[fail], g)
+			}
+		}
+	}
+	return sections
+}
+
 // HTML returns html format
 func (c DisplayContent) HTML() ([]byte, error) {
 
@@ -105,10 +160,20 @@ func (c DisplayContent) SQL(flagValues db.FlagValues) error {
 	if err != nil {
 		return err
 	}
-	if err := database.Initialize(); err != nil {
+	return c.Store(context.Background(), database)
+}
+
+// Store creates or migrates the tables of the database and adds/updates the rows of the report
+func (c DisplayContent) Store(ctx context.Context, database db.Datab) error {
+	if err := database.Initialize(ctx); err != nil {
 		return err
 	}
+	dbEnvironmentRow, dbTestRows := c.DBRows()
+	return database.Set(ctx, dbEnvironmentRow, dbTestRows)
+}
 
+// DBRows converts the report into an environment row and a row for each test
+func (c DisplayContent) DBRows() (models.DBEnvironmentTest, []models.DBTestCase) {
 	expectedRowNumber := 0
 	for _, g := range c.Results {
 		expectedRowNumber += len(g)
@@ -126,6 +191,9 @@ func (c DisplayContent) SQL(flagValues db.FlagValues) error {
 				TestOrder: test.TestOrder,
 				TestTime:  c.TestTime,
 			}
+			if resultType == fail {
+				r.Signature = FailureSignature(test.Events)
+			}
 			dbTestRows = append(dbTestRows, r)
 		}
 	}
@@ -139,9 +207,42 @@ func (c DisplayContent) SQL(flagValues db.FlagValues) error {
 		NumberOfSkip:  len(c.Results[skip]),
 		TotalDuration: c.TotalDuration,
 		GopoghVersion: c.BuildVersion,
+		Branch:        c.Detail.Branch,
 	}
+	if !c.Detail.CommitTime.IsZero() {
+		commitTime := c.Detail.CommitTime
+		dbEnvironmentRow.CommitTime = &commitTime
+	}
+	return dbEnvironmentRow, dbTestRows
+}
 
-	return database.Set(dbEnvironmentRow, dbTestRows)
+// FromSummary rebuilds a report without logs from a json summary, testTime is used as the start time of the tests
+func FromSummary(ss Summary, testTime time.Time) DisplayContent {
+	toGroups := func(names []string, status string) []models.TestGroup {
+		groups := make([]models.TestGroup, 0, len(names))
+		for i, name := range names {
+			groups = append(groups, models.TestGroup{
+				TestName:  name,
+				TestOrder: i + 1,
+				Status:    status,
+				Duration:  ss.Durations[name],
+			})
+		}
+		return groups
+	}
+	rs := map[string][]models.TestGroup{}
+	rs[pass] = toGroups(ss.PassedTests, pass)
+	rs[fail] = toGroups(ss.FailedTests, fail)
+	rs[skip] = toGroups(ss.SkippedTests, skip)
+	return DisplayContent{
+		Results:       rs,
+		TotalTests:    len(rs[pass]) + len(rs[fail]) + len(rs[skip]),
+		TotalDuration: ss.TotalDuration,
+		BuildVersion:  ss.GopoghVersion + "_" + ss.GopoghBuild,
+		CreatedOn:     time.Now(),
+		Detail:        ss.Detail,
+		TestTime:      testTime,
+	}
 }
 
 // Generate generates a report
@@ -0,0 +1,51 @@
+package report
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// maxSignatureLength is the number of characters a failure signature is truncated to
+const maxSignatureLength = 200
+
+var (
+	// testLogLine matches the lines logged by t.Log, t.Error and t.Fatal, prefixed by the file and line they were logged at
+	testLogLine = regexp.MustCompile(`^\w+\.go:\d+: `)
+	// failureWords matches the words of a line explaining a failure
+	failureWords = regexp.MustCompile(`(?i)\b(error|errors|fail|failed|failure|panic|timed out|timeout|unexpected|expected)\b`)
+	// signatureNumbers matches the hex ids and numbers that differ between failures with the same cause
+	signatureNumbers = regexp.MustCompile(`\b[0-9a-f]{12,}\b|[0-9]+`)
+)
+
+// FailureSignature returns the line of the test output explaining the failure with its numbers masked so failures with the same cause share it,
+// the first failure logged by the test if there is one, otherwise the first line mentioning a failure
+func FailureSignature(events []models.TestEvent) string {
+	var fallback string
+	for _, e := range events {
+		for _, line := range strings.Split(e.Output, "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "=== ") || strings.HasPrefix(line, "--- ") || !failureWords.MatchString(line) {
+				continue
+			}
+			if testLogLine.MatchString(line) {
+				return normalizeSignature(line)
+			}
+			if fallback == "" {
+				fallback = line
+			}
+		}
+	}
+	return normalizeSignature(fallback)
+}
+
+// normalizeSignature masks the numbers of the line and truncates it
+func normalizeSignature(line string) string {
+	line = signatureNumbers.ReplaceAllString(line, "N")
+	line = strings.Join(strings.Fields(line), " ")
+	if r := []rune(line); len(r) > maxSignatureLength {
+		line = string(r[:maxSignatureLength])
+	}
+	return line
+}
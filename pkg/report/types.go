@@ -10,6 +10,8 @@ const (
 	pass = "pass"
 	fail = "fail"
 	skip = "skip"
+	// quarantine is the section of the html report of the quarantined failures, they are stored as failures
+	quarantine = "quarantine"
 )
 
 var resultTypes = [3]string{pass, fail, skip}
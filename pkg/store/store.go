@@ -0,0 +1,113 @@
+// Package store provides storage for the generated gopogh reports
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned when a key is not in the store
+var ErrNotFound = errors.New("not found")
+
+// Blob is the interface of the stores we support for the reports
+type Blob interface {
+	// Put adds/replaces the data at key
+	Put(key string, data []byte) error
+	// Get returns the data at key or ErrNotFound
+	Get(key string) ([]byte, error)
+}
+
+// SuggestionsDir is the directory of the quarantine suggestion reports, which no environment may be named
+const SuggestionsDir = "suggestions"
+
+// ReportKey returns the key of the HTML report of a commit on an environment
+func ReportKey(env, commit string) (string, error) {
+	if env == "" || commit == "" {
+		return "", fmt.Errorf("environment and commit are required")
+	}
+	e, c := url.PathEscape(env), url.PathEscape(commit)
+	if e == "." || e == ".." {
+		return "", fmt.Errorf("invalid environment name: %q", env)
+	}
+	// compared regardless of case for the file systems that are not case sensitive
+	if strings.EqualFold(e, SuggestionsDir) {
+		return "", fmt.Errorf("environment name %q is reserved for the quarantine suggestions", env)
+	}
+	return e + "/" + c + ".html", nil
+}
+
+// Local is a Blob stored as files in a directory of the local disk
+type Local struct {
+	dir string
+}
+
+// NewLocal returns a store keeping its files under dir
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+	return &Local{dir: dir}, nil
+}
+
+// path converts a key into a file path, refusing keys that would escape the directory
+func (l *Local) path(key string) (string, error) {
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("invalid key: %q", key)
+		}
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the data to the file of key
+func (l *Local) Put(key string, data []byte) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	// write to a temporary file of its own first so a concurrent Get never sees a partial report,
+	// and concurrent Puts of the same key never write into the same file
+	f, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, p)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	return nil
+}
+
+// Get reads the file of key
+func (l *Local) Get(key string) ([]byte, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", key, err)
+	}
+	return data, nil
+}
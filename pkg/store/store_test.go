@@ -0,0 +1,89 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestLocalConcurrentPut(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ReportKey("Docker_Linux", "abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reports := make([][]byte, 8)
+	for i := range reports {
+		reports[i] = bytes.Repeat([]byte{byte('a' + i)}, 1<<20)
+	}
+	var wg sync.WaitGroup
+	for _, data := range reports {
+		wg.Add(1)
+		go func(data []byte) {
+			defer wg.Done()
+			if err := l.Put(key, data); err != nil {
+				t.Error(err)
+			}
+		}(data)
+	}
+	wg.Wait()
+
+	got, err := l.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, data := range reports {
+		found = found || bytes.Equal(got, data)
+	}
+	if !found {
+		t.Errorf("Get(%q) returned a report that was not put, %d bytes", key, len(got))
+	}
+
+	p, err := l.path(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("the directory of the report has %v, want only the report", names)
+	}
+}
+
+func TestReportKey(t *testing.T) {
+	tests := []struct {
+		env     string
+		commit  string
+		want    string
+		wantErr bool
+	}{
+		{env: "Docker_Linux", commit: "abc123", want: "Docker_Linux/abc123.html"},
+		{env: "KVM Linux", commit: "a/b", want: "KVM%20Linux/a%2Fb.html"},
+		{env: "", commit: "abc123", wantErr: true},
+		{env: "Docker_Linux", commit: "", wantErr: true},
+		{env: "..", commit: "abc123", wantErr: true},
+		{env: "suggestions", commit: "latest", wantErr: true},
+		{env: "Suggestions", commit: "latest", wantErr: true},
+		{env: "suggestions_linux", commit: "latest", want: "suggestions_linux/latest.html"},
+	}
+	for _, tc := range tests {
+		got, err := ReportKey(tc.env, tc.commit)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("ReportKey(%q, %q) = %q, %v, want %q, error %v", tc.env, tc.commit, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Package upload provides a client for sending test results to gopogh-server
+package upload
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// DefaultTimeout is how long an upload may take by default, so that a hung server does not block the CI job forever
+const DefaultTimeout = 5 * time.Minute
+
+// Events gzips the go test2json output at path and posts it to the ingestion endpoint at uploadURL
+// authenticating with the bearer token if one is given, returning the json summary the server responded with.
+// The upload is canceled with ctx or after timeout, 0 for no limit
+func Events(ctx context.Context, uploadURL, token string, detail models.ReportDetail, path string, timeout time.Duration) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	u, err := url.Parse(uploadURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload url: %v", err)
+	}
+	q := u.Query()
+	q.Set("name", detail.Name)
+	q.Set("details", detail.Details)
+	q.Set("pr", detail.PR)
+	q.Set("repo", detail.RepoName)
+	if detail.Branch != "" {
+		q.Set("branch", detail.Branch)
+	}
+	if !detail.CommitTime.IsZero() {
+		q.Set("commit_time", detail.CommitTime.Format(time.RFC3339))
+	}
+	u.RawQuery = q.Encode()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	pr, pw := io.Pipe()
+	// closing the reader stops the writer if the request did not read the whole body
+	defer func() {
+		_ = pr.Close()
+	}()
+	go func() {
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, f)
+		if cErr := gz.Close(); err == nil {
+			err = cErr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		_ = pr.CloseWithError(err)
+		return nil, fmt.Errorf("failed to upload %s: %v", path, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload response: %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upload failed with status %s: %s", resp.Status, body)
+	}
+	return body, nil
+}
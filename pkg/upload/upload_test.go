@@ -0,0 +1,81 @@
+package upload
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+const testEvents = `{"Action":"pass","Test":"TestA","Elapsed":1}` + "\n"
+
+// writeEvents writes test2json output in a temporary directory and returns its path
+func writeEvents(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "events.json")
+	if err := os.WriteFile(path, []byte(testEvents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer s3cr3t" {
+			t.Errorf("Authorization = %q, want the bearer token", got)
+		}
+		if got := r.URL.Query().Get("name"); got != "env" {
+			t.Errorf("name = %q, want env", got)
+		}
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("the body is not gzipped: %v", err)
+			return
+		}
+		body, err := io.ReadAll(gz)
+		if err != nil || string(body) != testEvents {
+			t.Errorf("body = %q, %v, want the events", body, err)
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"NumberOfPass":1}`))
+	}))
+	defer srv.Close()
+
+	body, err := Events(context.Background(), srv.URL, "s3cr3t", models.ReportDetail{Name: "env", Details: "c1"}, writeEvents(t), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"NumberOfPass":1}` {
+		t.Errorf("Events() = %s, want the response of the server", body)
+	}
+}
+
+func TestEventsTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		// a hung server neither reads the body nor responds
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	start := time.Now()
+	_, err := Events(context.Background(), srv.URL, "", models.ReportDetail{Name: "env", Details: "c1"}, writeEvents(t), 50*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "failed to upload") {
+		t.Errorf("Events() to a hung server error = %v, want a failed upload", err)
+	}
+	if took := time.Since(start); took > 10*time.Second {
+		t.Errorf("Events() to a hung server took %s, want it to time out", took)
+	}
+}